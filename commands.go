@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gagliardetto/codemill/handlers/tainttracking"
+	"github.com/gagliardetto/codemill/handlers/untrustedflowsource"
+	"github.com/gagliardetto/codemill/x"
+	. "github.com/gagliardetto/utilz"
+)
+
+// commands are the subcommands of codemill; each one parses its own
+// flags from the provided args, and returns the exit code.
+var commands = map[string]func(args []string) int{
+	"validate": cmdValidate,
+	"migrate":  cmdMigrate,
+	"diff":     cmdDiff,
+	"merge":    cmdMerge,
+	"import":   cmdImport,
+	"test":     cmdTest,
+	"install":  cmdInstall,
+}
+
+// cmdValidate collects all the problems of a spec file
+// and prints them; the exit code is 1 if any error is found
+// (warnings alone don't fail), so it can be used in pre-commit hooks.
+//
+// Usage: codemill validate --spec=path/to/spec.json [--format=text|json|sarif] [--out=report.json]
+func cmdValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var specFilepath string
+	var format string
+	var outFilepath string
+	fs.StringVar(&specFilepath, "spec", "", "Path to spec file to validate.")
+	fs.StringVar(&format, "format", "text", "Format of the report: text, json, or sarif.")
+	fs.StringVar(&outFilepath, "out", "", "Path to file where to save the report; defaults to stdout.")
+	fs.Parse(args)
+
+	if specFilepath == "" && fs.NArg() > 0 {
+		specFilepath = fs.Arg(0)
+	}
+	if specFilepath == "" {
+		Errorf("--spec flag not provided")
+		return 2
+	}
+
+	report, err := x.DiagnoseSpecFile(specFilepath, LoadPackage)
+	if err != nil {
+		Errorf("error while validating spec: %s", err)
+		return 2
+	}
+
+	var out io.Writer = os.Stdout
+	if outFilepath != "" {
+		file, err := os.Create(outFilepath)
+		if err != nil {
+			Errorf("error while creating report file: %s", err)
+			return 2
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch format {
+	case "text":
+		writeTextReport(out, report)
+	case "json":
+		err = writeIndentedJSON(out, report)
+	case "sarif":
+		err = writeIndentedJSON(out, report.SARIF())
+	default:
+		Errorf("unknown format: %q", format)
+		return 2
+	}
+	if err != nil {
+		Errorf("error while writing report: %s", err)
+		return 2
+	}
+
+	if report.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+// cmdMigrate moves all the selectors of a module to another version,
+// and reports the funcs (and structs, types) that disappeared or changed.
+//
+// Usage: codemill migrate --spec=path/to/spec.json --path=github.com/gin-gonic/gin --from=v1.6.3 --to=v1.7.2 [--dry-run] [--format=text|json]
+func cmdMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var specFilepath string
+	var path string
+	var fromVersion string
+	var toVersion string
+	var dryRun bool
+	var format string
+	fs.StringVar(&specFilepath, "spec", "", "Path to spec file.")
+	fs.StringVar(&path, "path", "", "Path of the module to migrate.")
+	fs.StringVar(&fromVersion, "from", "", "Current version of the module.")
+	fs.StringVar(&toVersion, "to", "", "New version of the module.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only report what would change; don't save the spec.")
+	fs.StringVar(&format, "format", "text", "Format of the report: text, or json.")
+	fs.Parse(args)
+
+	if specFilepath == "" {
+		Errorf("--spec flag not provided")
+		return 2
+	}
+
+	spec, err := x.TryLoadSpecFromFile(specFilepath, LoadPackage)
+	if err != nil {
+		Errorf("error while loading spec: %s", err)
+		return 2
+	}
+
+	report, err := spec.MigrateModule(path, fromVersion, toVersion, LoadPackage, dryRun)
+	if err != nil {
+		Errorf("error while migrating module: %s", err)
+		return 2
+	}
+
+	if !dryRun {
+		spec.RemoveMeta()
+		Infof("Saving spec to %q", MustAbs(specFilepath))
+		if err := x.SaveSpecToFile(spec, specFilepath); err != nil {
+			Errorf("error while saving spec: %s", err)
+			return 2
+		}
+	}
+
+	switch format {
+	case "text":
+		writeTextMigrationReport(os.Stdout, report)
+	case "json":
+		err = writeIndentedJSON(os.Stdout, report)
+	default:
+		Errorf("unknown format: %q", format)
+		return 2
+	}
+	if err != nil {
+		Errorf("error while writing report: %s", err)
+		return 2
+	}
+	return 0
+}
+
+func writeTextMigrationReport(w io.Writer, report *x.MigrationReport) {
+	print := func(label string, entries []*x.MigrationEntry) {
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s: %s.%s: %s %s", label, entry.Model, entry.Method, entry.Kind, entry.ID)
+			if entry.Note != "" {
+				fmt.Fprintf(w, " (%s)", entry.Note)
+			}
+			fmt.Fprintln(w)
+			if entry.OldSignature != entry.NewSignature && entry.OldSignature != "" && entry.NewSignature != "" {
+				fmt.Fprintf(w, "\t- %s\n\t+ %s\n", entry.OldSignature, entry.NewSignature)
+			}
+		}
+	}
+	print("migrated", report.Migrated)
+	print("changed", report.Changed)
+	print("removed", report.Removed)
+	fmt.Fprintf(
+		w,
+		"%s: %s -> %s: %v migrated, %v changed, %v removed\n",
+		report.Path,
+		report.FromVersion,
+		report.ToVersion,
+		len(report.Migrated),
+		len(report.Changed),
+		len(report.Removed),
+	)
+}
+
+// cmdDiff prints the semantic differences between two spec files;
+// the exit code is 1 if the specs differ.
+//
+// Usage: codemill diff [--sources] [--format=text|json] a.json b.json
+func cmdDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	var loadSources bool
+	var format string
+	fs.BoolVar(&loadSources, "sources", false, "Load the sources, to describe positions by parameter name and to show signatures.")
+	fs.StringVar(&format, "format", "text", "Format of the output: text, or json.")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		Errorf("usage: codemill diff [flags] a.json b.json")
+		return 2
+	}
+
+	from, err := x.ReadSpecFile(fs.Arg(0), LoadPackage)
+	if err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+	to, err := x.ReadSpecFile(fs.Arg(1), LoadPackage)
+	if err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+	if loadSources {
+		for _, spec := range []*x.XSpec{from, to} {
+			for _, mod := range spec.ListModules() {
+				if _, err := LoadPackage(mod.Path, mod.Version); err != nil {
+					Errorf("error while loading package %s: %s", mod.PathVersion(), err)
+				}
+			}
+		}
+	}
+
+	changes := x.DiffSpecs(from, to)
+
+	switch format {
+	case "text":
+		writeTextDiff(os.Stdout, changes)
+	case "json":
+		err = writeIndentedJSON(os.Stdout, changes)
+	default:
+		Errorf("unknown format: %q", format)
+		return 2
+	}
+	if err != nil {
+		Errorf("error while writing diff: %s", err)
+		return 2
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeTextDiff(w io.Writer, changes []*x.SpecChange) {
+	for _, change := range changes {
+		var sign string
+		switch change.Op {
+		case x.SpecChangeAdded:
+			sign = "+"
+		case x.SpecChangeRemoved:
+			sign = "-"
+		default:
+			sign = "~"
+		}
+		switch {
+		case change.Model == "":
+			fmt.Fprintf(w, "%s spec\n", sign)
+		case change.Qualifier == nil:
+			fmt.Fprintf(w, "%s model %s\n", sign, change.Model)
+		default:
+			fmt.Fprintf(w, "%s %s.%s: %s %s %s\n", sign, change.Model, change.Method, change.Kind, change.Qualifier.PathVersion(), change.Qualifier.ID)
+		}
+		if change.Signature != "" {
+			fmt.Fprintf(w, "\t%s\n", change.Signature)
+		}
+		for _, detail := range change.Details {
+			fmt.Fprintf(w, "\t%s\n", detail)
+		}
+	}
+}
+
+// cmdMerge does a three-way merge of spec files; the conflicts are printed,
+// and the `ours` version of each conflicting element is kept
+// (the exit code is 1 if there are conflicts).
+// It can be used as a git merge driver:
+//
+//	[merge "codemill"]
+//	    driver = codemill merge --base=%O --ours=%A --theirs=%B
+//
+// Usage: codemill merge --base=base.json --ours=ours.json --theirs=theirs.json [--out=merged.json]
+func cmdMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	var basePath string
+	var oursPath string
+	var theirsPath string
+	var outPath string
+	fs.StringVar(&basePath, "base", "", "Path to the spec file of the common ancestor.")
+	fs.StringVar(&oursPath, "ours", "", "Path to our spec file.")
+	fs.StringVar(&theirsPath, "theirs", "", "Path to their spec file.")
+	fs.StringVar(&outPath, "out", "", "Path where to save the merged spec; defaults to the --ours path.")
+	fs.Parse(args)
+
+	if basePath == "" || oursPath == "" || theirsPath == "" {
+		Errorf("--base, --ours and --theirs flags must be provided")
+		return 2
+	}
+	if outPath == "" {
+		outPath = oursPath
+	}
+
+	var specs []*x.XSpec
+	for _, path := range []string{basePath, oursPath, theirsPath} {
+		spec, err := x.ReadSpecFile(path, LoadPackage)
+		if err != nil {
+			Errorf("%s", err)
+			return 2
+		}
+		specs = append(specs, spec)
+	}
+
+	merged, conflicts := x.MergeSpecs(specs[0], specs[1], specs[2])
+	if err := x.SaveSpecToFile(merged, outPath); err != nil {
+		Errorf("error while saving merged spec: %s", err)
+		return 2
+	}
+
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "conflict: %s\n", conflict)
+	}
+	if len(conflicts) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// cmdImport converts existing codeql models (.qll files) into a spec;
+// the TaintTracking::FunctionModel and UntrustedFlowSource::Range classes
+// are imported, and what cannot be understood is printed as a warning.
+//
+// Usage: codemill import --out=spec.json [--name=Name] [--version=path@version]... file.qll...
+func cmdImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var outPath string
+	var specName string
+	var versions pathVersionsFlag
+	fs.StringVar(&outPath, "out", "", "Path where to save the imported spec (.json, .yaml, or .yml).")
+	fs.StringVar(&specName, "name", "", "Name of the imported spec; defaults to the name of the first file.")
+	fs.Var(&versions, "version", "Version of a package, as path@version (can be repeated); defaults to the latest version.")
+	fs.Parse(args)
+
+	if outPath == "" || fs.NArg() == 0 {
+		Errorf("--out flag and at least one .qll file must be provided")
+		return 2
+	}
+	if specName == "" {
+		specName = ToCamel(strings.TrimSuffix(filepath.Base(fs.Arg(0)), filepath.Ext(fs.Arg(0))))
+	}
+
+	spec := &x.XSpec{
+		Name:    specName,
+		Models:  make([]*x.XModel, 0),
+		RWMutex: &sync.RWMutex{},
+	}
+	importer := x.NewCodeQLImporter(LoadPackage, versions, map[string]x.ModelKind{
+		"TaintTracking::FunctionModel": tainttracking.Kind,
+		"UntrustedFlowSource::Range":   untrustedflowsource.Kind,
+	})
+	for _, filename := range fs.Args() {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			Errorf("error while reading %s: %s", filename, err)
+			return 2
+		}
+		if err := importer.ImportFile(spec, filename, string(src)); err != nil {
+			Errorf("%s", err)
+			return 2
+		}
+	}
+
+	for _, warning := range importer.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if err := spec.AddMeta(); err != nil {
+		Errorf("error while adding meta to spec: %s", err)
+		return 2
+	}
+	if err := x.SaveSpecToFile(spec, outPath); err != nil {
+		Errorf("error while saving spec: %s", err)
+		return 2
+	}
+	Infof("Imported %v model(s) into %s", len(spec.Models), outPath)
+	return 0
+}
+
+// cmdInstall copies the generated assets of a spec into a codeql-go checkout,
+// and adds the import of the codeql module to go.qll (in sorted position);
+// with --remove, the codeql module, its tests and its import are removed.
+// Both are idempotent.
+//
+// Usage: codemill install --assets=path/to/generated --codeql-go=path/to/codeql-go
+//        codemill install --remove --name=SpecName --codeql-go=path/to/codeql-go
+func cmdInstall(args []string) int {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	var assetsDir string
+	var codeqlGoDir string
+	var name string
+	var remove bool
+	fs.StringVar(&assetsDir, "assets", "", "Path to the folder of the generated assets (or to the folder of the spec, to use the latest run).")
+	fs.StringVar(&codeqlGoDir, "codeql-go", os.Getenv("CODEQL_GO"), "Path to the codeql-go checkout; defaults to $CODEQL_GO.")
+	fs.StringVar(&name, "name", "", "Name of the codeql module to remove; defaults to the name of the assets.")
+	fs.BoolVar(&remove, "remove", false, "Remove the codeql module instead of installing it.")
+	fs.Parse(args)
+
+	if codeqlGoDir == "" {
+		Errorf("--codeql-go flag not provided")
+		return 2
+	}
+	layout := &x.CodeQLGoLayout{Root: MustAbs(codeqlGoDir)}
+
+	if !remove || name == "" {
+		if assetsDir == "" {
+			Errorf("--assets flag not provided")
+			return 2
+		}
+		assets, err := x.FindGeneratedAssets(assetsDir)
+		if err != nil {
+			Errorf("%s", err)
+			return 2
+		}
+		if !remove {
+			testsDir, err := assets.CopyInto(layout)
+			if err != nil {
+				Errorf("%s", err)
+				return 2
+			}
+			Infof("Installed %s into %s (tests in %s)", assets.Name, layout.Root, testsDir)
+			return 0
+		}
+		name = assets.Name
+	}
+
+	if err := layout.Uninstall(name); err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+	Infof("Removed %s from %s", name, layout.Root)
+	return 0
+}
+
+// cmdTest copies the generated assets of a spec into a codeql-go checkout,
+// runs the codeql tests with the local codeql CLI, and maps the failures
+// (missing or unexpected results) back to the models and selectors of the spec;
+// the exit code is 1 if any test fails.
+//
+// Usage: codemill test --spec=spec.json --assets=path/to/generated --codeql-go=path/to/codeql-go [--codeql=path/to/codeql]
+func cmdTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	var specFilepath string
+	var assetsDir string
+	var codeqlGoDir string
+	var cliPath string
+	var format string
+	var verbose bool
+	fs.StringVar(&specFilepath, "spec", "", "Path to the spec file of the generated assets.")
+	fs.StringVar(&assetsDir, "assets", "", "Path to the folder of the generated assets (or to the folder of the spec, to use the latest run).")
+	fs.StringVar(&codeqlGoDir, "codeql-go", os.Getenv("CODEQL_GO"), "Path to the codeql-go checkout; defaults to $CODEQL_GO.")
+	fs.StringVar(&cliPath, "codeql", os.Getenv("CODEQL"), "Path to the codeql CLI executable; defaults to $CODEQL, or to codeql in $PATH.")
+	fs.StringVar(&format, "format", "text", "Format of the report: text, or json.")
+	fs.BoolVar(&verbose, "v", false, "Print the output of the codeql CLI.")
+	fs.Parse(args)
+
+	if specFilepath == "" || assetsDir == "" || codeqlGoDir == "" {
+		Errorf("--spec, --assets and --codeql-go flags must be provided")
+		return 2
+	}
+	if cliPath == "" {
+		cliPath = "codeql"
+	}
+	cliPath, err := exec.LookPath(cliPath)
+	if err != nil {
+		Errorf("codeql CLI not found: %s", err)
+		return 2
+	}
+	cliPath = MustAbs(cliPath)
+
+	spec, err := x.TryLoadSpecFromFile(specFilepath, LoadPackage)
+	if err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+	assets, err := x.FindGeneratedAssets(assetsDir)
+	if err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+
+	layout := &x.CodeQLGoLayout{Root: MustAbs(codeqlGoDir)}
+	testsDir, err := assets.CopyInto(layout)
+	if err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+	Infof("Copied %s into %s", assets.Name, layout.Root)
+
+	runner := &x.CodeQLTestRunner{
+		CLIPath: cliPath,
+		SearchPaths: []string{
+			filepath.Dir(cliPath),
+			layout.QLPath(),
+		},
+	}
+	if verbose {
+		runner.Output = os.Stderr
+	}
+	result, err := runner.Run(testsDir)
+	if err != nil {
+		Errorf("%s", err)
+		return 2
+	}
+	result.Attribute(spec, testsDir)
+
+	switch format {
+	case "text":
+		for _, failure := range result.Failures {
+			fmt.Println(failure.Error())
+		}
+		fmt.Printf("%v test(s) passed, %v test(s) failed\n", result.Passed, result.Failed)
+	case "json":
+		if err := writeIndentedJSON(os.Stdout, result); err != nil {
+			Errorf("%s", err)
+			return 2
+		}
+	default:
+		Errorf("unknown format: %q", format)
+		return 2
+	}
+
+	if result.Failed > 0 || len(result.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// pathVersionsFlag is a repeatable flag of path@version values.
+type pathVersionsFlag map[string]string
+
+func (f *pathVersionsFlag) String() string {
+	return Sf("%v", map[string]string(*f))
+}
+
+func (f *pathVersionsFlag) Set(value string) error {
+	i := strings.LastIndex(value, "@")
+	if i <= 0 || i == len(value)-1 {
+		return fmt.Errorf("invalid value %q: must be path@version", value)
+	}
+	if *f == nil {
+		*f = make(pathVersionsFlag)
+	}
+	(*f)[value[:i]] = value[i+1:]
+	return nil
+}
+
+func writeTextReport(w io.Writer, report *x.DiagnosticReport) {
+	for _, diag := range report.Diagnostics {
+		location := report.File
+		if diag.Line > 0 {
+			location = Sf("%s:%v:%v", location, diag.Line, diag.Column)
+		}
+		fmt.Fprintf(w, "%s: %s: %s [%s] (%s)\n", location, diag.Severity, diag.Message, diag.Code, diag.Pointer)
+	}
+	fmt.Fprintf(w, "%v error(s), %v warning(s)\n", report.Errors, report.Warnings)
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
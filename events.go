@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gagliardetto/codemill/x"
+	. "github.com/gagliardetto/utilz"
+	"github.com/gin-gonic/gin"
+)
+
+// specEditMu serializes the edits of the specs (and the switches of the active spec),
+// so that the revision checked with If-Match is the one that gets modified.
+var specEditMu = &sync.Mutex{}
+
+// specEvent is pushed to the browsers (see /api/events)
+// when a spec is modified, or the active spec is switched.
+type specEvent struct {
+	Name string
+	Data M
+}
+
+// eventBroker fans out the events to the subscribers.
+type eventBroker struct {
+	mu          *sync.Mutex
+	subscribers map[chan *specEvent]struct{}
+}
+
+var specEvents = &eventBroker{
+	mu:          &sync.Mutex{},
+	subscribers: make(map[chan *specEvent]struct{}),
+}
+
+// Subscribe returns a channel of the events, and the func to call when done.
+func (broker *eventBroker) Subscribe() (<-chan *specEvent, func()) {
+	ch := make(chan *specEvent, 16)
+	broker.mu.Lock()
+	broker.subscribers[ch] = struct{}{}
+	broker.mu.Unlock()
+	return ch, func() {
+		broker.mu.Lock()
+		delete(broker.subscribers, ch)
+		broker.mu.Unlock()
+	}
+}
+
+// Publish sends the event to the subscribers;
+// a subscriber that is not keeping up misses it.
+func (broker *eventBroker) Publish(name string, data M) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for ch := range broker.subscribers {
+		select {
+		case ch <- &specEvent{Name: name, Data: data}:
+		default:
+			Warnf("Event %q dropped for a slow subscriber", name)
+		}
+	}
+}
+
+// publishSpecRevision notifies the browsers that the spec was modified.
+func publishSpecRevision(entry *x.WorkspaceEntry) {
+	specEvents.Publish("spec", M{
+		"file": entry.File,
+		"etag": entry.Spec().ETag(),
+	})
+}
+
+// isSpecEdit returns true if the request modifies a spec (see requestEntry).
+func isSpecEdit(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return false
+	}
+	return req.URL.Path == "/api/spec" || strings.HasPrefix(req.URL.Path, "/api/spec/")
+}
+
+// specRevisionMiddleware handles the revisions of the edits of the specs:
+// an edit with an If-Match header that does not match the current revision
+// is rejected with 412; a successful edit bumps the revision, which
+// is returned in the ETag header, and pushed to the browsers.
+func specRevisionMiddleware(c *gin.Context) {
+	if !isSpecEdit(c.Request) {
+		c.Next()
+		return
+	}
+	specEditMu.Lock()
+	defer specEditMu.Unlock()
+
+	entry := requestEntry(c)
+	if entry == nil {
+		return
+	}
+	if match := c.GetHeader("If-Match"); match != "" && !x.ETagMatches(match, entry.Spec().ETag()) {
+		c.Header("ETag", entry.Spec().ETag())
+		abort(c, http.StatusPreconditionFailed, "The spec was modified by someone else; reload it to see the changes")
+		return
+	}
+
+	writer := &revisionWriter{
+		ResponseWriter: c.Writer,
+		entry:          entry,
+	}
+	c.Writer = writer
+	c.Next()
+	// The handler might not have written a body:
+	writer.beforeWrite()
+}
+
+// revisionWriter bumps the revision of the spec right before the response
+// of a successful edit is written (i.e. while the handler still holds
+// the lock of the spec), so that the ETag matches the returned spec.
+type revisionWriter struct {
+	gin.ResponseWriter
+	entry *x.WorkspaceEntry
+	done  bool
+}
+
+func (w *revisionWriter) beforeWrite() {
+	if w.done {
+		return
+	}
+	w.done = true
+	if w.Status() >= 400 {
+		return
+	}
+	w.entry.Spec().BumpRevision()
+	w.Header().Set("ETag", w.entry.Spec().ETag())
+	publishSpecRevision(w.entry)
+}
+
+func (w *revisionWriter) WriteHeaderNow() {
+	w.beforeWrite()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *revisionWriter) Write(data []byte) (int, error) {
+	w.beforeWrite()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *revisionWriter) WriteString(s string) (int, error) {
+	w.beforeWrite()
+	return w.ResponseWriter.WriteString(s)
+}
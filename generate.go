@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/codemill/x"
+	cqljen "github.com/gagliardetto/cqlgen/jen"
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// generationMu serializes the generations (e.g. of concurrent previews),
+// because the generators modify the parsed packages, that are cached and shared.
+var generationMu = &sync.Mutex{}
+
+// generateCodeQL generates the codeql module of the spec
+// with the handlers of the models.
+func generateCodeQL(spec *x.XSpec, multiversion bool) (*cqljen.File, error) {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+
+	cqlFile := cqljen.NewFile()
+	for _, hdr := range x.CqlFormatHeaderDoc(spec.ListModules()) {
+		cqlFile.HeaderDoc(hdr)
+	}
+
+	// `go` is always imported:
+	cqlFile.Import("go")
+
+	var genErr error
+	cqlFile.Doc(x.CqlFormatHeaderDoc(spec.ListModules())...)
+	cqlFile.Private().Module().Id(feparser.FormatCodeQlName(spec.Name)).BlockFunc(func(moduleGroup *cqljen.Group) {
+		// The paths of the packages are formatted by the handlers
+		// with the `packagePath()` predicate of their module:
+		ctx := &x.CodeQLContext{
+			ImportAdder:  cqlFile,
+			PackagePaths: x.NewCqlPackagePaths(spec.ListModules()),
+		}
+
+		for _, mdl := range spec.Models {
+
+			handler := x.Router().GetHandler(mdl.Kind)
+			if handler == nil {
+				genErr = fmt.Errorf("handler not found for kind %s (model %q)", mdl.Kind, mdl.Name)
+				return
+			}
+			{
+				// The codeql packages are matched by path (and not by version),
+				// so the selections of multiple versions are unioned:
+				cqlMdl := mdl
+				if multiversion {
+					cqlMdl, _ = x.UnionVersions(mdl)
+				}
+				// Generate codeql with the handler of the ModelKind;
+				// the handler might generate predicates, classes, etc.
+				// all within the module block.
+				err := handler.GenerateCodeQL(ctx, cqlMdl, moduleGroup)
+				if err != nil {
+					genErr = fmt.Errorf(
+						"error while generating codeql code for model %q (kind=%s): %s",
+						mdl.Name,
+						mdl.Kind,
+						err,
+					)
+					return
+				}
+			}
+
+		}
+
+		// Add the predicates used by the handlers:
+		ctx.PackagePaths.Generate(moduleGroup)
+	})
+	if genErr != nil {
+		return nil, genErr
+	}
+	return cqlFile, nil
+}
+
+// generateGo generates the go tests of the models of the spec
+// in the provided OutputFS (e.g. a folder, or a x.VirtualFS);
+// the errors of all the models are collected in the returned error.
+func generateGo(out x.OutputFS, spec *x.XSpec) error {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+
+	errs := make([]string, 0)
+	for _, mdl := range spec.Models {
+
+		handler := x.Router().GetHandler(mdl.Kind)
+		if handler == nil {
+			errs = append(errs, Sf("handler not found for kind %s (model %q)", mdl.Kind, mdl.Name))
+			continue
+		}
+		{
+			err := handler.GenerateGo(out, mdl)
+			if err != nil {
+				errs = append(errs, Sf(
+					"error while generating Go code for model %q (kind=%s): %s",
+					mdl.Name,
+					mdl.Kind,
+					err,
+				))
+			}
+		}
+
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// generateModelsAsData generates the models-as-data rows of the models of the spec;
+// the errors of all the models (e.g. their selections that cannot be expressed
+// as models-as-data) are collected in the returned error.
+func generateModelsAsData(spec *x.XSpec, multiversion bool) ([]*x.MaDRow, error) {
+	rows := make([]*x.MaDRow, 0)
+	errs := make([]string, 0)
+	for _, mdl := range spec.Models {
+
+		generator, ok := x.Router().GetHandler(mdl.Kind).(x.ModelsAsDataGenerator)
+		if !ok {
+			errs = append(errs, Sf("model %q (kind=%s) cannot be expressed as models-as-data", mdl.Name, mdl.Kind))
+			continue
+		}
+		// The models-as-data packages are matched by path (and not by version):
+		madMdl := mdl
+		if multiversion {
+			madMdl, _ = x.UnionVersions(mdl)
+		}
+		mdlRows, err := generator.GenerateModelsAsData(madMdl)
+		if err != nil {
+			errs = append(errs, Sf(
+				"error while generating models-as-data for model %q (kind=%s): %s",
+				mdl.Name,
+				mdl.Kind,
+				err,
+			))
+			continue
+		}
+		rows = append(rows, mdlRows...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+	return rows, nil
+}
+
+// generateAssets validates the models of the spec, and generates all the assets
+// (codeql, models-as-data, go tests) in a new folder for this run, inside the
+// folder of the spec in outDir; it returns the path of the folder of this run.
+func generateAssets(spec *x.XSpec, outDir string, multiversion bool, outputs map[string]bool) (string, error) {
+	{
+		// Validate all specs:
+		for _, mdl := range spec.Models {
+
+			handler := x.Router().GetHandler(mdl.Kind)
+			if handler == nil {
+				return "", fmt.Errorf(
+					"handler not found for kind %s",
+					mdl.Kind,
+				)
+			}
+
+			{
+				// Validate provided model:
+				err := handler.Validate(mdl)
+				if err != nil {
+					return "", fmt.Errorf(
+						"error while validating model %q (kind=%s): %s",
+						mdl.Name,
+						mdl.Kind,
+						err,
+					)
+				}
+			}
+			{
+				// The generators would fail on selections not supported by the kind:
+				if err := x.CheckModelCapabilities(mdl); err != nil {
+					return "", fmt.Errorf(
+						"model %q (kind=%s) has a selection not supported by its kind: %s",
+						mdl.Name,
+						mdl.Kind,
+						err,
+					)
+				}
+			}
+			// Without multiversion, the models that select multiple versions of the same
+			// package are generated as before (one test directory per version):
+			if multiversion && x.HasMultiversion(mdl.ListModules()) {
+				_, conflicts := x.UnionVersions(mdl)
+				for _, conflict := range conflicts {
+					Errorf("%s", conflict)
+				}
+				if len(conflicts) > 0 {
+					return "", fmt.Errorf(
+						"model %q has %v conflicts across versions of the same package",
+						mdl.Name,
+						len(conflicts),
+					)
+				}
+			}
+		}
+	}
+
+	// Generate the codeql and the models-as-data before writing any asset,
+	// so that a failure doesn't leave a partial run folder:
+	var cqlFile *cqljen.File
+	if outputs["qll"] {
+		generated, err := generateCodeQL(spec, multiversion)
+		if err != nil {
+			return "", err
+		}
+		cqlFile = generated
+	}
+	var madRows []*x.MaDRow
+	if outputs["mad-yaml"] || outputs["mad-csv"] {
+		rows, err := generateModelsAsData(spec, multiversion)
+		if err != nil {
+			return "", err
+		}
+		madRows = rows
+	}
+
+	// Create the folder of the spec for the generated assets (if it doesn't exist):
+	packageAssetFolderName := feparser.FormatCodeQlName(spec.Name)
+	packageAssetFolderPath := path.Join(outDir, packageAssetFolderName)
+	if err := os.MkdirAll(packageAssetFolderPath, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error while creating assets folder: %s", err)
+	}
+	// Create a new folder for the assets generated during this run:
+	ts := time.Now()
+	thisRunAssetFolderName := feparser.FormatCodeQlName(spec.Name) + "_" + ts.Format(FilenameTimeFormat)
+	thisRunAssetFolderPath := path.Join(packageAssetFolderPath, thisRunAssetFolderName)
+	for i := 2; ; i++ {
+		err := os.Mkdir(thisRunAssetFolderPath, os.ModePerm)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("error while creating assets folder: %s", err)
+		}
+		// More than one run in the same second (e.g. from the UI):
+		thisRunAssetFolderPath = path.Join(packageAssetFolderPath, Sf("%s_%v", thisRunAssetFolderName, i))
+	}
+
+	if outputs["qll"] {
+		// Save codeql assets:
+		assetFileName := feparser.FormatCodeQlName(spec.Name) + ".qll"
+		assetFilepath := path.Join(thisRunAssetFolderPath, assetFileName)
+
+		// Write generated codeql to file:
+		Infof("Saving codeql assets to %q", MustAbs(assetFilepath))
+		err := writeFileWith(assetFilepath, func(w io.Writer) error {
+			return cqlFile.Render(w)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if outputs["mad-yaml"] || outputs["mad-csv"] {
+		// Save models-as-data:
+		baseName := feparser.FormatCodeQlName(spec.Name)
+		if outputs["mad-yaml"] {
+			assetFilepath := path.Join(thisRunAssetFolderPath, baseName+".model.yml")
+			Infof("Saving models-as-data to %q", MustAbs(assetFilepath))
+			err := writeFileWith(assetFilepath, func(w io.Writer) error {
+				return x.RenderMaDYAML(w, madRows)
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+		if outputs["mad-csv"] {
+			for _, extensible := range x.ListMaDExtensibles(madRows) {
+				assetFilepath := path.Join(thisRunAssetFolderPath, Sf("%s.%s.csv", baseName, extensible))
+				Infof("Saving models-as-data to %q", MustAbs(assetFilepath))
+				err := writeFileWith(assetFilepath, func(w io.Writer) error {
+					return x.RenderMaDCSV(w, extensible, madRows)
+				})
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	{
+		goTestsFolderPath := path.Join(thisRunAssetFolderPath, "tests")
+		// Create a folder for Go code:
+		if err := os.Mkdir(goTestsFolderPath, os.ModePerm); err != nil {
+			return "", fmt.Errorf("error while creating tests folder: %s", err)
+		}
+		// Generate Go code:
+		if err := generateGo(x.DirFS(goTestsFolderPath), spec); err != nil {
+			return "", err
+		}
+	}
+	return thisRunAssetFolderPath, nil
+}
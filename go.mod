@@ -28,4 +28,5 @@ require (
 	golang.org/x/tools v0.0.0-20201017001424-6003fad69a88
 	google.golang.org/protobuf v1.25.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -8,7 +8,9 @@ import (
 	. "github.com/gagliardetto/utilz"
 )
 
-func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootModuleGroup *Group) error {
+func (han *Handler) GenerateCodeQL(ctx *x.CodeQLContext, mdl *x.XModel, rootModuleGroup *Group) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -31,25 +33,25 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 	{
 		// Add imports:
-		//impAdder.Import("DataFlow::PathGraph")
+		//ctx.Import("DataFlow::PathGraph")
 	}
 
 	className := mdl.Name
 	allPathVersions := mdl.ListAllPathVersions()
 
-	_, b2tmKey, b2itmKey, err := x.GroupFuncSelectors(methodWriteHeaderKey)
+	b2feKey, b2tmKey, b2itmKey, err := x.GroupFuncSelectors(methodWriteHeaderKey)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
-	_, b2tmVal, b2itmVal, err := x.GroupFuncSelectors(methodWriteHeaderVal)
+	b2feVal, b2tmVal, b2itmVal, err := x.GroupFuncSelectors(methodWriteHeaderVal)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	{
-		addedCount := 0
-		funcModelsClassName := feparser.NewCodeQlName(className)
 		for _, pathVersion := range allPathVersions {
+			addedCount := 0
+			funcModelsClassName := x.CqlFormatModelClassName(className, pathVersion)
 			tmp := DoGroup(func(tempFuncsModel *Group) {
 				tempFuncsModel.Doc(Sf("Models a HTTP header writer model for package: %s", pathVersion))
 				tempFuncsModel.Private().Class().Id(funcModelsClassName).Extends().List(
@@ -57,6 +59,8 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 					Id("DataFlow::CallNode"),
 				).BlockFunc(
 					func(funcModelsClassGroup *Group) {
+						funcModelsClassGroup.Id("DataFlow::Node").Id("keyNode").Semicolon().Line()
+						funcModelsClassGroup.Id("DataFlow::Node").Id("valNode").Semicolon().Line()
 
 						funcModelsClassGroup.Id(funcModelsClassName).Call().BlockFunc(
 							func(funcModelsSelfMethodGroup *Group) {
@@ -64,7 +68,52 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 									funcModelsSelfMethodGroup.DoGroup(
 										func(groupCase *Group) {
 											pathCodez := make([]Code, 0)
+											path, _ := scanner.SplitPathVersion(pathVersion)
+
+											// Functions:
+											{
+												cont, ok := b2feKey[pathVersion]
+												if ok {
+													for _, keyFuncQual := range cont {
+														if AllFalse(keyFuncQual.Pos...) {
+															continue
+														}
+														fn := GetFunc(keyFuncQual)
+														thing := fn.(*feparser.FEFunc)
+
+														valFuncQual := b2feVal[pathVersion].ByBasicQualifier(keyFuncQual.BasicQualifier)
+														if valFuncQual == nil {
+															x.Failf("No %s selector found for func %q", MethodWriteHeaderVal, keyFuncQual.ID)
+														}
+
+														pathCodez = append(pathCodez,
+															ParensFunc(
+																func(par *Group) {
+																	par.Commentf("signature: %s", thing.Signature)
+																	par.This().
+																		Dot("getTarget").Call().
+																		Dot("hasQualifiedName").Call(
+																		ctx.FormatPackagePath(path),
+																		Lit(thing.Name),
+																	)
+
+																	par.And()
+
+																	{
+																		_, code := GetFuncQualifierCodeElements(keyFuncQual)
+																		par.Id("keyNode").Eq().Add(code).And()
+																	}
 
+																	{
+																		_, code := GetFuncQualifierCodeElements(valFuncQual)
+																		par.Id("valNode").Eq().Add(code)
+																	}
+																},
+															),
+														)
+													}
+												}
+											}
 											// Type methods:
 											{
 												b2tmKey.IterValid(pathVersion,
@@ -73,12 +122,12 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 															qual := methodQualifiers[0]
 															source := x.GetCachedSource(qual.Path, qual.Version)
 															if source == nil {
-																Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+																x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 															}
 															// Find receiver type:
 															typ := x.FindTypeByID(source, receiverTypeID)
 															if typ == nil {
-																Fatalf("Type not found: %q", receiverTypeID)
+																x.Failf("Type not found: %q", receiverTypeID)
 															}
 
 															mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -113,10 +162,8 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 																							gr.Id("Method").Id("m")
 																						}),
 																						DoGroup(func(gr *Group) {
-																							path, _ := scanner.SplitPathVersion(pathVersion)
-
 																							gr.Id("m").Dot("hasQualifiedName").Call(
-																								x.CqlFormatPackagePath(path),
+																								ctx.FormatPackagePath(path),
 																								Lit(thing.Receiver.TypeName),
 																								Lit(thing.Func.Name),
 																							)
@@ -124,22 +171,16 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 																						nil,
 																					).Dot("getACall").Call()
 
+																				par.And()
+
 																				{
 																					_, code := GetFuncQualifierCodeElements(keyMethodQual)
-
-																					funcModelsClassGroup.Override().Id("DataFlow::Node").Id("getName").Call().BlockFunc(
-																						func(overrideBlockGroup *Group) {
-																							overrideBlockGroup.Id("result").Eq().Add(code)
-																						})
+																					par.Id("keyNode").Eq().Add(code).And()
 																				}
 
 																				{
 																					_, code := GetFuncQualifierCodeElements(valMethodQual)
-
-																					funcModelsClassGroup.Override().Id("DataFlow::Node").Id("getValue").Call().BlockFunc(
-																						func(overrideBlockGroup *Group) {
-																							overrideBlockGroup.Id("result").Eq().Add(code)
-																						})
+																					par.Id("valNode").Eq().Add(code)
 																				}
 																			},
 																		)
@@ -160,12 +201,12 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 															qual := methodQualifiers[0]
 															source := x.GetCachedSource(qual.Path, qual.Version)
 															if source == nil {
-																Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+																x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 															}
 															// Find receiver type:
 															typ := x.FindTypeByID(source, receiverTypeID)
 															if typ == nil {
-																Fatalf("Type not found: %q", receiverTypeID)
+																x.Failf("Type not found: %q", receiverTypeID)
 															}
 															mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
 
@@ -200,7 +241,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 																						}),
 																						DoGroup(func(gr *Group) {
 																							gr.Id("m").Dot("implements").Call(
-																								Id("package"),
+																								ctx.FormatPackagePath(path),
 																								Lit(thing.Receiver.TypeName),
 																								Lit(thing.Func.Name),
 																							)
@@ -212,7 +253,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 																				{
 																					_, code := GetFuncQualifierCodeElements(keyMethodQual)
-																					par.Id("urlNode").Eq().Add(code).And()
+																					par.Id("keyNode").Eq().Add(code).And()
 																				}
 
 																				{
@@ -232,10 +273,6 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 											}
 
 											if len(pathCodez) > 0 {
-												if addedCount > 0 {
-													groupCase.Or()
-												}
-
 												groupCase.Parens(
 													Join(
 														Or(),
@@ -249,6 +286,16 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 								}
 							})
 
+						funcModelsClassGroup.Override().Id("DataFlow::Node").Id("getName").Call().BlockFunc(
+							func(overrideBlockGroup *Group) {
+								overrideBlockGroup.Id("result").Eq().Id("keyNode")
+							})
+
+						funcModelsClassGroup.Override().Id("DataFlow::Node").Id("getValue").Call().BlockFunc(
+							func(overrideBlockGroup *Group) {
+								overrideBlockGroup.Id("result").Eq().Id("valNode")
+							})
+
 						funcModelsClassGroup.Override().Id("HTTP::ResponseWriter").Id("getResponseWriter").Call().BlockFunc(
 							func(overrideBlockGroup *Group) {
 								overrideBlockGroup.None()
@@ -268,12 +315,12 @@ func GetFunc(qual *x.FuncQualifier) x.FuncInterface {
 
 	source := x.GetCachedSource(qual.Path, qual.Version)
 	if source == nil {
-		Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+		x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 	}
 	// Find the func/type-method/interface-method:
 	fn := x.FindFuncByID(source, qual.ID)
 	if fn == nil {
-		Fatalf("Func not found: %q", qual.ID)
+		x.Failf("Func not found: %q", qual.ID)
 	}
 
 	return fn
@@ -1,8 +1,8 @@
 package headerwrite
 
 import (
+	"fmt"
 	"go/types"
-	"os"
 	"path/filepath"
 
 	. "github.com/dave/jennifer/jen"
@@ -87,7 +87,9 @@ var (
 	IncludeCommentsInGeneratedGo bool
 )
 
-func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
+func (han *Handler) GenerateGo(out x.OutputFS, mdl *x.XModel) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -100,15 +102,17 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 	// Check if there are multiple versions of a same package:
 	mods := mdl.ListModules()
 	if x.HasMultiversion(mods) {
-		Ln(RedBG("Has multiversion"))
+		Infof("Model %q has multiple versions of the same package; generating one test directory per version.", mdl.Name)
 	}
 	// If there are no multiple versions of the same module,
 	// that means we can save all the code to one file.
 	allInOneFile := !x.HasMultiversion(mods)
 
 	// Create the directory for the tests for this model:
-	outDir := filepath.Join(parentDir, feparser.NewCodeQlName(mdl.Name))
-	MustCreateFolderIfNotExists(outDir, os.ModePerm)
+	outDir := feparser.NewCodeQlName(mdl.Name)
+	if err := out.MkdirAll(outDir); err != nil {
+		return err
+	}
 
 	// Assuming the validation has already been done:
 	MethodWriteHeaderKey := mdl.Methods.ByName(MethodWriteHeaderKey)
@@ -134,15 +138,63 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 		}
 		codez := make([]Code, 0)
 
-		_, b2tmKey, b2itmKey, err := x.GroupFuncSelectors(MethodWriteHeaderKey)
+		b2feKey, b2tmKey, b2itmKey, err := x.GroupFuncSelectors(MethodWriteHeaderKey)
 		if err != nil {
-			Fatalf("Error while GroupFuncSelectors: %s", err)
+			x.Failf("Error while GroupFuncSelectors: %s", err)
 		}
-		_, b2tmVal, b2itmVal, err := x.GroupFuncSelectors(MethodWriteHeaderVal)
+		b2feVal, b2tmVal, b2itmVal, err := x.GroupFuncSelectors(MethodWriteHeaderVal)
 		if err != nil {
-			Fatalf("Error while GroupFuncSelectors: %s", err)
+			x.Failf("Error while GroupFuncSelectors: %s", err)
+		}
+
+		{
+			cont, ok := b2feKey[pathVersion]
+			if ok && x.HasValidPos(cont...) {
+				addedCount := 0
+				code := BlockFunc(
+					func(groupCase *Group) {
+
+						for _, keyFuncQual := range cont {
+							fn := x.GetFuncByQualifier(keyFuncQual)
+							thing := fn.(*feparser.FEFunc)
+
+							x.AddImportsFromFunc(file, thing)
+
+							{
+								if AllFalse(keyFuncQual.Pos...) {
+									continue
+								}
+								valFuncQual := b2feVal[pathVersion].ByBasicQualifier(keyFuncQual.BasicQualifier)
+								if valFuncQual == nil {
+									x.Failf("No %s selector found for func %q", MethodWriteHeaderVal.Name, keyFuncQual.ID)
+								}
+								groupCase.Comment(thing.Signature)
+
+								blocksOfCases := generateGoTestBlock_Func(
+									file,
+									thing,
+									keyFuncQual,
+									valFuncQual,
+								)
+								if len(blocksOfCases) == 1 {
+									groupCase.Add(blocksOfCases...)
+								} else {
+									groupCase.Block(blocksOfCases...)
+								}
+								addedCount++
+							}
+
+						}
+					})
+				if addedCount > 0 {
+					codez = append(codez,
+						Comment("Header write via function call.").
+							Line().
+							Add(code),
+					)
+				}
+			}
 		}
-		// TODO: consider also header writes done with a function?
 
 		{
 			codezTypeMethods := make([]Code, 0)
@@ -153,7 +205,7 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 					// Find receiver type:
 					typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -216,7 +268,7 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 					// Find receiver type:
 					typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -276,47 +328,53 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 		}
 
 		if !allInOneFile {
-			file.PackageComment("//go:generate depstubber --vendor --auto")
-
 			pkgDstDirpath := filepath.Join(outDir, feparser.FormatID("Model", mdl.Name, "For", feparser.FormatCodeQlName(pathVersion)))
-			MustCreateFolderIfNotExists(pkgDstDirpath, os.ModePerm)
+			if err := out.MkdirAll(pkgDstDirpath); err != nil {
+				return err
+			}
 
 			assetFileName := feparser.FormatID("Model", mdl.Name, "For", feparser.FormatCodeQlName(pathVersion)) + ".go"
-			if err := x.SaveGoFile(pkgDstDirpath, assetFileName, file); err != nil {
-				Fatalf("Error while saving go file: %s", err)
+			if err := x.TypeCheckGoTest(file, mdl, pathVersion); err != nil {
+				return err
+			}
+			if err := x.SaveGoFile(out, pkgDstDirpath, assetFileName, file); err != nil {
+				return fmt.Errorf("error while saving go file: %s", err)
 			}
 
-			if err := x.WriteGoModFile(pkgDstDirpath, pathVersion); err != nil {
-				Fatalf("Error while saving go.mod file: %s", err)
+			if err := x.WriteVendoredGoModule(out, pkgDstDirpath, mdl, pathVersion); err != nil {
+				return fmt.Errorf("error while saving go.mod file and vendor stubs: %s", err)
 			}
-			if err := x.WriteCodeQLTestQuery(pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
-				Fatalf("Error while saving <name>.ql file: %s", err)
+			if err := x.WriteCodeQLTestQuery(out, pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
+				return fmt.Errorf("error while saving <name>.ql file: %s", err)
 			}
-			if err := x.WriteEmptyCodeQLDotExpectedFile(pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
-				Fatalf("Error while saving <name>.expected file: %s", err)
+			if err := x.WriteEmptyCodeQLDotExpectedFile(out, pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
+				return fmt.Errorf("error while saving <name>.expected file: %s", err)
 			}
 		}
 	}
 
 	if allInOneFile {
-		file.PackageComment("//go:generate depstubber --vendor --auto")
-
 		pkgDstDirpath := outDir
-		MustCreateFolderIfNotExists(pkgDstDirpath, os.ModePerm)
+		if err := out.MkdirAll(pkgDstDirpath); err != nil {
+			return err
+		}
 
 		assetFileName := feparser.FormatID("Model", mdl.Name) + ".go"
-		if err := x.SaveGoFile(pkgDstDirpath, assetFileName, file); err != nil {
-			Fatalf("Error while saving go file: %s", err)
+		if err := x.TypeCheckGoTest(file, mdl, allPathVersions...); err != nil {
+			return err
+		}
+		if err := x.SaveGoFile(out, pkgDstDirpath, assetFileName, file); err != nil {
+			return fmt.Errorf("error while saving go file: %s", err)
 		}
 
-		if err := x.WriteGoModFile(pkgDstDirpath, allPathVersions...); err != nil {
-			Fatalf("Error while saving go.mod file: %s", err)
+		if err := x.WriteVendoredGoModule(out, pkgDstDirpath, mdl, allPathVersions...); err != nil {
+			return fmt.Errorf("error while saving go.mod file and vendor stubs: %s", err)
 		}
-		if err := x.WriteCodeQLTestQuery(pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
-			Fatalf("Error while saving <name>.ql file: %s", err)
+		if err := x.WriteCodeQLTestQuery(out, pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
+			return fmt.Errorf("error while saving <name>.ql file: %s", err)
 		}
-		if err := x.WriteEmptyCodeQLDotExpectedFile(pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
-			Fatalf("Error while saving <name>.expected file: %s", err)
+		if err := x.WriteEmptyCodeQLDotExpectedFile(out, pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
+			return fmt.Errorf("error while saving <name>.expected file: %s", err)
 		}
 	}
 	return nil
@@ -336,6 +394,40 @@ func newStatement() *Statement {
 	return &Statement{}
 }
 
+func generateGoTestBlock_Func(
+	file *File,
+	fe *feparser.FEFunc,
+	qualHeaderKey *x.FuncQualifier,
+	qualHeaderVal *x.FuncQualifier,
+) []Code {
+	childBlocks := make([]Code, 0)
+
+	headerKeyIndexes := x.MustPosToRelativeParamIndexes(fe, qualHeaderKey.Pos)
+	if len(headerKeyIndexes) != 1 {
+		x.Failf("headerKeyIndexes len is not 1: %v", qualHeaderKey)
+	}
+	headerValIndexes := x.MustPosToRelativeParamIndexes(fe, qualHeaderVal.Pos)
+	if len(headerValIndexes) != 1 {
+		x.Failf("headerValIndexes len is not 1: %v", qualHeaderVal)
+	}
+
+	childBlock := generate_Func(
+		file,
+		fe,
+		headerKeyIndexes[0],
+		headerValIndexes[0],
+	)
+	{
+		if childBlock != nil {
+			childBlocks = append(childBlocks, childBlock)
+		} else {
+			Warnf(Sf("NOTHING GENERATED; qualHeaderKey %v, qualHeaderVal %v", qualHeaderKey.Pos, qualHeaderVal.Pos))
+		}
+	}
+
+	return childBlocks
+}
+
 func generateGoTestBlock_Method(
 	file *File,
 	fe *feparser.FETypeMethod,
@@ -346,11 +438,11 @@ func generateGoTestBlock_Method(
 
 	headerKeyIndexes := x.MustPosToRelativeParamIndexes(fe, qualHeaderKey.Pos)
 	if len(headerKeyIndexes) != 1 {
-		Fatalf("headerKeyIndexes len is not 1: %v", qualHeaderKey)
+		x.Failf("headerKeyIndexes len is not 1: %v", qualHeaderKey)
 	}
 	headerValIndexes := x.MustPosToRelativeParamIndexes(fe, qualHeaderVal.Pos)
 	if len(headerValIndexes) != 1 {
-		Fatalf("headerValIndexes len is not 1: %v", qualHeaderVal)
+		x.Failf("headerValIndexes len is not 1: %v", qualHeaderVal)
 	}
 
 	childBlock := generate_Method(
@@ -372,6 +464,43 @@ func generateGoTestBlock_Method(
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+func generate_Func(file *File, fe *feparser.FEFunc, indexKey int, indexVal int) *Statement {
+
+	keyParam := fe.Parameters[indexKey]
+	keyParam.VarName = gogentools.NewNameWithPrefix(feparser.NewLowerTitleName("key", keyParam.TypeName))
+
+	valParam := fe.Parameters[indexVal]
+	valParam.VarName = gogentools.NewNameWithPrefix(feparser.NewLowerTitleName("val", valParam.TypeName))
+
+	code := BlockFunc(
+		func(groupCase *Group) {
+
+			ComposeTypeAssertion(file, groupCase, keyParam.VarName, keyParam.GetOriginal().GetType(), keyParam.GetOriginal().IsVariadic())
+			ComposeTypeAssertion(file, groupCase, valParam.VarName, valParam.GetOriginal().GetType(), valParam.GetOriginal().IsVariadic())
+
+			groupCase.Qual(fe.PkgPath, fe.Name).CallFunc(
+				func(call *Group) {
+
+					tpFun := fe.GetOriginal().GetType().(*types.Signature)
+
+					zeroVals := gogentools.ScanTupleOfZeroValues(file, tpFun.Params(), fe.GetOriginal().IsVariadic())
+
+					for i, zero := range zeroVals {
+						isConsidered := IntSliceContains([]int{indexKey, indexVal}, i)
+						if isConsidered {
+							call.Id(fe.Parameters[i].VarName)
+						} else {
+							call.Add(zero)
+						}
+					}
+
+				},
+			).Add(Tag(keyParam.VarName, valParam.VarName))
+
+		})
+	return code
+}
+
 func generate_Method(file *File, fe *feparser.FETypeMethod, indexKey int, indexVal int) *Statement {
 
 	keyParam := fe.Func.Parameters[indexKey]
@@ -0,0 +1,36 @@
+package headerwrite
+
+import (
+	"github.com/gagliardetto/codemill/x"
+)
+
+// GenerateModelsAsData reports all the selected funcs as unsupported:
+// models-as-data have no kind for the headers written to a response
+// (the key/value pair of a HeaderWrite is modeled only in codeql).
+func (han *Handler) GenerateModelsAsData(mdl *x.XModel) ([]*x.MaDRow, error) {
+	if err := mdl.Validate(); err != nil {
+		return nil, err
+	}
+	if err := han.Validate(mdl); err != nil {
+		return nil, err
+	}
+
+	var unsupported x.MaDUnsupported
+	for _, mtd := range mdl.Methods {
+		for _, sel := range mtd.Selectors {
+			qual := sel.GetFuncQualifier()
+			if qual == nil || !x.HasValidPos(qual) {
+				continue
+			}
+			unsupported.Addf(
+				"method %q: func %q: the headers written by a func cannot be expressed as models-as-data",
+				mtd.Name,
+				qual.ID,
+			)
+		}
+	}
+	if err := unsupported.Err(); err != nil {
+		return nil, err
+	}
+	return make([]*x.MaDRow, 0), nil
+}
@@ -7,9 +7,7 @@ import (
 )
 
 // NOTE:
-// - The method (on type or interface) must write both key and value.
-// - Functions without a receiver are not supported.
-// - One method per model.
+// - The func (function, or method on type or interface) must write both key and value.
 
 const (
 	Kind x.ModelKind = "HTTP::HeaderWrite"
@@ -24,17 +22,54 @@ const (
 
 //
 func (han *Handler) ScavengeMethods() []*x.XMethod {
-	return []*x.XMethod{
+	return x.ScavengeMethodsFromDocs(han.MethodDocs())
+}
+
+// MethodDocs returns the docs of the methods of the kind.
+func (han *Handler) MethodDocs() []*x.MethodDoc {
+	return []*x.MethodDoc{
 		{
-			Name:      MethodWriteHeaderKey,
-			Selectors: []*x.XSelector{},
+			Name:        MethodWriteHeaderKey,
+			Description: "Coupled 1/2: select the parameter that is the name (key) of the header.",
+			Examples: []string{
+				"func (h Header) Set(key, value string): parameter key.",
+				"func (c *Context) Header(key, value string): parameter key.",
+			},
+			Constraints: []string{
+				"Each func must also be selected in " + MethodWriteHeaderVal + ", with its value parameter.",
+			},
+			CoupledWith: []string{MethodWriteHeaderVal},
 		},
 		{
-			Name:      MethodWriteHeaderVal,
-			Selectors: []*x.XSelector{},
+			Name:        MethodWriteHeaderVal,
+			Description: "Coupled 2/2: select the parameter that is the value of the header.",
+			Examples: []string{
+				"func (h Header) Set(key, value string): parameter value.",
+				"func (c *Context) Header(key, value string): parameter value.",
+			},
+			Constraints: []string{
+				"Each func must also be selected in " + MethodWriteHeaderKey + ", with its key parameter.",
+			},
+			CoupledWith: []string{MethodWriteHeaderKey},
 		},
 	}
 }
+
+// Capabilities returns the capabilities of the methods of the kind:
+// the key and the value are one parameter each.
+func (han *Handler) Capabilities() []*x.MethodCapabilities {
+	capabilities := make([]*x.MethodCapabilities, 0)
+	for _, name := range []string{MethodWriteHeaderKey, MethodWriteHeaderVal} {
+		capabilities = append(capabilities, &x.MethodCapabilities{
+			Name:          name,
+			SelectorKinds: []x.SelectorKind{x.SelectorKindFunc},
+			Mode:          x.SelectionModePos,
+			Roles:         []x.ElementRole{x.ElementRoleParameter},
+			MaxSelections: 1,
+		})
+	}
+	return capabilities
+}
 func (han *Handler) Validate(mdl *x.XModel) error {
 	if len(mdl.Methods) != 2 {
 		return fmt.Errorf("wrong number of methods; expected 2, got %v", len(mdl.Methods))
@@ -47,7 +82,12 @@ func (han *Handler) Validate(mdl *x.XModel) error {
 			return fmt.Errorf("#1 method is not called %s", MethodWriteHeaderVal)
 		}
 	}
-	// TODO:
-	// - Make sure that each selector has a key and a value method.
-	return nil
+	// Each func must write both the key and the value:
+	return x.CheckPairedSelectors(mdl, MethodWriteHeaderKey, MethodWriteHeaderVal)
+}
+
+// Diagnose reports the funcs that are selected only
+// for the key, or only for the value.
+func (han *Handler) Diagnose(report *x.DiagnosticReport, mdl *x.XModel, modelPointer string) {
+	x.DiagnoseUnpairedSelectors(report, mdl, modelPointer, MethodWriteHeaderKey, MethodWriteHeaderVal)
 }
@@ -8,7 +8,9 @@ import (
 	. "github.com/gagliardetto/utilz"
 )
 
-func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootModuleGroup *Group) error {
+func (han *Handler) GenerateCodeQL(ctx *x.CodeQLContext, mdl *x.XModel, rootModuleGroup *Group) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -26,7 +28,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 	{
 		// Add imports:
-		//impAdder.Import("DataFlow::PathGraph")
+		//ctx.Import("DataFlow::PathGraph")
 	}
 
 	className := mdl.Name
@@ -34,13 +36,13 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(methodGetURL)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
-	{
+	for _, pathVersion := range allPathVersions {
 		addedCount := 0
-		funcModelsClassName := feparser.NewCodeQlName(className)
+		funcModelsClassName := x.CqlFormatModelClassName(className, pathVersion)
 		tmp := DoGroup(func(tempFuncsModel *Group) {
-			tempFuncsModel.Doc("Models HTTP redirects.")
+			tempFuncsModel.Doc(Sf("Models HTTP redirects for package: %s", pathVersion))
 			tempFuncsModel.Private().Class().Id(funcModelsClassName).Extends().List(
 				Id("HTTP::Redirect::Range"),
 				Id("DataFlow::CallNode"),
@@ -54,193 +56,188 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 							{
 								funcModelsSelfMethodGroup.DoGroup(
 									func(groupCase *Group) {
-										for _, pathVersion := range allPathVersions {
-											pathCodez := make([]Code, 0)
-											// Functions:
-											{
-												cont, ok := b2fe[pathVersion]
-												if ok {
-													for _, funcQual := range cont {
-														if AllFalse(funcQual.Pos...) {
-															continue
-														}
-														fn := GetFunc(funcQual)
-														thing := fn.(*feparser.FEFunc)
-														pathCodez = append(pathCodez,
-															ParensFunc(
-																func(par *Group) {
-																	par.Commentf("signature: %s", thing.Signature)
-																	par.This().
-																		Dot("getTarget").Call().
-																		Dot("hasQualifiedName").Call(
-																		Id("package"),
-																		Lit(thing.Name),
-																	)
-
-																	par.And()
-
-																	_, code := GetFuncQualifierCodeElements(funcQual)
-																	par.Id("urlNode").Eq().Add(code)
-																},
-															),
-														)
+										pathCodez := make([]Code, 0)
+										// Functions:
+										{
+											cont, ok := b2fe[pathVersion]
+											if ok {
+												for _, funcQual := range cont {
+													if AllFalse(funcQual.Pos...) {
+														continue
 													}
-
+													fn := GetFunc(funcQual)
+													thing := fn.(*feparser.FEFunc)
+													pathCodez = append(pathCodez,
+														ParensFunc(
+															func(par *Group) {
+																par.Commentf("signature: %s", thing.Signature)
+																par.This().
+																	Dot("getTarget").Call().
+																	Dot("hasQualifiedName").Call(
+																	Id("package"),
+																	Lit(thing.Name),
+																)
+
+																par.And()
+
+																_, code := GetFuncQualifierCodeElements(funcQual)
+																par.Id("urlNode").Eq().Add(code)
+															},
+														),
+													)
 												}
+
 											}
-											// Type methods:
-											{
-												b2tm.IterValid(pathVersion,
-													func(receiverTypeID string, methodQualifiers x.FuncQualifierSlice) {
-														codez := DoGroup(func(mtdGroup *Group) {
-															qual := methodQualifiers[0]
-															source := x.GetCachedSource(qual.Path, qual.Version)
-															if source == nil {
-																Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
-															}
-															// Find receiver type:
-															typ := x.FindTypeByID(source, receiverTypeID)
-															if typ == nil {
-																Fatalf("Type not found: %q", receiverTypeID)
-															}
-
-															mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
-
-															methodIndex := 0
-															mtdGroup.ParensFunc(
-																func(parMethods *Group) {
-																	for _, methodQual := range methodQualifiers {
-																		if AllFalse(methodQual.Pos...) {
-																			continue
-																		}
-																		if methodIndex > 0 {
-																			parMethods.Or()
-																		}
-																		methodIndex++
-
-																		fn := GetFunc(methodQual)
-																		thing := fn.(*feparser.FETypeMethod)
-
-																		parMethods.ParensFunc(
-																			func(par *Group) {
-																				par.Commentf("signature: %s", thing.Func.Signature)
-
-																				par.This().
-																					Eq().
-																					Any(
-																						DoGroup(func(gr *Group) {
-																							gr.Id("Method").Id("m")
-																						}),
-																						DoGroup(func(gr *Group) {
-																							gr.Id("m").Dot("hasQualifiedName").Call(
-																								Id("package"),
-																								Lit(thing.Receiver.TypeName),
-																								Lit(thing.Func.Name),
-																							)
-																						}),
-																						nil,
-																					).Dot("getACall").Call()
-
-																				par.And()
-
-																				_, code := GetFuncQualifierCodeElements(methodQual)
-																				par.Id("urlNode").Eq().Add(code)
-																			},
-																		)
+										}
+										// Type methods:
+										{
+											b2tm.IterValid(pathVersion,
+												func(receiverTypeID string, methodQualifiers x.FuncQualifierSlice) {
+													codez := DoGroup(func(mtdGroup *Group) {
+														qual := methodQualifiers[0]
+														source := x.GetCachedSource(qual.Path, qual.Version)
+														if source == nil {
+															x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
+														}
+														// Find receiver type:
+														typ := x.FindTypeByID(source, receiverTypeID)
+														if typ == nil {
+															x.Failf("Type not found: %q", receiverTypeID)
+														}
+
+														mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
 
+														methodIndex := 0
+														mtdGroup.ParensFunc(
+															func(parMethods *Group) {
+																for _, methodQual := range methodQualifiers {
+																	if AllFalse(methodQual.Pos...) {
+																		continue
 																	}
-																},
-															)
+																	if methodIndex > 0 {
+																		parMethods.Or()
+																	}
+																	methodIndex++
+
+																	fn := GetFunc(methodQual)
+																	thing := fn.(*feparser.FETypeMethod)
+
+																	parMethods.ParensFunc(
+																		func(par *Group) {
+																			par.Commentf("signature: %s", thing.Func.Signature)
+
+																			par.This().
+																				Eq().
+																				Any(
+																					DoGroup(func(gr *Group) {
+																						gr.Id("Method").Id("m")
+																					}),
+																					DoGroup(func(gr *Group) {
+																						gr.Id("m").Dot("hasQualifiedName").Call(
+																							Id("package"),
+																							Lit(thing.Receiver.TypeName),
+																							Lit(thing.Func.Name),
+																						)
+																					}),
+																					nil,
+																				).Dot("getACall").Call()
+
+																			par.And()
+
+																			_, code := GetFuncQualifierCodeElements(methodQual)
+																			par.Id("urlNode").Eq().Add(code)
+																		},
+																	)
 
-														})
-														pathCodez = append(pathCodez, codez)
-													})
-											}
-											// Interface methods:
-											{
-												b2itm.IterValid(pathVersion,
-													func(receiverTypeID string, methodQualifiers x.FuncQualifierSlice) {
-														codez := DoGroup(func(mtdGroup *Group) {
-															qual := methodQualifiers[0]
-															source := x.GetCachedSource(qual.Path, qual.Version)
-															if source == nil {
-																Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
-															}
-															// Find receiver type:
-															typ := x.FindTypeByID(source, receiverTypeID)
-															if typ == nil {
-																Fatalf("Type not found: %q", receiverTypeID)
-															}
-															mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
-
-															methodIndex := 0
-															mtdGroup.ParensFunc(
-																func(parMethods *Group) {
-																	for _, methodQual := range methodQualifiers {
-																		if AllFalse(methodQual.Pos...) {
-																			continue
-																		}
-																		if methodIndex > 0 {
-																			parMethods.Or()
-																		}
-																		methodIndex++
-
-																		fn := GetFunc(methodQual)
-																		thing := fn.(*feparser.FEInterfaceMethod)
-
-																		parMethods.ParensFunc(
-																			func(par *Group) {
-																				par.Commentf("signature: %s", thing.Func.Signature)
-
-																				par.This().
-																					Eq().
-																					Any(
-																						DoGroup(func(gr *Group) {
-																							gr.Id("Method").Id("m")
-																						}),
-																						DoGroup(func(gr *Group) {
-																							gr.Id("m").Dot("implements").Call(
-																								Id("package"),
-																								Lit(thing.Receiver.TypeName),
-																								Lit(thing.Func.Name),
-																							)
-																						}),
-																						nil,
-																					).Dot("getACall").Call()
-
-																				par.And()
-
-																				_, code := GetFuncQualifierCodeElements(methodQual)
-																				par.Id("urlNode").Eq().Add(code)
-																			},
-																		)
+																}
+															},
+														)
 
+													})
+													pathCodez = append(pathCodez, codez)
+												})
+										}
+										// Interface methods:
+										{
+											b2itm.IterValid(pathVersion,
+												func(receiverTypeID string, methodQualifiers x.FuncQualifierSlice) {
+													codez := DoGroup(func(mtdGroup *Group) {
+														qual := methodQualifiers[0]
+														source := x.GetCachedSource(qual.Path, qual.Version)
+														if source == nil {
+															x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
+														}
+														// Find receiver type:
+														typ := x.FindTypeByID(source, receiverTypeID)
+														if typ == nil {
+															x.Failf("Type not found: %q", receiverTypeID)
+														}
+														mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
+
+														methodIndex := 0
+														mtdGroup.ParensFunc(
+															func(parMethods *Group) {
+																for _, methodQual := range methodQualifiers {
+																	if AllFalse(methodQual.Pos...) {
+																		continue
+																	}
+																	if methodIndex > 0 {
+																		parMethods.Or()
 																	}
-																},
-															)
+																	methodIndex++
+
+																	fn := GetFunc(methodQual)
+																	thing := fn.(*feparser.FEInterfaceMethod)
+
+																	parMethods.ParensFunc(
+																		func(par *Group) {
+																			par.Commentf("signature: %s", thing.Func.Signature)
+
+																			par.This().
+																				Eq().
+																				Any(
+																					DoGroup(func(gr *Group) {
+																						gr.Id("Method").Id("m")
+																					}),
+																					DoGroup(func(gr *Group) {
+																						gr.Id("m").Dot("implements").Call(
+																							Id("package"),
+																							Lit(thing.Receiver.TypeName),
+																							Lit(thing.Func.Name),
+																						)
+																					}),
+																					nil,
+																				).Dot("getACall").Call()
+
+																			par.And()
+
+																			_, code := GetFuncQualifierCodeElements(methodQual)
+																			par.Id("urlNode").Eq().Add(code)
+																		},
+																	)
+
+																}
+															},
+														)
 
-														})
-														pathCodez = append(pathCodez, codez)
 													})
-											}
+													pathCodez = append(pathCodez, codez)
+												})
+										}
 
-											if len(pathCodez) > 0 {
-												if addedCount > 0 {
-													groupCase.Or()
-												}
-												path, _ := scanner.SplitPathVersion(pathVersion)
-												groupCase.Commentf("HTTP redirect models for package: %s", pathVersion)
-												groupCase.Id("package").Eq().Add(x.CqlFormatPackagePath(path)).And()
-
-												groupCase.Parens(
-													Join(
-														Or(),
-														pathCodez...,
-													),
-												)
-
-												addedCount++
-											}
+										if len(pathCodez) > 0 {
+											path, _ := scanner.SplitPathVersion(pathVersion)
+											groupCase.Commentf("HTTP redirect models for package: %s", pathVersion)
+											groupCase.Id("package").Eq().Add(ctx.FormatPackagePath(path)).And()
+
+											groupCase.Parens(
+												Join(
+													Or(),
+													pathCodez...,
+												),
+											)
+
+											addedCount++
 										}
 									})
 							}
@@ -258,7 +255,6 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 				})
 		})
 		if addedCount > 0 {
-
 			rootModuleGroup.Add(tmp)
 		}
 	}
@@ -270,12 +266,12 @@ func GetFunc(qual *x.FuncQualifier) x.FuncInterface {
 
 	source := x.GetCachedSource(qual.Path, qual.Version)
 	if source == nil {
-		Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+		x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 	}
 	// Find the func/type-method/interface-method:
 	fn := x.FindFuncByID(source, qual.ID)
 	if fn == nil {
-		Fatalf("Func not found: %q", qual.ID)
+		x.Failf("Func not found: %q", qual.ID)
 	}
 
 	return fn
@@ -1,8 +1,8 @@
 package redirect
 
 import (
+	"fmt"
 	"go/types"
-	"os"
 	"path/filepath"
 
 	. "github.com/dave/jennifer/jen"
@@ -79,7 +79,9 @@ var (
 	IncludeCommentsInGeneratedGo bool
 )
 
-func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
+func (han *Handler) GenerateGo(out x.OutputFS, mdl *x.XModel) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -92,15 +94,17 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 	// Check if there are multiple versions of a same package:
 	mods := mdl.ListModules()
 	if x.HasMultiversion(mods) {
-		Ln(RedBG("Has multiversion"))
+		Infof("Model %q has multiple versions of the same package; generating one test directory per version.", mdl.Name)
 	}
 	// If there are no multiple versions of the same module,
 	// that means we can save all the code to one file.
 	allInOneFile := !x.HasMultiversion(mods)
 
 	// Create the directory for the tests for this model:
-	outDir := filepath.Join(parentDir, feparser.NewCodeQlName(mdl.Name))
-	MustCreateFolderIfNotExists(outDir, os.ModePerm)
+	outDir := feparser.NewCodeQlName(mdl.Name)
+	if err := out.MkdirAll(outDir); err != nil {
+		return err
+	}
 
 	// Assuming the validation has already been done:
 	methodGetURL := mdl.Methods[0]
@@ -123,7 +127,7 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 
 		b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(methodGetURL)
 		if err != nil {
-			Fatalf("Error while GroupFuncSelectors: %s", err)
+			x.Failf("Error while GroupFuncSelectors: %s", err)
 		}
 
 		{
@@ -178,7 +182,7 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 					// Find receiver type:
 					typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -235,7 +239,7 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 					// Find receiver type:
 					typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -290,47 +294,53 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 		}
 
 		if !allInOneFile {
-			file.PackageComment("//go:generate depstubber --vendor --auto")
-
 			pkgDstDirpath := filepath.Join(outDir, feparser.FormatID("Model", mdl.Name, "For", feparser.FormatCodeQlName(pathVersion)))
-			MustCreateFolderIfNotExists(pkgDstDirpath, os.ModePerm)
+			if err := out.MkdirAll(pkgDstDirpath); err != nil {
+				return err
+			}
 
 			assetFileName := feparser.FormatID("Model", mdl.Name, "For", feparser.FormatCodeQlName(pathVersion)) + ".go"
-			if err := x.SaveGoFile(pkgDstDirpath, assetFileName, file); err != nil {
-				Fatalf("Error while saving go file: %s", err)
+			if err := x.TypeCheckGoTest(file, mdl, pathVersion); err != nil {
+				return err
+			}
+			if err := x.SaveGoFile(out, pkgDstDirpath, assetFileName, file); err != nil {
+				return fmt.Errorf("error while saving go file: %s", err)
 			}
 
-			if err := x.WriteGoModFile(pkgDstDirpath, pathVersion); err != nil {
-				Fatalf("Error while saving go.mod file: %s", err)
+			if err := x.WriteVendoredGoModule(out, pkgDstDirpath, mdl, pathVersion); err != nil {
+				return fmt.Errorf("error while saving go.mod file and vendor stubs: %s", err)
 			}
-			if err := x.WriteCodeQLTestQuery(pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
-				Fatalf("Error while saving <name>.ql file: %s", err)
+			if err := x.WriteCodeQLTestQuery(out, pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
+				return fmt.Errorf("error while saving <name>.ql file: %s", err)
 			}
-			if err := x.WriteEmptyCodeQLDotExpectedFile(pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
-				Fatalf("Error while saving <name>.expected file: %s", err)
+			if err := x.WriteEmptyCodeQLDotExpectedFile(out, pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
+				return fmt.Errorf("error while saving <name>.expected file: %s", err)
 			}
 		}
 	}
 
 	if allInOneFile {
-		file.PackageComment("//go:generate depstubber --vendor --auto")
-
 		pkgDstDirpath := outDir
-		MustCreateFolderIfNotExists(pkgDstDirpath, os.ModePerm)
+		if err := out.MkdirAll(pkgDstDirpath); err != nil {
+			return err
+		}
 
 		assetFileName := feparser.FormatID("Model", mdl.Name) + ".go"
-		if err := x.SaveGoFile(pkgDstDirpath, assetFileName, file); err != nil {
-			Fatalf("Error while saving go file: %s", err)
+		if err := x.TypeCheckGoTest(file, mdl, allPathVersions...); err != nil {
+			return err
+		}
+		if err := x.SaveGoFile(out, pkgDstDirpath, assetFileName, file); err != nil {
+			return fmt.Errorf("error while saving go file: %s", err)
 		}
 
-		if err := x.WriteGoModFile(pkgDstDirpath, allPathVersions...); err != nil {
-			Fatalf("Error while saving go.mod file: %s", err)
+		if err := x.WriteVendoredGoModule(out, pkgDstDirpath, mdl, allPathVersions...); err != nil {
+			return fmt.Errorf("error while saving go.mod file and vendor stubs: %s", err)
 		}
-		if err := x.WriteCodeQLTestQuery(pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
-			Fatalf("Error while saving <name>.ql file: %s", err)
+		if err := x.WriteCodeQLTestQuery(out, pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
+			return fmt.Errorf("error while saving <name>.ql file: %s", err)
 		}
-		if err := x.WriteEmptyCodeQLDotExpectedFile(pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
-			Fatalf("Error while saving <name>.expected file: %s", err)
+		if err := x.WriteEmptyCodeQLDotExpectedFile(out, pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
+			return fmt.Errorf("error while saving <name>.expected file: %s", err)
 		}
 	}
 	return nil
@@ -0,0 +1,42 @@
+package redirect
+
+import (
+	"github.com/gagliardetto/codemill/x"
+)
+
+// GenerateModelsAsData generates a `url-redirection`
+// sink row for each selected URL parameter.
+func (han *Handler) GenerateModelsAsData(mdl *x.XModel) ([]*x.MaDRow, error) {
+	if err := mdl.Validate(); err != nil {
+		return nil, err
+	}
+	if err := han.Validate(mdl); err != nil {
+		return nil, err
+	}
+
+	// Assuming the validation has already been done:
+	methodGetURL := mdl.Methods[0]
+
+	rows := make([]*x.MaDRow, 0)
+	for _, sel := range methodGetURL.Selectors {
+		qual := sel.GetFuncQualifier()
+		if qual == nil || !x.HasValidPos(qual) {
+			continue
+		}
+		base, fn, err := x.NewMaDFuncRow(x.MaDSinkModel, qual)
+		if err != nil {
+			return nil, err
+		}
+		inputs, err := x.FormatMaDAccessPaths(fn, qual.Pos)
+		if err != nil {
+			return nil, err
+		}
+		for _, input := range inputs {
+			row := *base
+			row.Input = input
+			row.Kind = "url-redirection"
+			rows = append(rows, &row)
+		}
+	}
+	return rows, nil
+}
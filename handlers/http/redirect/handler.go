@@ -18,10 +18,31 @@ const (
 
 //
 func (han *Handler) ScavengeMethods() []*x.XMethod {
-	return []*x.XMethod{
+	return x.ScavengeMethodsFromDocs(han.MethodDocs())
+}
+
+// MethodDocs returns the docs of the methods of the kind.
+func (han *Handler) MethodDocs() []*x.MethodDoc {
+	return []*x.MethodDoc{
+		{
+			Name:        MethodGetURL,
+			Description: "Select the parameter that is the URL of the redirect.",
+			Examples: []string{
+				"http.Redirect(w ResponseWriter, r *Request, url string, code int): parameter url.",
+				"func (c *Context) Redirect(code int, location string): parameter location.",
+			},
+		},
+	}
+}
+
+// Capabilities returns the capabilities of the methods of the kind.
+func (han *Handler) Capabilities() []*x.MethodCapabilities {
+	return []*x.MethodCapabilities{
 		{
-			Name:      MethodGetURL,
-			Selectors: []*x.XSelector{},
+			Name:          MethodGetURL,
+			SelectorKinds: []x.SelectorKind{x.SelectorKindFunc},
+			Mode:          x.SelectionModePos,
+			Roles:         []x.ElementRole{x.ElementRoleParameter},
 		},
 	}
 }
@@ -1,8 +1,6 @@
 package responsebody
 
 import (
-	"strings"
-
 	"github.com/gagliardetto/codebox/scanner"
 	"github.com/gagliardetto/codemill/x"
 	. "github.com/gagliardetto/cqlgen/jen"
@@ -10,7 +8,9 @@ import (
 	. "github.com/gagliardetto/utilz"
 )
 
-func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootModuleGroup *Group) error {
+func (han *Handler) GenerateCodeQL(ctx *x.CodeQLContext, mdl *x.XModel, rootModuleGroup *Group) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -20,7 +20,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 	{
 		// Add imports:
-		//impAdder.Import("DataFlow::PathGraph")
+		//ctx.Import("DataFlow::PathGraph")
 	}
 
 	className := mdl.Name
@@ -66,7 +66,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 												}
 												path, _ := scanner.SplitPathVersion(pathVersion)
 												groupCase.Commentf("HTTP ResponseBody models for package: %s", pathVersion)
-												groupCase.Id("package").Eq().Add(x.CqlFormatPackagePath(path)).And()
+												groupCase.Id("package").Eq().Add(ctx.FormatPackagePath(path)).And()
 
 												groupCase.Parens(
 													Join(
@@ -105,12 +105,12 @@ func GetFunc(qual *x.FuncQualifier) x.FuncInterface {
 
 	source := x.GetCachedSource(qual.Path, qual.Version)
 	if source == nil {
-		Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+		x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 	}
 	// Find the func/type-method/interface-method:
 	fn := x.FindFuncByID(source, qual.ID)
 	if fn == nil {
-		Fatalf("Func not found: %q", qual.ID)
+		x.Failf("Func not found: %q", qual.ID)
 	}
 
 	return fn
@@ -133,34 +133,6 @@ func GetContentTypeSetterFuncQualifierCodeElements(qual *x.FuncQualifier) (x.Fun
 	return fn, code
 }
 
-func guessContentTypeFromFuncName(name string) string {
-	name = strings.ToLower(name)
-
-	if strings.Contains(name, "jsonp") {
-		return "application/javascript"
-	}
-	if strings.Contains(name, "json") {
-		return "application/json"
-	}
-	if strings.Contains(name, "xml") {
-		return "text/xml"
-	}
-	if strings.Contains(name, "yaml") || strings.Contains(name, "yml") {
-		return "application/x-yaml"
-	}
-	if strings.Contains(name, "html") {
-		return "text/html"
-	}
-	if strings.Contains(name, "string") || strings.Contains(name, "text") {
-		return "text/plain"
-	}
-	if strings.Contains(name, "error") {
-		// NOTE: this might be not correct.
-		return "text/plain"
-	}
-	return "TODO"
-}
-
 // cql_MethodBodyWithCtFromFuncName generates model statements for MethodBodyWithCtFromFuncName
 func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 	comment := "One call sets both body and content-type (which is implicit in the func name)."
@@ -174,7 +146,7 @@ func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(mtdBodyWithCtFromFuncName)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	pathCodez := make([]Code, 0)
@@ -207,7 +179,7 @@ func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code
 
 							par.And()
 
-							par.Id("contentType").Eq().Lit(guessContentTypeFromFuncName(fn.GetFunc().Name))
+							par.Id("contentType").Eq().Lit(MustGetContentType(funcQual))
 						},
 					),
 				)
@@ -225,12 +197,12 @@ func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -279,7 +251,7 @@ func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code
 
 										par.And()
 
-										par.Id("contentType").Eq().Lit(guessContentTypeFromFuncName(fn.GetFunc().Name))
+										par.Id("contentType").Eq().Lit(MustGetContentType(methodQual))
 									},
 								)
 							}
@@ -300,12 +272,12 @@ func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
@@ -354,7 +326,7 @@ func cql_MethodBodyWithCtFromFuncName(mdl *x.XModel, pathVersion string) []Code
 
 										par.And()
 
-										par.Id("contentType").Eq().Lit(guessContentTypeFromFuncName(fn.GetFunc().Name))
+										par.Id("contentType").Eq().Lit(MustGetContentType(methodQual))
 									},
 								)
 							}
@@ -382,7 +354,7 @@ func cql_MethodBodyWithCt(mdl *x.XModel, pathVersion string) []Code {
 
 	b2feBody, b2tmBody, b2itmBody, err := x.GroupFuncSelectors(mtdBodyWithCtIsBody)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 	//
 	mtdBodyWithCtIsCt := mdl.Methods.ByName(MethodBodyWithCtIsCt)
@@ -393,7 +365,7 @@ func cql_MethodBodyWithCt(mdl *x.XModel, pathVersion string) []Code {
 
 	b2feCt, b2tmCt, b2itmCt, err := x.GroupFuncSelectors(mtdBodyWithCtIsCt)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	pathCodez := make([]Code, 0)
@@ -447,12 +419,12 @@ func cql_MethodBodyWithCt(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -526,12 +498,12 @@ func cql_MethodBodyWithCt(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
@@ -609,7 +581,7 @@ func cql_MethodBody(mdl *x.XModel, pathVersion string) []Code {
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(mtdBody)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	pathCodez := make([]Code, 0)
@@ -653,12 +625,12 @@ func cql_MethodBody(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -720,12 +692,12 @@ func cql_MethodBody(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					{
@@ -795,7 +767,7 @@ func par_cql_MethodCt(mdl *x.XModel, pathVersion string) []Code {
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(mtdCt)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	pathCodez := make([]Code, 0)
@@ -864,12 +836,12 @@ func par_cql_MethodCt(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -942,12 +914,12 @@ func par_cql_MethodCt(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 					mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
 
@@ -1025,7 +997,7 @@ func par_cql_MethodCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(mtdCtFromFuncName)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	pathCodez := make([]Code, 0)
@@ -1073,7 +1045,7 @@ func par_cql_MethodCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 
 								par.And()
 
-								par.Id("contentType").Eq().Lit(guessContentTypeFromFuncName(fn.GetFunc().Name))
+								par.Id("contentType").Eq().Lit(MustGetContentType(funcQual))
 							},
 						)
 					}
@@ -1094,12 +1066,12 @@ func par_cql_MethodCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 
 					mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -1148,7 +1120,7 @@ func par_cql_MethodCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 
 									st.And()
 
-									st.Id("contentType").Eq().Lit(guessContentTypeFromFuncName(fn.GetFunc().Name))
+									st.Id("contentType").Eq().Lit(MustGetContentType(methodQual))
 								}
 
 							}
@@ -1171,12 +1143,12 @@ func par_cql_MethodCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 					qual := methodQualifiers[0]
 					source := x.GetCachedSource(qual.Path, qual.Version)
 					if source == nil {
-						Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+						x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 					}
 					// Find receiver type:
 					typ := x.FindTypeByID(source, receiverTypeID)
 					if typ == nil {
-						Fatalf("Type not found: %q", receiverTypeID)
+						x.Failf("Type not found: %q", receiverTypeID)
 					}
 					mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
 
@@ -1224,7 +1196,7 @@ func par_cql_MethodCtFromFuncName(mdl *x.XModel, pathVersion string) []Code {
 
 									st.And()
 
-									st.Id("contentType").Eq().Lit(guessContentTypeFromFuncName(fn.GetFunc().Name))
+									st.Id("contentType").Eq().Lit(MustGetContentType(methodQual))
 								}
 
 							}
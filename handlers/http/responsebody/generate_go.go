@@ -1,8 +1,8 @@
 package responsebody
 
 import (
+	"fmt"
 	"go/types"
-	"os"
 	"path/filepath"
 
 	. "github.com/dave/jennifer/jen"
@@ -146,7 +146,9 @@ func getTypeContent(file *File, group *Group, varName string, typ types.Type, is
 	}
 	return assertContent
 }
-func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
+func (han *Handler) GenerateGo(out x.OutputFS, mdl *x.XModel) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -159,15 +161,17 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 	// Check if there are multiple versions of a same package:
 	mods := mdl.ListModules()
 	if x.HasMultiversion(mods) {
-		Ln(RedBG("Has multiversion"))
+		Infof("Model %q has multiple versions of the same package; generating one test directory per version.", mdl.Name)
 	}
 	// If there are no multiple versions of the same module,
 	// that means we can save all the code to one file.
 	allInOneFile := !x.HasMultiversion(mods)
 
 	// Create the directory for the tests for this model:
-	outDir := filepath.Join(parentDir, feparser.NewCodeQlName(mdl.Name))
-	MustCreateFolderIfNotExists(outDir, os.ModePerm)
+	outDir := feparser.NewCodeQlName(mdl.Name)
+	if err := out.MkdirAll(outDir); err != nil {
+		return err
+	}
 
 	allPathVersions := mdl.ListAllPathVersions()
 
@@ -200,47 +204,53 @@ func (han *Handler) GenerateGo(parentDir string, mdl *x.XModel) error {
 		}
 
 		if !allInOneFile {
-			file.PackageComment("//go:generate depstubber --vendor --auto")
-
 			pkgDstDirpath := filepath.Join(outDir, feparser.FormatID("Model", mdl.Name, "For", feparser.FormatCodeQlName(pathVersion)))
-			MustCreateFolderIfNotExists(pkgDstDirpath, os.ModePerm)
+			if err := out.MkdirAll(pkgDstDirpath); err != nil {
+				return err
+			}
 
 			assetFileName := feparser.FormatID("Model", mdl.Name, "For", feparser.FormatCodeQlName(pathVersion)) + ".go"
-			if err := x.SaveGoFile(pkgDstDirpath, assetFileName, file); err != nil {
-				Fatalf("Error while saving go file: %s", err)
+			if err := x.TypeCheckGoTest(file, mdl, pathVersion); err != nil {
+				return err
+			}
+			if err := x.SaveGoFile(out, pkgDstDirpath, assetFileName, file); err != nil {
+				return fmt.Errorf("error while saving go file: %s", err)
 			}
 
-			if err := x.WriteGoModFile(pkgDstDirpath, pathVersion); err != nil {
-				Fatalf("Error while saving go.mod file: %s", err)
+			if err := x.WriteVendoredGoModule(out, pkgDstDirpath, mdl, pathVersion); err != nil {
+				return fmt.Errorf("error while saving go.mod file and vendor stubs: %s", err)
 			}
-			if err := x.WriteCodeQLTestQuery(pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
-				Fatalf("Error while saving <name>.ql file: %s", err)
+			if err := x.WriteCodeQLTestQuery(out, pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
+				return fmt.Errorf("error while saving <name>.ql file: %s", err)
 			}
-			if err := x.WriteEmptyCodeQLDotExpectedFile(pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
-				Fatalf("Error while saving <name>.expected file: %s", err)
+			if err := x.WriteEmptyCodeQLDotExpectedFile(out, pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
+				return fmt.Errorf("error while saving <name>.expected file: %s", err)
 			}
 		}
 	}
 
 	if allInOneFile {
-		file.PackageComment("//go:generate depstubber --vendor --auto")
-
 		pkgDstDirpath := outDir
-		MustCreateFolderIfNotExists(pkgDstDirpath, os.ModePerm)
+		if err := out.MkdirAll(pkgDstDirpath); err != nil {
+			return err
+		}
 
 		assetFileName := feparser.FormatID("Model", mdl.Name) + ".go"
-		if err := x.SaveGoFile(pkgDstDirpath, assetFileName, file); err != nil {
-			Fatalf("Error while saving go file: %s", err)
+		if err := x.TypeCheckGoTest(file, mdl, allPathVersions...); err != nil {
+			return err
+		}
+		if err := x.SaveGoFile(out, pkgDstDirpath, assetFileName, file); err != nil {
+			return fmt.Errorf("error while saving go file: %s", err)
 		}
 
-		if err := x.WriteGoModFile(pkgDstDirpath, allPathVersions...); err != nil {
-			Fatalf("Error while saving go.mod file: %s", err)
+		if err := x.WriteVendoredGoModule(out, pkgDstDirpath, mdl, allPathVersions...); err != nil {
+			return fmt.Errorf("error while saving go.mod file and vendor stubs: %s", err)
 		}
-		if err := x.WriteCodeQLTestQuery(pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
-			Fatalf("Error while saving <name>.ql file: %s", err)
+		if err := x.WriteCodeQLTestQuery(out, pkgDstDirpath, x.DefaultCodeQLTestFileName, TestQueryContent); err != nil {
+			return fmt.Errorf("error while saving <name>.ql file: %s", err)
 		}
-		if err := x.WriteEmptyCodeQLDotExpectedFile(pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
-			Fatalf("Error while saving <name>.expected file: %s", err)
+		if err := x.WriteEmptyCodeQLDotExpectedFile(out, pkgDstDirpath, x.DefaultCodeQLTestFileName); err != nil {
+			return fmt.Errorf("error while saving <name>.expected file: %s", err)
 		}
 	}
 	return nil
@@ -257,7 +267,7 @@ func go_MethodBodyWithCtFromFuncName(mdl *x.XModel, file *File, pathVersion stri
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(method)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	codez := make([]Code, 0)
@@ -313,7 +323,7 @@ func go_MethodBodyWithCtFromFuncName(mdl *x.XModel, file *File, pathVersion stri
 				// Find receiver type:
 				typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 				if typ == nil {
-					Fatalf("Type not found: %q", receiverTypeID)
+					x.Failf("Type not found: %q", receiverTypeID)
 				}
 
 				gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -370,7 +380,7 @@ func go_MethodBodyWithCtFromFuncName(mdl *x.XModel, file *File, pathVersion stri
 				// Find receiver type:
 				typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 				if typ == nil {
-					Fatalf("Type not found: %q", receiverTypeID)
+					x.Failf("Type not found: %q", receiverTypeID)
 				}
 
 				gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -430,6 +440,7 @@ func par_MethodBodyWithCtFromFuncName_generateGoTestBlock(file *File, fn x.FuncI
 		file,
 		fn,
 		indexes,
+		MustGetContentType(qual),
 	)
 	{
 		if childBlock != nil {
@@ -444,7 +455,7 @@ func par_MethodBodyWithCtFromFuncName_generateGoTestBlock(file *File, fn x.FuncI
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func par_MethodBodyWithCtFromFuncName_generate(file *File, fn x.FuncInterface, indexes []int) *Statement {
+func par_MethodBodyWithCtFromFuncName_generate(file *File, fn x.FuncInterface, indexes []int, contentType string) *Statement {
 
 	for _, index := range indexes {
 		in := fn.GetFunc().Parameters[index]
@@ -500,7 +511,7 @@ func par_MethodBodyWithCtFromFuncName_generate(file *File, fn x.FuncInterface, i
 					}
 
 				},
-			).Add(Tag(TagContentType(guessContentTypeFromFuncName(fn.GetFunc().Name)), TagResponseBody(varNames...)))
+			).Add(Tag(TagContentType(contentType), TagResponseBody(varNames...)))
 
 		})
 	return code
@@ -518,7 +529,7 @@ func go_MethodBodyWithCt(mdl *x.XModel, file *File, pathVersion string) []Code {
 
 	b2feBody, b2tmBody, b2itmBody, err := x.GroupFuncSelectors(mtdBodyWithCtIsBody)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 	//
 	mtdBodyWithCtIsCt := mdl.Methods.ByName(MethodBodyWithCtIsCt)
@@ -529,7 +540,7 @@ func go_MethodBodyWithCt(mdl *x.XModel, file *File, pathVersion string) []Code {
 
 	b2feCt, b2tmCt, b2itmCt, err := x.GroupFuncSelectors(mtdBodyWithCtIsCt)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	codez := make([]Code, 0)
@@ -587,7 +598,7 @@ func go_MethodBodyWithCt(mdl *x.XModel, file *File, pathVersion string) []Code {
 				// Find receiver type:
 				typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 				if typ == nil {
-					Fatalf("Type not found: %q", receiverTypeID)
+					x.Failf("Type not found: %q", receiverTypeID)
 				}
 
 				gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -646,7 +657,7 @@ func go_MethodBodyWithCt(mdl *x.XModel, file *File, pathVersion string) []Code {
 				// Find receiver type:
 				typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 				if typ == nil {
-					Fatalf("Type not found: %q", receiverTypeID)
+					x.Failf("Type not found: %q", receiverTypeID)
 				}
 
 				gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -712,11 +723,11 @@ func par_MethodBodyWithCt_generateGoTestBlock(
 	// TODO: support here multiple bodies, too?
 	bodyIndexes := x.MustPosToRelativeParamIndexes(fn, bodyQual.Pos)
 	if len(bodyIndexes) != 1 {
-		Fatalf("bodyIndexes len is not 1: %v", bodyQual)
+		x.Failf("bodyIndexes len is not 1: %v", bodyQual)
 	}
 	ctIndexes := x.MustPosToRelativeParamIndexes(fn, ctQual.Pos)
 	if len(ctIndexes) != 1 {
-		Fatalf("ctIndexes len is not 1: %v", ctQual)
+		x.Failf("ctIndexes len is not 1: %v", ctQual)
 	}
 
 	childBlock := par_MethodBodyWithCt_generate(
@@ -809,7 +820,7 @@ func go_body_ct(mdl *x.XModel, file *File, pathVersion string) []Code {
 	}
 	b2feBody, b2tmBody, b2itmBody, err := x.GroupFuncSelectors(mtdBody)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 	//
 	mtdCt := mdl.Methods.ByName(MethodCt)
@@ -819,7 +830,7 @@ func go_body_ct(mdl *x.XModel, file *File, pathVersion string) []Code {
 	}
 	b2feCt, b2tmCt, b2itmCt, err := x.GroupFuncSelectors(mtdCt)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 	//
 	mtdCtFromFuncName := mdl.Methods.ByName(MethodCtFromFuncName)
@@ -829,7 +840,7 @@ func go_body_ct(mdl *x.XModel, file *File, pathVersion string) []Code {
 	}
 	b2feCtFromFuncName, b2tmCtFromFuncName, b2itmCtFromFuncName, err := x.GroupFuncSelectors(mtdCtFromFuncName)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 
 	_, _, _ = b2feCtFromFuncName, b2tmCtFromFuncName, b2itmCtFromFuncName
@@ -908,7 +919,7 @@ func go_body_ct(mdl *x.XModel, file *File, pathVersion string) []Code {
 				// Find receiver type:
 				typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 				if typ == nil {
-					Fatalf("Type not found: %q", receiverTypeID)
+					x.Failf("Type not found: %q", receiverTypeID)
 				}
 
 				gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -986,7 +997,7 @@ func go_body_ct(mdl *x.XModel, file *File, pathVersion string) []Code {
 				// Find receiver type:
 				typ := x.FindType(qual.Path, qual.Version, receiverTypeID)
 				if typ == nil {
-					Fatalf("Type not found: %q", receiverTypeID)
+					x.Failf("Type not found: %q", receiverTypeID)
 				}
 
 				gogentools.ImportPackage(file, typ.PkgPath, typ.PkgName)
@@ -1068,11 +1079,11 @@ func par_go_body_plus_ct(
 	// TODO: support here multiple bodies, too?
 	bodyIndexes := x.MustPosToRelativeParamIndexes(bodyFn, bodyQual.Pos)
 	if len(bodyIndexes) != 1 {
-		Fatalf("bodyIndexes len is not 1: %v", bodyQual)
+		x.Failf("bodyIndexes len is not 1: %v", bodyQual)
 	}
 	ctIndexes := x.MustPosToRelativeParamIndexes(ctFn, ctQual.Pos)
 	if len(ctIndexes) != 1 {
-		Fatalf("ctIndexes len is not 1: %v", ctQual)
+		x.Failf("ctIndexes len is not 1: %v", ctQual)
 	}
 
 	childBlock := par_go_body_plus_ct_generate(
@@ -1203,7 +1214,7 @@ func par_go_body_plus_ctFromFuncName(
 	// TODO: support here multiple bodies, too?
 	bodyIndexes := x.MustPosToRelativeParamIndexes(bodyFn, bodyQual.Pos)
 	if len(bodyIndexes) != 1 {
-		Fatalf("bodyIndexes len is not 1: %v", bodyQual)
+		x.Failf("bodyIndexes len is not 1: %v", bodyQual)
 	}
 
 	childBlock := par_go_body_plus_ctFromFuncName_generate(
@@ -1211,6 +1222,7 @@ func par_go_body_plus_ctFromFuncName(
 		bodyFn,
 		ctFn,
 		bodyIndexes[0],
+		MustGetContentType(ctQual),
 	)
 	{
 		if childBlock != nil {
@@ -1227,6 +1239,7 @@ func par_go_body_plus_ctFromFuncName_generate(
 	bodyFn x.FuncInterface,
 	ctFn x.FuncInterface,
 	bodyIndex int,
+	contentType string,
 ) *Statement {
 
 	bodyParam := bodyFn.GetFunc().Parameters[bodyIndex]
@@ -1283,7 +1296,7 @@ func par_go_body_plus_ctFromFuncName_generate(
 						}
 
 					},
-				).Add(Tag(TagContentType(guessContentTypeFromFuncName(ctFn.GetFunc().Name)), TagResponseBody(bodyParam.VarName)))
+				).Add(Tag(TagContentType(contentType), TagResponseBody(bodyParam.VarName)))
 			}
 
 		})
@@ -0,0 +1,86 @@
+package responsebody
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gagliardetto/codemill/x"
+)
+
+// GenerateModelsAsData generates an `html-injection` (or `js-injection`) sink row
+// for each selected body parameter whose content-type is known from the func name.
+// Models-as-data cannot relate a body to a content-type set at runtime (i.e. by
+// another parameter, or by another call), nor express the other content-types,
+// so those selections are reported as unsupported.
+func (han *Handler) GenerateModelsAsData(mdl *x.XModel) ([]*x.MaDRow, error) {
+	if err := mdl.Validate(); err != nil {
+		return nil, err
+	}
+	if err := han.Validate(mdl); err != nil {
+		return nil, err
+	}
+
+	rows := make([]*x.MaDRow, 0)
+	var unsupported x.MaDUnsupported
+	for _, mtd := range mdl.Methods {
+		for _, sel := range mtd.Selectors {
+			qual := sel.GetFuncQualifier()
+			if qual == nil || !x.HasValidPos(qual) {
+				continue
+			}
+			switch mtd.Name {
+			case MethodBodyWithCtFromFuncName:
+				ct, err := GetContentType(qual)
+				if err != nil {
+					return nil, err
+				}
+				kind, err := madSinkKindOfContentType(ct)
+				if err != nil {
+					unsupported.Addf("method %q: func %q: %s", mtd.Name, qual.ID, err)
+					continue
+				}
+				base, fn, err := x.NewMaDFuncRow(x.MaDSinkModel, qual)
+				if err != nil {
+					return nil, err
+				}
+				inputs, err := x.FormatMaDAccessPaths(fn, qual.Pos)
+				if err != nil {
+					return nil, err
+				}
+				for _, input := range inputs {
+					row := *base
+					row.Input = input
+					row.Kind = kind
+					rows = append(rows, &row)
+				}
+			case MethodBodyWithCtIsBody, MethodBody:
+				unsupported.Addf(
+					"method %q: func %q: the content-type of the body is set at runtime, which cannot be expressed as models-as-data",
+					mtd.Name,
+					qual.ID,
+				)
+			default:
+				// The content-type funcs are only used with the
+				// bodies of the methods above.
+			}
+		}
+	}
+	if err := unsupported.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// madSinkKindOfContentType returns the models-as-data sink
+// kind of a body with the provided content-type.
+func madSinkKindOfContentType(ct string) (string, error) {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(ct, ";", 2)[0]))
+	switch mediaType {
+	case "text/html":
+		return "html-injection", nil
+	case "application/javascript", "text/javascript", "application/x-javascript":
+		return "js-injection", nil
+	default:
+		return "", fmt.Errorf("bodies with content-type %q cannot be expressed as models-as-data (only text/html and javascript bodies can)", ct)
+	}
+}
@@ -2,8 +2,10 @@ package responsebody
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/gagliardetto/codemill/x"
+	. "github.com/gagliardetto/utilz"
 )
 
 // NOTES:
@@ -29,20 +31,99 @@ const (
 
 //
 func (han *Handler) ScavengeMethods() []*x.XMethod {
-	return x.ScavengeMethods(
-		MethodBodyWithCtFromFuncName, // "Select the body parameter; the content-type will be inferred from the function name.",
+	return x.ScavengeMethodsFromDocs(han.MethodDocs())
+}
+
+// MethodDocs returns the docs of the methods of the kind.
+func (han *Handler) MethodDocs() []*x.MethodDoc {
+	return []*x.MethodDoc{
+		{
+			Name:        MethodBodyWithCtFromFuncName,
+			Description: "Select the body parameter; the content-type will be inferred from the function name (e.g. JSON, XML, HTML, String).",
+			Examples: []string{
+				"func (c *Context) JSON(code int, obj interface{}): parameter obj (content-type application/json).",
+				"func (c *Context) HTML(code int, name string, obj interface{}): parameter obj (content-type text/html).",
+			},
+		},
 
 		// For funcs that allow to specify two parameters: body and content-type.
 		// Each function that you add to MethodBodyWithCtIsBody,
 		// you must also add it to MethodBodyWithCtIsCt.
-		MethodBodyWithCtIsBody, // "Coupled 1/2: Select body parameter of func; content-type will be selected from another parameter of the same function.",
-		MethodBodyWithCtIsCt,   // "Coupled 2/2: Select body parameter of func; content-type will be selected from another parameter of the same function.",
+		{
+			Name:        MethodBodyWithCtIsBody,
+			Description: "Coupled 1/2: select the body parameter of a func whose content-type is another parameter of the same function.",
+			Examples: []string{
+				"func (c *Context) Data(code int, contentType string, data []byte): parameter data.",
+			},
+			Constraints: []string{
+				"Each func must also be selected in " + MethodBodyWithCtIsCt + ", with its content-type parameter.",
+			},
+			CoupledWith: []string{MethodBodyWithCtIsCt},
+		},
+		{
+			Name:        MethodBodyWithCtIsCt,
+			Description: "Coupled 2/2: select the content-type parameter of a func whose body is another parameter of the same function.",
+			Examples: []string{
+				"func (c *Context) Data(code int, contentType string, data []byte): parameter contentType.",
+			},
+			Constraints: []string{
+				"Each func must also be selected in " + MethodBodyWithCtIsBody + ", with its body parameter.",
+			},
+			CoupledWith: []string{MethodBodyWithCtIsBody},
+		},
 
-		MethodBody, // "Select body param of any function that allows to set the body but does NOT determine the content-type.",
-		MethodCt,   // "Select content-type param of any function that allows to set the content-type but does NOT set the body.",
+		{
+			Name:        MethodBody,
+			Description: "Select the body parameter of any function that allows to set the body but does NOT determine the content-type.",
+			Examples: []string{
+				"func (w ResponseWriter) Write(b []byte) (int, error): parameter b.",
+			},
+			Constraints: []string{
+				"The body is modeled together with the content-type set by another call, i.e. by the funcs of " + MethodCt + " or " + MethodCtFromFuncName + ".",
+			},
+			RequiresOneOf: []string{MethodCt, MethodCtFromFuncName},
+		},
+		{
+			Name:        MethodCt,
+			Description: "Select the content-type parameter of any function that allows to set the content-type but does NOT set the body.",
+			Examples: []string{
+				"func (c *Context) ContentType(value string): parameter value.",
+			},
+		},
 
-		MethodCtFromFuncName, // "Select any function that sets the content-type independently of params; content-type will be inferred from the func name.",
-	)
+		{
+			Name:        MethodCtFromFuncName,
+			Description: "Select any function that sets the content-type independently of params; the content-type will be inferred from the func name.",
+			Examples: []string{
+				"func (c *Context) SetContentTypeJSON(): any element (content-type application/json).",
+			},
+		},
+	}
+}
+
+// Capabilities returns the capabilities of the methods of the kind:
+// the body and the content-type are parameters; the funcs of
+// MethodCtFromFuncName are selected as a whole (any element will do).
+func (han *Handler) Capabilities() []*x.MethodCapabilities {
+	capabilities := make([]*x.MethodCapabilities, 0)
+	for _, mt := range han.ScavengeMethods() {
+		caps := &x.MethodCapabilities{
+			Name:          mt.Name,
+			SelectorKinds: []x.SelectorKind{x.SelectorKindFunc},
+			Mode:          x.SelectionModePos,
+			Roles:         []x.ElementRole{x.ElementRoleParameter},
+			ContentType:   MethodHasContentType(mt.Name),
+		}
+		switch mt.Name {
+		case MethodBodyWithCtIsBody, MethodBodyWithCtIsCt, MethodBody, MethodCt:
+			// The go tests use exactly one body (or content-type) parameter:
+			caps.MaxSelections = 1
+		case MethodCtFromFuncName:
+			caps.Roles = x.AllElementRoles
+		}
+		capabilities = append(capabilities, caps)
+	}
+	return capabilities
 }
 func (han *Handler) Validate(mdl *x.XModel) error {
 	defaultMthNum := len(han.ScavengeMethods())
@@ -57,5 +138,85 @@ func (han *Handler) Validate(mdl *x.XModel) error {
 			}
 		}
 	}
+	{
+		// Make sure that the content-type of each func is known:
+		for _, mtd := range mdl.Methods {
+			if !MethodHasContentType(mtd.Name) {
+				continue
+			}
+			for _, sel := range mtd.Selectors {
+				qual := sel.GetFuncQualifier()
+				if qual == nil || AllFalse(qual.Pos...) {
+					// Deselected funcs are skipped by the generators.
+					continue
+				}
+				if _, err := GetContentType(qual); err != nil {
+					return fmt.Errorf("error for method %q: %s", mtd.Name, err)
+				}
+			}
+		}
+	}
 	return nil
 }
+
+// Diagnose reports the coupled funcs that are selected only
+// for the body, or only for the content-type.
+func (han *Handler) Diagnose(report *x.DiagnosticReport, mdl *x.XModel, modelPointer string) {
+	x.DiagnoseUnpairedSelectors(report, mdl, modelPointer, MethodBodyWithCtIsBody, MethodBodyWithCtIsCt)
+}
+
+// MethodHasContentType returns true if the func selectors of the provided
+// method carry a content-type (which is not a parameter of the func).
+func MethodHasContentType(methodName string) bool {
+	return methodName == MethodBodyWithCtFromFuncName || methodName == MethodCtFromFuncName
+}
+
+// GetContentType returns the content-type of the provided func qualifier,
+// i.e. the one set by the user or, if not set, the one guessed from the func name.
+func GetContentType(qual *x.FuncQualifier) (string, error) {
+	if ct := strings.TrimSpace(qual.ContentType); ct != "" {
+		return ct, nil
+	}
+	if ct := GuessContentTypeFromFuncName(qual.Name); ct != "" {
+		return ct, nil
+	}
+	return "", fmt.Errorf("content-type of func %q cannot be guessed from its name; please set it explicitly", qual.ID)
+}
+
+func MustGetContentType(qual *x.FuncQualifier) string {
+	ct, err := GetContentType(qual)
+	if err != nil {
+		x.Failf("Error while GetContentType: %s", err)
+	}
+	return ct
+}
+
+// GuessContentTypeFromFuncName returns the content-type implied by the
+// name of a func, or an empty string if it cannot be guessed.
+func GuessContentTypeFromFuncName(name string) string {
+	name = strings.ToLower(name)
+
+	if strings.Contains(name, "jsonp") {
+		return "application/javascript"
+	}
+	if strings.Contains(name, "json") {
+		return "application/json"
+	}
+	if strings.Contains(name, "xml") {
+		return "text/xml"
+	}
+	if strings.Contains(name, "yaml") || strings.Contains(name, "yml") {
+		return "application/x-yaml"
+	}
+	if strings.Contains(name, "html") {
+		return "text/html"
+	}
+	if strings.Contains(name, "string") || strings.Contains(name, "text") {
+		return "text/plain"
+	}
+	if strings.Contains(name, "error") {
+		// NOTE: this might be not correct.
+		return "text/plain"
+	}
+	return ""
+}
@@ -0,0 +1,402 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/codemill/x"
+	cqljen "github.com/gagliardetto/cqlgen/jen"
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// Handler is a ModelKind handler implemented by a plugin (i.e. a subprocess
+// that speaks the protocol of this package); the process is started
+// again by the next request if it exits.
+type Handler struct {
+	// Command is the executable of the plugin, and its args.
+	Command []string
+	// Timeout is the max duration of a request (none if zero); when it
+	// expires, the process of the plugin is killed, and the request fails.
+	Timeout time.Duration
+	desc    *DescribeResult
+
+	mu     *sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+	nextID int
+}
+
+// DefaultTimeout is the default Timeout of the requests to the plugins.
+const DefaultTimeout = 2 * time.Minute
+
+// ParseCommands parses the commands of the plugins (e.g. from the
+// CODEMILL_PLUGINS environment variable): the commands are separated
+// by the OS path list separator, and the args of a command by spaces.
+func ParseCommands(s string) [][]string {
+	commands := make([][]string, 0)
+	for _, command := range filepath.SplitList(s) {
+		if fields := strings.Fields(command); len(fields) > 0 {
+			commands = append(commands, fields)
+		}
+	}
+	return commands
+}
+
+// Start starts the plugin, and asks it to describe its ModelKind.
+func Start(command []string) (*Handler, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("plugin command is empty")
+	}
+	han := &Handler{
+		Command: command,
+		Timeout: DefaultTimeout,
+		mu:      &sync.Mutex{},
+	}
+	han.mu.Lock()
+	defer han.mu.Unlock()
+	if err := han.start(); err != nil {
+		return nil, err
+	}
+	return han, nil
+}
+
+// checkDescription returns an error if the description
+// of the kind provided by a plugin is not usable.
+func checkDescription(desc *DescribeResult) error {
+	if desc.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("protocol version not supported: %v (expected %v)", desc.ProtocolVersion, ProtocolVersion)
+	}
+	if strings.TrimSpace(string(desc.Kind)) == "" {
+		return fmt.Errorf("kind not provided")
+	}
+	if len(desc.Methods) == 0 {
+		return fmt.Errorf("kind %s has no methods", desc.Kind)
+	}
+	names := make([]string, 0, len(desc.Methods))
+	for _, doc := range desc.Methods {
+		if strings.TrimSpace(doc.Name) == "" {
+			return fmt.Errorf("kind %s has a method without name", desc.Kind)
+		}
+		if SliceContains(names, doc.Name) {
+			return fmt.Errorf("kind %s has duplicate method %q", desc.Kind, doc.Name)
+		}
+		names = append(names, doc.Name)
+	}
+	if len(desc.Capabilities) != len(desc.Methods) {
+		return fmt.Errorf("kind %s has %v methods, but the capabilities of %v", desc.Kind, len(desc.Methods), len(desc.Capabilities))
+	}
+	for i, caps := range desc.Capabilities {
+		if caps.Name != names[i] {
+			return fmt.Errorf("kind %s: capabilities #%v are of method %q, not of %q", desc.Kind, i, caps.Name, names[i])
+		}
+		if len(caps.SelectorKinds) == 0 {
+			return fmt.Errorf("kind %s: method %q supports no selector kinds", desc.Kind, caps.Name)
+		}
+		if caps.SupportsSelectorKind(x.SelectorKindFunc) && caps.Mode != x.SelectionModePos && caps.Mode != x.SelectionModeFlow {
+			return fmt.Errorf("kind %s: method %q has unknown selection mode %q", desc.Kind, caps.Name, caps.Mode)
+		}
+	}
+	return nil
+}
+
+// Kind returns the ModelKind implemented by the plugin.
+func (han *Handler) Kind() x.ModelKind {
+	return han.desc.Kind
+}
+
+// start starts the process of the plugin, and asks it to describe its ModelKind;
+// when the process is started again, the description must be the one provided
+// the first time (i.e. the one registered in the router). The caller must hold the lock.
+func (han *Handler) start() error {
+	cmd := exec.Command(han.Command[0], han.Command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error while starting plugin %q: %s", han.Command[0], err)
+	}
+	han.cmd = cmd
+	han.stdin = stdin
+	han.stdout = bufio.NewScanner(stdout)
+	han.stdout.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	var desc DescribeResult
+	if err := han.request(MethodDescribe, &DescribeParams{ProtocolVersion: ProtocolVersion}, &desc); err != nil {
+		han.kill()
+		return err
+	}
+	if err := checkDescription(&desc); err != nil {
+		han.kill()
+		return fmt.Errorf("plugin %q: %s", han.Command[0], err)
+	}
+	if han.desc != nil && !reflect.DeepEqual(han.desc, &desc) {
+		han.kill()
+		return fmt.Errorf("plugin %q: the description of kind %s changed since the plugin was started", han.Command[0], han.desc.Kind)
+	}
+	han.desc = &desc
+	return nil
+}
+
+// stop closes the stdin of the process (so that the plugin exits),
+// and waits for it; the caller must hold the lock.
+func (han *Handler) stop() error {
+	if han.cmd == nil {
+		return nil
+	}
+	han.stdin.Close()
+	err := han.cmd.Wait()
+	han.cmd = nil
+	return err
+}
+
+// kill kills the process (i.e. when it is not usable anymore),
+// and waits for it; the caller must hold the lock.
+func (han *Handler) kill() {
+	if han.cmd == nil {
+		return
+	}
+	han.cmd.Process.Kill()
+	han.stop()
+}
+
+// Close stops the process of the plugin.
+func (han *Handler) Close() error {
+	han.mu.Lock()
+	defer han.mu.Unlock()
+	return han.stop()
+}
+
+// call sends the request to the plugin (starting it if needed),
+// and decodes the result of the response.
+func (han *Handler) call(method string, params interface{}, result interface{}) error {
+	han.mu.Lock()
+	defer han.mu.Unlock()
+
+	if han.cmd == nil {
+		if err := han.start(); err != nil {
+			return err
+		}
+	}
+	return han.request(method, params, result)
+}
+
+// request sends the request to the running plugin, and decodes the result
+// of the response; the caller must hold the lock.
+func (han *Handler) request(method string, params interface{}, result interface{}) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	han.nextID++
+	req := &Request{
+		ID:     han.nextID,
+		Method: method,
+		Params: rawParams,
+	}
+
+	var timedOut int32
+	if han.Timeout > 0 {
+		// Killing the process unblocks the round trip:
+		process := han.cmd.Process
+		timer := time.AfterFunc(han.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			process.Kill()
+		})
+		defer timer.Stop()
+	}
+	resp, err := han.roundTrip(req)
+	if err != nil {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			err = fmt.Errorf("no response within %s", han.Timeout)
+		}
+		// The process is not usable anymore:
+		han.kill()
+		return fmt.Errorf("plugin %q: %s: %s", han.Command[0], method, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("plugin %q: %s: result not valid: %s", han.Command[0], method, err)
+	}
+	return nil
+}
+
+func (han *Handler) roundTrip(req *Request) (*Response, error) {
+	if err := json.NewEncoder(han.stdin).Encode(req); err != nil {
+		return nil, err
+	}
+	if !han.stdout.Scan() {
+		if err := han.stdout.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("plugin exited")
+	}
+	var resp Response
+	if err := json.Unmarshal(han.stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("response not valid: %s", err)
+	}
+	if resp.ID != req.ID {
+		return nil, fmt.Errorf("response ID %v does not match request ID %v", resp.ID, req.ID)
+	}
+	return &resp, nil
+}
+
+// ScavengeMethods returns the methods provided by the plugin.
+func (han *Handler) ScavengeMethods() []*x.XMethod {
+	return x.ScavengeMethodsFromDocs(han.desc.Methods)
+}
+
+// MethodDocs returns the docs of the methods provided by the plugin.
+func (han *Handler) MethodDocs() []*x.MethodDoc {
+	return han.desc.Methods
+}
+
+// Capabilities returns the capabilities of the methods provided by the plugin.
+func (han *Handler) Capabilities() []*x.MethodCapabilities {
+	return han.desc.Capabilities
+}
+
+func (han *Handler) Validate(mdl *x.XModel) error {
+	params, err := newModelParams(mdl)
+	if err != nil {
+		return err
+	}
+	return han.call(MethodValidate, params, nil)
+}
+
+func (han *Handler) GenerateCodeQL(ctx *x.CodeQLContext, mdl *x.XModel, moduleGroup *cqljen.Group) error {
+	params, err := newModelParams(mdl)
+	if err != nil {
+		return err
+	}
+	var result GenerateCodeQLResult
+	err = han.call(MethodGenerateCodeQL, &GenerateCodeQLParams{ModelParams: *params}, &result)
+	if err != nil {
+		return err
+	}
+	for _, imp := range result.Imports {
+		ctx.Import(imp)
+	}
+	code, err := replacePackagePaths(ctx, result.Code)
+	if err != nil {
+		return err
+	}
+	moduleGroup.Comment(Sf("Model %s (generated by plugin %s)", mdl.Name, filepath.Base(han.Command[0])))
+	moduleGroup.Add(code)
+	return nil
+}
+
+func (han *Handler) GenerateGo(out x.OutputFS, mdl *x.XModel) error {
+	params, err := newModelParams(mdl)
+	if err != nil {
+		return err
+	}
+	var result GenerateGoResult
+	if err := han.call(MethodGenerateGo, params, &result); err != nil {
+		return err
+	}
+	for _, file := range result.Files {
+		rel := filepath.Clean(filepath.FromSlash(file.Path))
+		if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("path of generated file not valid: %q", file.Path)
+		}
+		if err := out.MkdirAll(filepath.Dir(rel)); err != nil {
+			return err
+		}
+		if err := out.WriteFile(rel, []byte(file.Content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newModelParams returns the model, with the funcs
+// selected in the model resolved from their packages.
+func newModelParams(mdl *x.XModel) (*ModelParams, error) {
+	params := &ModelParams{
+		Model: mdl,
+		Funcs: make([]*ResolvedFunc, 0),
+	}
+	done := make(map[string]bool)
+	for _, mt := range mdl.Methods {
+		for _, sel := range mt.Selectors {
+			qual := sel.GetFuncQualifier()
+			if qual == nil {
+				continue
+			}
+			key := qual.PathVersion() + "#" + qual.ID
+			if done[key] {
+				continue
+			}
+			done[key] = true
+
+			source := x.GetCachedSource(qual.Path, qual.Version)
+			if source == nil {
+				return nil, fmt.Errorf("source not found: %s", qual.PathVersion())
+			}
+			fn := x.FindFuncByID(source, qual.ID)
+			if fn == nil {
+				return nil, fmt.Errorf("func not found: %q", qual.ID)
+			}
+			resolved := &ResolvedFunc{
+				ID:       qual.ID,
+				Path:     qual.Path,
+				Version:  qual.Version,
+				Elements: x.CompileFuncQualifierElementsMeta(fn),
+			}
+			switch thing := fn.(type) {
+			case *feparser.FEFunc:
+				resolved.Func = thing
+			case *feparser.FETypeMethod:
+				resolved.TypeMethod = thing
+			case *feparser.FEInterfaceMethod:
+				resolved.InterfaceMethod = thing
+			}
+			params.Funcs = append(params.Funcs, resolved)
+		}
+	}
+	return params, nil
+}
+
+var rxPackagePath = regexp.MustCompile(`\{\{\s*packagePath\s+("(?:[^"\\]|\\.)*")\s*\}\}`)
+
+// replacePackagePaths returns the codeql code, with the {{packagePath "..."}}
+// replaced by the codeql expressions of the packages.
+func replacePackagePaths(ctx *x.CodeQLContext, code string) (*cqljen.Statement, error) {
+	stat := &cqljen.Statement{}
+	last := 0
+	for _, loc := range rxPackagePath.FindAllStringSubmatchIndex(code, -1) {
+		pkgPath, err := strconv.Unquote(code[loc[2]:loc[3]])
+		if err != nil {
+			return nil, fmt.Errorf("packagePath not valid: %s", code[loc[0]:loc[1]])
+		}
+		stat.Id(code[last:loc[0]]).Add(ctx.FormatPackagePath(pkgPath))
+		last = loc[1]
+	}
+	stat.Id(code[last:])
+	return stat, nil
+}
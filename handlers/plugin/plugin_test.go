@@ -0,0 +1,297 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/codemill/x"
+	cqljen "github.com/gagliardetto/cqlgen/jen"
+)
+
+// fakePluginModeEnv is set when the test binary is started as
+// a plugin (see TestMain); its value is the mode of fakePlugin.
+const fakePluginModeEnv = "CODEMILL_FAKE_PLUGIN_MODE"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakePluginModeEnv); mode != "" {
+		os.Exit(runFakePlugin(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runFakePlugin(mode string) int {
+	impl := &fakePlugin{mode: mode}
+	if mode == "wrong-id" {
+		// Respond to the requests (except describe) with the wrong ID:
+		scanner := bufio.NewScanner(os.Stdin)
+		enc := json.NewEncoder(os.Stdout)
+		for scanner.Scan() {
+			var req Request
+			if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+				return 1
+			}
+			result, _ := serveRequest(impl, &req)
+			raw, _ := json.Marshal(result)
+			resp := &Response{ID: req.ID, Result: raw}
+			if req.Method != MethodDescribe {
+				resp.ID++
+			}
+			enc.Encode(resp)
+		}
+		return 0
+	}
+	if err := Serve(impl, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// fakePlugin implements the kind Fake; its mode
+// selects how it misbehaves (if at all).
+type fakePlugin struct {
+	mode string
+}
+
+func (p *fakePlugin) Describe() *DescribeResult {
+	desc := &DescribeResult{
+		ProtocolVersion: ProtocolVersion,
+		Kind:            "Fake",
+		Methods: []*x.MethodDoc{
+			{Name: "Sink", Description: "The sinks."},
+		},
+		Capabilities: []*x.MethodCapabilities{
+			{Name: "Sink", SelectorKinds: []x.SelectorKind{x.SelectorKindFunc}, Mode: x.SelectionModePos},
+		},
+	}
+	switch p.mode {
+	case "other-methods":
+		desc.Methods[0].Description = "The other sinks."
+	case "bad-version":
+		desc.ProtocolVersion = ProtocolVersion + 1
+	case "no-methods":
+		desc.Methods = nil
+		desc.Capabilities = nil
+	case "bad-capabilities":
+		desc.Capabilities[0].Name = "Source"
+	}
+	return desc
+}
+
+func (p *fakePlugin) Validate(params *ModelParams) error {
+	if p.mode == "hang" {
+		time.Sleep(time.Hour)
+	}
+	if params.Model.Name == "Invalid" {
+		return errors.New("model is not valid")
+	}
+	return nil
+}
+
+func (p *fakePlugin) GenerateCodeQL(params *GenerateCodeQLParams) (*GenerateCodeQLResult, error) {
+	return &GenerateCodeQLResult{
+		Imports: []string{"DataFlow::PathGraph"},
+		Code:    `predicate isSink(Function fn) { fn.hasQualifiedName({{packagePath "example.com/lib"}}, "Write") }`,
+	}, nil
+}
+
+func (p *fakePlugin) GenerateGo(params *ModelParams) (*GenerateGoResult, error) {
+	path := params.Model.Name + "/" + params.Model.Name + ".go"
+	if p.mode == "bad-path" {
+		path = "../" + path
+	}
+	return &GenerateGoResult{
+		Files: []*File{{Path: path, Content: "package main\n"}},
+	}, nil
+}
+
+// startFakePlugin starts the test binary as a plugin in the provided mode.
+func startFakePlugin(t *testing.T, mode string) (*Handler, error) {
+	setFakePluginMode(t, mode)
+	han, err := Start([]string{os.Args[0]})
+	if err == nil {
+		t.Cleanup(func() { han.Close() })
+	}
+	return han, err
+}
+
+// setFakePluginMode sets the mode of the plugin processes started from now on.
+func setFakePluginMode(t *testing.T, mode string) {
+	os.Setenv(fakePluginModeEnv, mode)
+	t.Cleanup(func() { os.Unsetenv(fakePluginModeEnv) })
+}
+
+func newFakeModel(han *Handler, name string) *x.XModel {
+	return &x.XModel{
+		Name:    name,
+		Kind:    han.Kind(),
+		Methods: han.ScavengeMethods(),
+	}
+}
+
+func expectError(t *testing.T, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected error %q, got none", expected)
+	}
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected error %q, got %q", expected, err)
+	}
+}
+
+func TestStartDescribe(t *testing.T) {
+	han, err := startFakePlugin(t, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if han.Kind() != "Fake" {
+		t.Errorf("expected kind Fake, got %s", han.Kind())
+	}
+	methods := han.ScavengeMethods()
+	if len(methods) != 1 || methods[0].Name != "Sink" {
+		t.Errorf("expected the method Sink, got %v", methods)
+	}
+
+	tests := map[string]string{
+		"bad-version":      "protocol version not supported",
+		"no-methods":       "kind Fake has no methods",
+		"bad-capabilities": `capabilities #0 are of method "Source", not of "Sink"`,
+	}
+	for mode, expected := range tests {
+		t.Run(mode, func(t *testing.T) {
+			_, err := startFakePlugin(t, mode)
+			expectError(t, err, expected)
+		})
+	}
+}
+
+func TestRequests(t *testing.T) {
+	han, err := startFakePlugin(t, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := han.Validate(newFakeModel(han, "Valid")); err != nil {
+		t.Fatal(err)
+	}
+	// The error of a request does not stop the plugin:
+	expectError(t, han.Validate(newFakeModel(han, "Invalid")), "model is not valid")
+
+	vfs := x.NewVirtualFS()
+	if err := han.GenerateGo(vfs, newFakeModel(han, "Valid")); err != nil {
+		t.Fatal(err)
+	}
+	if content, ok := vfs.ReadFile("Valid/Valid.go"); !ok || string(content) != "package main\n" {
+		t.Errorf("generated file not found in %v", vfs.Paths())
+	}
+}
+
+func TestGenerateGoPathOutsideFolder(t *testing.T) {
+	han, err := startFakePlugin(t, "bad-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vfs := x.NewVirtualFS()
+	expectError(t, han.GenerateGo(vfs, newFakeModel(han, "Valid")), "path of generated file not valid")
+	if paths := vfs.Paths(); len(paths) != 0 {
+		t.Errorf("expected no files, got %v", paths)
+	}
+}
+
+func TestResponseIDMismatch(t *testing.T) {
+	han, err := startFakePlugin(t, "wrong-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, han.Validate(newFakeModel(han, "Valid")), "response ID 3 does not match request ID 2")
+	// The process is started again (and described) by the next request:
+	expectError(t, han.Validate(newFakeModel(han, "Valid")), "response ID 5 does not match request ID 4")
+}
+
+func TestTimeoutAndRestart(t *testing.T) {
+	han, err := startFakePlugin(t, "hang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	han.Timeout = 200 * time.Millisecond
+	expectError(t, han.Validate(newFakeModel(han, "Valid")), "no response within 200ms")
+
+	// The plugin is started again by the next request:
+	setFakePluginMode(t, "ok")
+	if err := han.Validate(newFakeModel(han, "Valid")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugin must describe the same kind when started again:
+	if err := han.Close(); err != nil {
+		t.Fatal(err)
+	}
+	setFakePluginMode(t, "other-methods")
+	expectError(t, han.Validate(newFakeModel(han, "Valid")), "the description of kind Fake changed")
+}
+
+func TestGenerateCodeQLPackagePaths(t *testing.T) {
+	han, err := startFakePlugin(t, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &x.BasicQualifier{Path: "example.com/lib", Version: "v1.0.0"}
+	file := cqljen.NewFile()
+	ctx := &x.CodeQLContext{
+		ImportAdder:  file,
+		PackagePaths: x.NewCqlPackagePaths([]*x.BasicQualifier{pkg}),
+	}
+	var genErr error
+	file.Private().Module().Id("Test").BlockFunc(func(group *cqljen.Group) {
+		genErr = han.GenerateCodeQL(ctx, newFakeModel(han, "Valid"), group)
+	})
+	if genErr != nil {
+		t.Fatal(genErr)
+	}
+	got := fmt.Sprintf("%#v", file)
+
+	// The package is matched by the `packagePath()` predicate of the module:
+	expected := `fn.hasQualifiedName(packagePath(), "Write")`
+	if !strings.Contains(withoutSpaces(got), withoutSpaces(expected)) {
+		t.Errorf("expected %q in:\n%s", expected, got)
+	}
+	if strings.Contains(got, "{{") {
+		t.Errorf("packagePath not replaced in:\n%s", got)
+	}
+	if !strings.Contains(got, "import DataFlow::PathGraph") {
+		t.Errorf("import not added in:\n%s", got)
+	}
+}
+
+func TestReplacePackagePaths(t *testing.T) {
+	// Without the `packagePath()` predicates:
+	ctx := &x.CodeQLContext{}
+	code, err := replacePackagePaths(ctx, `predicate isLib(string path) { path = [{{ packagePath "example.com/lib" }}, {{packagePath "fmt"}}] }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := cqljen.NewFile()
+	file.Private().Module().Id("Test").Block(code)
+	got := fmt.Sprintf("%#v", file)
+
+	expected := `predicate isLib(string path) { path = [package("example.com/lib", ""), "fmt"] }`
+	if !strings.Contains(withoutSpaces(got), withoutSpaces(expected)) {
+		t.Errorf("expected %q in:\n%s", expected, got)
+	}
+
+	_, err = replacePackagePaths(ctx, `{{packagePath "\q"}}`)
+	expectError(t, err, "packagePath not valid")
+}
+
+// withoutSpaces returns the string without the whitespace
+// (i.e. ignoring how the codeql code is formatted).
+func withoutSpaces(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/gagliardetto/codemill/x"
+	"github.com/gagliardetto/feparser"
+)
+
+// The protocol spoken with the plugins: codemill starts the plugin as a
+// subprocess, and writes one request per line (JSON) on its stdin;
+// the plugin writes one response per line (JSON) on its stdout, in order.
+// The stderr of the plugin is the one of codemill (i.e. for logs).
+// The plugin must exit when its stdin is closed.
+
+// ProtocolVersion is the version of the protocol.
+const ProtocolVersion = 1
+
+// The methods of the requests:
+const (
+	// MethodDescribe is the first request (each time the plugin is started):
+	// the params are DescribeParams, the result is DescribeResult.
+	MethodDescribe = "describe"
+	// MethodValidate validates a model: the params are ModelParams,
+	// the result is empty.
+	MethodValidate = "validate"
+	// MethodGenerateCodeQL generates the codeql code of a model: the params
+	// are GenerateCodeQLParams, the result is GenerateCodeQLResult.
+	MethodGenerateCodeQL = "generateCodeQL"
+	// MethodGenerateGo generates the go tests of a model: the params
+	// are ModelParams, the result is GenerateGoResult.
+	MethodGenerateGo = "generateGo"
+)
+
+// Request is a request sent to the plugin.
+type Request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the response of the plugin to the request with the same ID;
+// Error is not empty if the request failed.
+type Response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// DescribeParams are the params of MethodDescribe.
+type DescribeParams struct {
+	ProtocolVersion int
+}
+
+// DescribeResult is the ModelKind implemented by the plugin.
+type DescribeResult struct {
+	ProtocolVersion int
+	Kind            x.ModelKind
+	// Methods are the methods of the kind, in order (Name and Description are
+	// required; the examples and constraints are shown in the UI).
+	Methods []*x.MethodDoc
+	// Capabilities are what the selectors of each method can select.
+	Capabilities []*x.MethodCapabilities
+}
+
+// ModelParams are the params of the requests about a model.
+type ModelParams struct {
+	Model *x.XModel
+	// Funcs are the funcs selected in the model, resolved
+	// from the loaded packages (one per func).
+	Funcs []*ResolvedFunc
+}
+
+// ResolvedFunc is a func selected in a model, as found in its loaded package;
+// one of Func, TypeMethod, InterfaceMethod is set.
+type ResolvedFunc struct {
+	ID      string
+	Path    string
+	Version string
+
+	Func            *feparser.FEFunc            `json:",omitempty"`
+	TypeMethod      *feparser.FETypeMethod      `json:",omitempty"`
+	InterfaceMethod *feparser.FEInterfaceMethod `json:",omitempty"`
+	// Elements are the receiver, parameters, and results of the func,
+	// with their absolute index (see FuncQualifier.Pos).
+	Elements *x.FuncQualifierElementsMeta
+}
+
+// GenerateCodeQLParams are the params of MethodGenerateCodeQL.
+type GenerateCodeQLParams struct {
+	ModelParams
+}
+
+// GenerateCodeQLResult is the codeql code of a model, that is added to the
+// module of the spec. The code can refer to the path of a package with
+// {{packagePath "github.com/foo/bar"}}, which is replaced by the codeql
+// expression that matches the package (any major version).
+type GenerateCodeQLResult struct {
+	// Imports are the codeql modules to import (e.g. "DataFlow::PathGraph").
+	Imports []string
+	Code    string
+}
+
+// GenerateGoResult are the files of the go tests of a model.
+type GenerateGoResult struct {
+	Files []*File
+}
+
+// File is a generated file; Path is relative to the folder
+// of the tests (e.g. "ModelName/ModelName.go").
+type File struct {
+	Path    string
+	Content string
+}
+
+// Implementation is implemented by the plugins written in go (see Serve).
+type Implementation interface {
+	Describe() *DescribeResult
+	Validate(params *ModelParams) error
+	GenerateCodeQL(params *GenerateCodeQLParams) (*GenerateCodeQLResult, error)
+	GenerateGo(params *ModelParams) (*GenerateGoResult, error)
+}
+
+// Serve serves the requests read from r (i.e. os.Stdin) with the provided
+// implementation, writing the responses to w (i.e. os.Stdout), until r is closed.
+func Serve(impl Implementation, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return fmt.Errorf("error while parsing request: %s", err)
+		}
+		result, err := serveRequest(impl, &req)
+		resp := &Response{ID: req.ID}
+		if err == nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func serveRequest(impl Implementation, req *Request) (interface{}, error) {
+	switch req.Method {
+	case MethodDescribe:
+		return impl.Describe(), nil
+	case MethodValidate:
+		var params ModelParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return struct{}{}, impl.Validate(&params)
+	case MethodGenerateCodeQL:
+		var params GenerateCodeQLParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return impl.GenerateCodeQL(&params)
+	case MethodGenerateGo:
+		var params ModelParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return impl.GenerateGo(&params)
+	default:
+		return nil, fmt.Errorf("unknown method: %q", req.Method)
+	}
+}
+
+// maxMessageSize is the max size of a request or response (i.e. one line).
+const maxMessageSize = 64 * 1024 * 1024
@@ -7,7 +7,9 @@ import (
 	. "github.com/gagliardetto/utilz"
 )
 
-func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootModuleGroup *Group) error {
+func (han *Handler) GenerateCodeQL(ctx *x.CodeQLContext, mdl *x.XModel, rootModuleGroup *Group) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -25,7 +27,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 	{
 		// Add imports:
-		//impAdder.Import("DataFlow::PathGraph")
+		//ctx.Import("DataFlow::PathGraph")
 	}
 
 	className := mdl.Name
@@ -33,7 +35,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 
 	b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(self)
 	if err != nil {
-		Fatalf("Error while GroupFuncSelectors: %s", err)
+		x.Failf("Error while GroupFuncSelectors: %s", err)
 	}
 	{
 		addedCount := 0
@@ -65,7 +67,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 														ParensFunc(
 															func(par *Group) {
 																par.Commentf("signature: %s", thing.Signature)
-																par.This().Dot("hasQualifiedName").Call(x.CqlFormatPackagePath(funcQual.Path), Lit(thing.Name))
+																par.This().Dot("hasQualifiedName").Call(ctx.FormatPackagePath(funcQual.Path), Lit(thing.Name))
 																par.And()
 
 																joined := Join(
@@ -137,12 +139,12 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 															qual := methodQualifiers[0]
 															source := x.GetCachedSource(qual.Path, qual.Version)
 															if source == nil {
-																Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+																x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 															}
 															// Find receiver type:
 															typ := x.FindTypeByID(source, receiverTypeID)
 															if typ == nil {
-																Fatalf("Type not found: %q", receiverTypeID)
+																x.Failf("Type not found: %q", receiverTypeID)
 															}
 
 															mtdGroup.Commentf("Receiver type: %s", typ.TypeString)
@@ -165,7 +167,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 																		parMethods.ParensFunc(
 																			func(par *Group) {
 																				par.Commentf("signature: %s", thing.Func.Signature)
-																				par.This().Dot("hasQualifiedName").Call(x.CqlFormatPackagePath(methodQual.Path), Lit(thing.Receiver.TypeName), Lit(thing.Func.Name))
+																				par.This().Dot("hasQualifiedName").Call(ctx.FormatPackagePath(methodQual.Path), Lit(thing.Receiver.TypeName), Lit(thing.Func.Name))
 																				par.And()
 
 																				joined := Join(
@@ -197,12 +199,12 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 														qual := methodQualifiers[0]
 														source := x.GetCachedSource(qual.Path, qual.Version)
 														if source == nil {
-															Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+															x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 														}
 														// Find receiver type:
 														typ := x.FindTypeByID(source, receiverTypeID)
 														if typ == nil {
-															Fatalf("Type not found: %q", receiverTypeID)
+															x.Failf("Type not found: %q", receiverTypeID)
 														}
 														mtdGroup.Commentf("Receiver interface: %s", typ.TypeString)
 
@@ -224,7 +226,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, rootMo
 																	parMethods.ParensFunc(
 																		func(par *Group) {
 																			par.Commentf("signature: %s", thing.Func.Signature)
-																			par.This().Dot("implements").Call(x.CqlFormatPackagePath(methodQual.Path), Lit(thing.Receiver.TypeName), Lit(thing.Func.Name))
+																			par.This().Dot("implements").Call(ctx.FormatPackagePath(methodQual.Path), Lit(thing.Receiver.TypeName), Lit(thing.Func.Name))
 																			par.And()
 
 																			joined := Join(
@@ -284,12 +286,12 @@ func GetFuncQualifierCodeElements(qual *x.FuncQualifier) (x.FuncInterface, []Cod
 
 	source := x.GetCachedSource(qual.Path, qual.Version)
 	if source == nil {
-		Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+		x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 	}
 	// Find the func/type-method/interface-method:
 	fn := x.FindFuncByID(source, qual.ID)
 	if fn == nil {
-		Fatalf("Func not found: %q", qual.ID)
+		x.Failf("Func not found: %q", qual.ID)
 	}
 
 	codeElements := make([]Code, 0)
@@ -0,0 +1,51 @@
+package tainttracking
+
+import (
+	"github.com/gagliardetto/codemill/x"
+)
+
+// GenerateModelsAsData generates a summary row
+// for each input/output pair of each flow block.
+func (han *Handler) GenerateModelsAsData(mdl *x.XModel) ([]*x.MaDRow, error) {
+	if err := mdl.Validate(); err != nil {
+		return nil, err
+	}
+	if err := han.Validate(mdl); err != nil {
+		return nil, err
+	}
+
+	// Assuming the validation has already been done:
+	self := mdl.Methods[0]
+
+	rows := make([]*x.MaDRow, 0)
+	for _, sel := range self.Selectors {
+		qual := sel.GetFuncQualifier()
+		if qual == nil || !x.HasValidEnabledFlow(qual) {
+			continue
+		}
+		base, fn, err := x.NewMaDFuncRow(x.MaDSummaryModel, qual)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range qual.Flows.Blocks {
+			inputs, err := x.FormatMaDAccessPaths(fn, block.Inp)
+			if err != nil {
+				return nil, err
+			}
+			outputs, err := x.FormatMaDAccessPaths(fn, block.Out)
+			if err != nil {
+				return nil, err
+			}
+			for _, input := range inputs {
+				for _, output := range outputs {
+					row := *base
+					row.Input = input
+					row.Output = output
+					row.Kind = "taint"
+					rows = append(rows, &row)
+				}
+			}
+		}
+	}
+	return rows, nil
+}
@@ -18,10 +18,35 @@ const (
 
 //
 func (han *Handler) ScavengeMethods() []*x.XMethod {
-	return []*x.XMethod{
+	return x.ScavengeMethodsFromDocs(han.MethodDocs())
+}
+
+// MethodDocs returns the docs of the methods of the kind.
+func (han *Handler) MethodDocs() []*x.MethodDoc {
+	return []*x.MethodDoc{
+		{
+			Name:        MethodSelf,
+			Description: "Select the flows of taint through the func: taint that enters from the inputs comes out from the outputs.",
+			Examples: []string{
+				"strings.ToUpper(s string) string: from parameter s to the result.",
+				"io.Copy(dst io.Writer, src io.Reader) (int64, error): from parameter src to parameter dst.",
+				"func (b *Buffer) Write(p []byte) (int, error): from parameter p to the receiver.",
+			},
+			Constraints: []string{
+				"Each flow block must have at least one input and one output.",
+			},
+		},
+	}
+}
+
+// Capabilities returns the capabilities of the methods of the kind.
+func (han *Handler) Capabilities() []*x.MethodCapabilities {
+	return []*x.MethodCapabilities{
 		{
-			Name:      MethodSelf,
-			Selectors: []*x.XSelector{},
+			Name:          MethodSelf,
+			SelectorKinds: []x.SelectorKind{x.SelectorKindFunc},
+			Mode:          x.SelectionModeFlow,
+			Roles:         x.AllElementRoles,
 		},
 	}
 }
@@ -10,7 +10,9 @@ import (
 	. "github.com/gagliardetto/utilz"
 )
 
-func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, moduleGroup *Group) error {
+func (han *Handler) GenerateCodeQL(ctx *x.CodeQLContext, mdl *x.XModel, moduleGroup *Group) (err error) {
+	defer x.CatchGenerationError(&err)
+
 	if err := mdl.Validate(); err != nil {
 		return err
 	}
@@ -28,7 +30,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 
 	{
 		// Add imports:
-		//impAdder.Import("DataFlow::PathGraph")
+		//ctx.Import("DataFlow::PathGraph")
 	}
 
 	className := mdl.Name
@@ -39,7 +41,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 			classGr.Id(className).Call().BlockFunc(func(metGr *Group) {
 				b2fe, b2tm, b2itm, err := x.GroupFuncSelectors(self)
 				if err != nil {
-					Fatalf("Error while GroupFuncSelectors: %s", err)
+					x.Failf("Error while GroupFuncSelectors: %s", err)
 				}
 
 				{
@@ -83,7 +85,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 									fn, codeElements := GetFuncQualifierCodeElements(funcQual)
 									thing := fn.(*feparser.FEFunc)
 									st.Comment("signature: " + thing.Signature)
-									st.Id("fn").Dot("hasQualifiedName").Call(x.CqlFormatPackagePath(funcQual.Path), Lit(thing.Name)).
+									st.Id("fn").Dot("hasQualifiedName").Call(ctx.FormatPackagePath(funcQual.Path), Lit(thing.Name)).
 										And().
 										Parens(
 											Join(
@@ -137,7 +139,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 								st.And()
 
 								st.Id("mtd").Dot("hasQualifiedName").Call(
-									x.CqlFormatPackagePath(path),
+									ctx.FormatPackagePath(path),
 									Id("receiverName"),
 									Id("methodName"),
 								)
@@ -165,12 +167,12 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 									qual := methodQualifiers[0]
 									source := x.GetCachedSource(qual.Path, qual.Version)
 									if source == nil {
-										Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+										x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 									}
 									// Find receiver type:
 									typ := x.FindTypeByID(source, receiverTypeID)
 									if typ == nil {
-										Fatalf("Type not found: %q", receiverTypeID)
+										x.Failf("Type not found: %q", receiverTypeID)
 									}
 
 									st.Id("receiverName").Eq().Lit(typ.TypeString)
@@ -252,7 +254,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 								st.And()
 
 								st.Id("mtd").Dot("implements").Call(
-									x.CqlFormatPackagePath(path),
+									ctx.FormatPackagePath(path),
 									Id("interfaceName"),
 									Id("methodName"),
 								)
@@ -280,13 +282,13 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 									qual := methodQualifiers[0]
 									source := x.GetCachedSource(qual.Path, qual.Version)
 									if source == nil {
-										Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+										x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 									}
 
 									// Find interface type:
 									typ := x.FindTypeByID(source, receiverTypeID)
 									if typ == nil {
-										Fatalf("Type not found: %q", receiverTypeID)
+										x.Failf("Type not found: %q", receiverTypeID)
 									}
 
 									st.Id("interfaceName").Eq().Lit(typ.TypeString)
@@ -331,7 +333,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 
 				b2st, err := x.GroupStructSelectors(self)
 				if err != nil {
-					Fatalf("Error while GroupStructSelectors: %s", err)
+					x.Failf("Error while GroupStructSelectors: %s", err)
 				}
 				if (len(b2fe) > 0 || len(b2tm) > 0 || len(b2itm) > 0) && len(b2st) > 0 {
 					metGr.Or()
@@ -370,7 +372,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 								st.And()
 
 								st.Id("fld").Dot("hasQualifiedName").Call(
-									x.CqlFormatPackagePath(path),
+									ctx.FormatPackagePath(path),
 									Id("structName"),
 									Id("fields"),
 								)
@@ -382,19 +384,19 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 									}
 									source := x.GetCachedSource(qual.Path, qual.Version)
 									if source == nil {
-										Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+										x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 									}
 									// Make sure that the struct exist:
 									str := x.FindStructByID(source, qual.ID)
 									if str == nil {
-										Fatalf("Struct not found: %q", qual.ID)
+										x.Failf("Struct not found: %q", qual.ID)
 									}
 
 									fieldNames := make([]string, 0)
 									for fieldName := range qual.Fields {
 										//fld := x.FindFieldByName(str, fieldName)
 										//if fld == nil {
-										//	Fatalf("Field not found: %q", fieldName)
+										//	x.Failf("Field not found: %q", fieldName)
 										//}
 										// TODO: add a comment on the type for each field?
 										fieldNames = append(fieldNames, fieldName)
@@ -412,7 +414,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 
 				b2typ, err := x.GroupTypeSelectors(self)
 				if err != nil {
-					Fatalf("Error while GroupTypeSelectors: %s", err)
+					x.Failf("Error while GroupTypeSelectors: %s", err)
 				}
 				if (len(b2fe) > 0 || len(b2tm) > 0 || len(b2itm) > 0 || len(b2st) > 0) && len(b2typ) > 0 {
 					metGr.Or()
@@ -448,12 +450,12 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 								for _, qual := range typeQualifiers {
 									source := x.GetCachedSource(qual.Path, qual.Version)
 									if source == nil {
-										Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+										x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 									}
 									// Find the type:
 									typ := x.FindTypeByID(source, qual.ID)
 									if typ == nil {
-										Fatalf("Type not found: %q", qual.ID)
+										x.Failf("Type not found: %q", qual.ID)
 									}
 									typeNames = append(typeNames, typ.TypeName)
 								}
@@ -461,7 +463,7 @@ func (han *Handler) GenerateCodeQL(impAdder x.ImportAdder, mdl *x.XModel, module
 								sort.Strings(typeNames)
 
 								st.Id("v").Dot("getType").Call().Dot("hasQualifiedName").Call(
-									x.CqlFormatPackagePath(path),
+									ctx.FormatPackagePath(path),
 									StringsToSetOrLit(typeNames...),
 								)
 							}),
@@ -482,12 +484,12 @@ func GetFuncQualifierCodeElements(qual *x.FuncQualifier) (x.FuncInterface, []Cod
 
 	source := x.GetCachedSource(qual.Path, qual.Version)
 	if source == nil {
-		Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+		x.Failf("Source not found: %s@%s", qual.Path, qual.Version)
 	}
 	// Find the func/type-method/interface-method:
 	fn := x.FindFuncByID(source, qual.ID)
 	if fn == nil {
-		Fatalf("Func not found: %q", qual.ID)
+		x.Failf("Func not found: %q", qual.ID)
 	}
 
 	receiver, parameterIndexes, resultIndexes := x.PosToRelativeIndexes(fn, qual.Pos)
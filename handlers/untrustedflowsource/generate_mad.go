@@ -0,0 +1,70 @@
+package untrustedflowsource
+
+import (
+	"sort"
+
+	"github.com/gagliardetto/codemill/x"
+	. "github.com/gagliardetto/utilz"
+)
+
+// GenerateModelsAsData generates a `remote` source row for each
+// selected output of a func, and for each selected field of a struct;
+// types cannot be expressed as models-as-data, so the selected ones
+// are reported as unsupported.
+func (han *Handler) GenerateModelsAsData(mdl *x.XModel) ([]*x.MaDRow, error) {
+	if err := mdl.Validate(); err != nil {
+		return nil, err
+	}
+	if err := han.Validate(mdl); err != nil {
+		return nil, err
+	}
+
+	// Assuming the validation has already been done:
+	self := mdl.Methods[0]
+
+	rows := make([]*x.MaDRow, 0)
+	var unsupported x.MaDUnsupported
+	for _, sel := range self.Selectors {
+		switch qual := sel.Qualifier.(type) {
+		case *x.FuncQualifier:
+			if !x.HasValidPos(qual) {
+				continue
+			}
+			base, fn, err := x.NewMaDFuncRow(x.MaDSourceModel, qual)
+			if err != nil {
+				return nil, err
+			}
+			outputs, err := x.FormatMaDAccessPaths(fn, qual.Pos)
+			if err != nil {
+				return nil, err
+			}
+			for _, output := range outputs {
+				row := *base
+				row.Output = output
+				row.Kind = "remote"
+				rows = append(rows, &row)
+			}
+		case *x.StructQualifier:
+			fieldNames := make([]string, 0, len(qual.Fields))
+			for fieldName := range qual.Fields {
+				fieldNames = append(fieldNames, fieldName)
+			}
+			sort.Strings(fieldNames)
+			for _, fieldName := range fieldNames {
+				row := x.NewMaDFieldRow(x.MaDSourceModel, qual, fieldName)
+				row.Kind = "remote"
+				rows = append(rows, row)
+			}
+		case *x.TypeQualifier:
+			if qual.Value {
+				unsupported.Addf("type %s of %s: types cannot be expressed as models-as-data", qual.TypeName, qual.PathVersion())
+			}
+		default:
+			panic(Sf("Unknown type: %T", sel.Qualifier))
+		}
+	}
+	if err := unsupported.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -18,10 +18,32 @@ const (
 
 //
 func (han *Handler) ScavengeMethods() []*x.XMethod {
-	return []*x.XMethod{
+	return x.ScavengeMethodsFromDocs(han.MethodDocs())
+}
+
+// MethodDocs returns the docs of the methods of the kind.
+func (han *Handler) MethodDocs() []*x.MethodDoc {
+	return []*x.MethodDoc{
+		{
+			Name:        MethodSelf,
+			Description: "Select the elements that hold data controlled by a remote user: results (or receivers, parameters) of funcs, fields of structs, and types.",
+			Examples: []string{
+				"func (c *Context) Query(key string) string: the result.",
+				"func (c *Context) Bind(obj interface{}) error: parameter obj.",
+				"http.Request: fields Header, Body, URL.",
+			},
+		},
+	}
+}
+
+// Capabilities returns the capabilities of the methods of the kind.
+func (han *Handler) Capabilities() []*x.MethodCapabilities {
+	return []*x.MethodCapabilities{
 		{
-			Name:      MethodSelf,
-			Selectors: []*x.XSelector{},
+			Name:          MethodSelf,
+			SelectorKinds: []x.SelectorKind{x.SelectorKindFunc, x.SelectorKindStruct, x.SelectorKindType},
+			Mode:          x.SelectionModePos,
+			Roles:         x.AllElementRoles,
 		},
 	}
 }
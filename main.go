@@ -2,24 +2,25 @@ package main
 
 //go:generate statik -src=./public -include=*.html,*.css,*.js
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gagliardetto/codebox/scanner"
 	_ "github.com/gagliardetto/codemill/statik"
 	"github.com/gagliardetto/codemill/x"
-	cqljen "github.com/gagliardetto/cqlgen/jen"
 	"github.com/gagliardetto/feparser"
 	"github.com/gagliardetto/golang-go/cmd/go/not-internal/get"
 	"github.com/gagliardetto/golang-go/cmd/go/not-internal/modfetch"
@@ -35,6 +36,7 @@ import (
 	"github.com/gagliardetto/codemill/handlers/http/headerwrite"
 	"github.com/gagliardetto/codemill/handlers/http/redirect"
 	"github.com/gagliardetto/codemill/handlers/http/responsebody"
+	"github.com/gagliardetto/codemill/handlers/plugin"
 	"github.com/gagliardetto/codemill/handlers/tainttracking"
 	"github.com/gagliardetto/codemill/handlers/untrustedflowsource"
 )
@@ -47,11 +49,207 @@ const (
 )
 
 var (
-	globalSpec *x.XSpec
+	// workspace holds the specs served by this process:
+	// the one provided with --spec, or the ones in the --workspace folder.
+	workspace *x.Workspace
 )
 
+// specFileHeader is the response header with the file of the spec of the request
+// (see requestEntry); the UI sends it back in the spec param of its requests.
+const specFileHeader = "X-Codemill-Spec"
+
+// specEntryKey is the key of the entry of the request in the gin context.
+const specEntryKey = "codemill.specEntry"
+
+// requestEntry returns the workspace entry of the spec of the request, i.e. the one
+// of the file in the spec param or, if not provided, the active one; each browser
+// tab sends the file of the spec it shows, so that its requests do not apply to the
+// spec activated by another tab. If not found, the request is aborted and nil is returned.
+func requestEntry(c *gin.Context) *x.WorkspaceEntry {
+	if entry, ok := c.Get(specEntryKey); ok {
+		return entry.(*x.WorkspaceEntry)
+	}
+	var entry *x.WorkspaceEntry
+	if file := c.Query("spec"); file != "" {
+		loaded, err := workspace.Load(file)
+		if err != nil {
+			Abort404(c, err.Error())
+			return nil
+		}
+		entry = loaded
+	} else {
+		entry = workspace.Active()
+		if entry == nil {
+			Abort404(c, "No active spec")
+			return nil
+		}
+	}
+	c.Set(specEntryKey, entry)
+	c.Header(specFileHeader, entry.File)
+	return entry
+}
+
+// requestSpec returns the spec of the request (see requestEntry), or nil.
+func requestSpec(c *gin.Context) *x.XSpec {
+	entry := requestEntry(c)
+	if entry == nil {
+		return nil
+	}
+	return entry.Spec()
+}
+
 func main() {
+	registerHandlers()
+
+	// Subcommands (e.g. `codemill validate`) have their own flags:
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	var specFilepath string
+	var workspaceDir string
+	var outDir string
+	var runServer bool
+	var doGen bool
+	var multiversion bool
+	var outputFormats string
+	server := &serverOptions{}
+	flag.StringVar(&specFilepath, "spec", "", "Path to spec file (.json, or .yaml for the readable format); file will be created if not already existing. With --workspace, the name of the spec file to open first.")
+	flag.StringVar(&workspaceDir, "workspace", "", "Path to a folder of spec files; the UI can open, create, and switch between them.")
+	flag.StringVar(&outDir, "dir", "", "Path to dir where to save generated files.")
+	flag.BoolVar(&runServer, "http", true, "Run http server.")
+	flag.BoolVar(&doGen, "gen", true, "Generate code.")
+	flag.BoolVar(&multiversion, "multiversion", false, "Union the selections of multiple versions of the same package by package path (conflicting selections fail the generation); otherwise, each version is generated on its own.")
+	flag.BoolVar(&x.TypeCheckGeneratedGo, "typecheck", true, "Type-check the generated Go tests (against the loaded packages) before writing them.")
+	flag.StringVar(&outputFormats, "output", "qll", "Comma-separated formats of the generated codeql models: qll, mad-yaml (models-as-data extension), or mad-csv.")
+	flag.StringVar(&server.Listen, "listen", "127.0.0.1:8070", "Address (host:port) the http server listens on.")
+	flag.StringVar(&server.Token, "token", "", "Token required to access the http server (as `Authorization: Bearer <token>`, or with the ?token= URL printed at start); \"auto\" generates a random one.")
+	flag.StringVar(&server.AllowedOrigins, "allowed-origins", "", "Comma-separated origins (e.g. https://codemill.example.com) allowed to modify specs, besides the one of the server.")
+	flag.StringVar(&server.TLSCert, "tls-cert", "", "Path to the TLS certificate; serve https (requires --tls-key).")
+	flag.StringVar(&server.TLSKey, "tls-key", "", "Path to the TLS private key.")
+	flag.Parse()
+
+	if err := server.Setup(); err != nil {
+		panic(err)
+	}
+
+	outputs, err := parseOutputFormats(outputFormats)
+	if err != nil {
+		panic(err)
+	}
+
+	if specFilepath == "" && workspaceDir == "" {
+		// specFilepath is ALWAYS necessary (without a workspace),
+		// either for knowing from where to load a spec,
+		// or where to save a new created one.
+		panic("--spec flag not provided")
+	}
+
+	if outDir == "" {
+		panic("--dir flag not provided")
+	}
+
+	if workspaceDir != "" {
+		workspace, err = x.OpenWorkspace(workspaceDir, LoadPackage)
+		if err != nil {
+			panic(err)
+		}
+		first := filepath.Base(specFilepath)
+		if specFilepath == "" {
+			entries := workspace.List()
+			if len(entries) == 0 {
+				// Empty workspace: start with a new spec.
+				entry, err := workspace.CreateSpec("DefaultSpec")
+				if err != nil {
+					panic(err)
+				}
+				entries = append(entries, entry)
+			}
+			first = entries[0].File
+		}
+		if _, err := workspace.Activate(first); err != nil {
+			panic(err)
+		}
+	} else {
+		var spec *x.XSpec
+		if MustFileExists(specFilepath) {
+			// If the file exists, try loading the spec:
+			spec, err = x.TryLoadSpecFromFile(specFilepath, LoadPackage)
+			if err != nil {
+				panic(err)
+			}
+		} else {
+			// If the file does NOT exist,
+			// create a new spec named after the filename:
+			name := ToCamel(TrimExt(filepath.Base(specFilepath)))
+			if name == "" {
+				name = "DefaultSpec"
+			}
+			spec = x.NewXSpecWithName(name)
+		}
+		workspace = x.NewWorkspace(LoadPackage)
+		entry := workspace.AddSpec(specFilepath, spec)
+		if _, err := workspace.Activate(entry.File); err != nil {
+			panic(err)
+		}
+	}
+
+	onExitCallback := func() {
+		// Save all the loaded specs:
+		loaded := workspace.ListLoaded()
+		for _, entry := range loaded {
+			entry.Spec().Lock()
+			// TODO: cleanup before saving.
+			err := entry.Save(false)
+			entry.Spec().Unlock()
+			if err != nil {
+				panic(err)
+			}
+		}
+		if !doGen {
+			Ln(LimeBG(">>> Completed without generation <<<"))
+			os.Exit(0)
+		}
+		// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+		// NOTE: after this point, any modification to the specs will be volatile,
+		// i.e. discarded the instant this program hits os.Exit.
+		// <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<
+
+		for _, entry := range loaded {
+			spec := entry.Spec()
+			spec.Lock()
+
+			// Sort stuff for visual convenience in the generated code:
+			spec.Sort()
+
+			if _, err := generateAssets(spec, outDir, multiversion, outputs); err != nil {
+				Fatalf("%s", err)
+			}
+			spec.Unlock()
+		}
+
+		Ln(LimeBG(">>> Generation completed <<<"))
+		os.Exit(0)
+	}
+
+	var once sync.Once
+	go Notify(
+		func(os.Signal) bool {
+			once.Do(onExitCallback)
+			return false
+		},
+		os.Kill,
+		os.Interrupt,
+	)
+	defer once.Do(onExitCallback)
+
 	r := gin.Default()
+	// Check the origin and the credentials of the requests:
+	r.Use(server.Middlewares()...)
+	// Edits of the spec are checked against (and bump) its revision:
+	r.Use(specRevisionMiddleware)
 
 	statikFS, err := fs.New()
 	if err != nil {
@@ -100,262 +298,297 @@ func main() {
 	}
 	httpClient := new(http.Client)
 
-	var specFilepath string
-	var outDir string
-	var runServer bool
-	var doGen bool
-	flag.StringVar(&specFilepath, "spec", "", "Path to spec file; file will be created if not already existing.")
-	flag.StringVar(&outDir, "dir", "", "Path to dir where to save generated files.")
-	flag.BoolVar(&runServer, "http", true, "Run http server.")
-	flag.BoolVar(&doGen, "gen", true, "Generate code.")
-	flag.Parse()
-
-	if specFilepath == "" {
-		// specFilepath is ALWAYS necessary,
-		// either for knowing from where to load a spec,
-		// or where to save a new created one.
-		panic("--spec flag not provided")
-	}
+	r.GET("/api/spec", func(c *gin.Context) {
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
 
-	if outDir == "" {
-		panic("--dir flag not provided")
-	}
+		spec.RLock()
+		defer spec.RUnlock()
+		c.Header("ETag", spec.ETag())
+		c.IndentedJSON(200, spec)
+	})
 
-	{
-		rt := x.Router()
-		// Register ModelKind handlers in the router:
-		{
-			// untrustedflowsource handler:
-			err := rt.RegisterHandler(untrustedflowsource.Kind, &untrustedflowsource.Handler{})
-			if err != nil {
-				Fatalf("error while registering handler: %s", err)
+	r.GET("/api/events", func(c *gin.Context) {
+		// Stream (as server-sent events) the modifications of the specs,
+		// and the switches of the active spec:
+		events, unsubscribe := specEvents.Subscribe()
+		defer unsubscribe()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event := <-events:
+				c.SSEvent(event.Name, event.Data)
+				return true
+			case <-c.Request.Context().Done():
+				return false
 			}
+		})
+	})
 
-			// tainttracking handler:
-			err = rt.RegisterHandler(tainttracking.Kind, &tainttracking.Handler{})
-			if err != nil {
-				Fatalf("error while registering handler: %s", err)
-			}
+	r.GET("/api/cached", func(c *gin.Context) {
+		// List already cached sources:
+		list := x.GetListCachedSources()
 
-			// http redirect handler:
-			err = rt.RegisterHandler(redirect.Kind, &redirect.Handler{})
-			if err != nil {
-				Fatalf("error while registering handler: %s", err)
-			}
+		sort.Slice(list, func(i, j int) bool {
+			return x.FormatPathVersion(list[i].Path, list[i].Version) < x.FormatPathVersion(list[j].Path, list[j].Version)
+		})
+		c.IndentedJSON(200, M{"results": list})
+	})
 
-			// http responsebody handler:
-			err = rt.RegisterHandler(responsebody.Kind, &responsebody.Handler{})
+	r.GET("/api/models/kinds", func(c *gin.Context) {
+		// List available model kinds:
+		kinds := x.Router().ListModelKinds()
+		sort.Slice(kinds, func(i, j int) bool {
+			return kinds[i] < kinds[j]
+		})
+		results := make([]M, 0)
+		for _, kind := range kinds {
+			// What the selectors of each method can select:
+			methods, err := x.Router().GetCapabilities(kind)
 			if err != nil {
-				Fatalf("error while registering handler: %s", err)
+				abort(c, 500, err.Error())
+				return
 			}
-
-			// http headerwrite handler:
-			err = rt.RegisterHandler(headerwrite.Kind, &headerwrite.Handler{})
+			// What each method is for, with examples and constraints:
+			docs, err := x.Router().GetMethodDocs(kind)
 			if err != nil {
-				Fatalf("error while registering handler: %s", err)
+				abort(c, 500, err.Error())
+				return
 			}
+			results = append(results, M{
+				"kind":    kind,
+				"methods": methods,
+				"docs":    docs,
+			})
 		}
-	}
+		c.IndentedJSON(200, M{"results": results})
+	})
 
-	if MustFileExists(specFilepath) {
-		// If the file exists, try loading the spec:
-		spec, err := x.TryLoadSpecFromFile(specFilepath, LoadPackage)
-		if err != nil {
-			panic(err)
-		}
-		globalSpec = spec
-	} else {
-		// If the file does NOT exist,
-		// create a new spec named after the filename:
-		name := ToCamel(TrimExt(filepath.Base(specFilepath)))
-		if name == "" {
-			name = "DefaultSpec"
+	r.GET("/api/preview", func(c *gin.Context) {
+		// Preview the codeql and go code generated for a model
+		// (nothing is written to disk):
+		spec := requestSpec(c)
+		if spec == nil {
+			return
 		}
-		globalSpec = x.NewXSpecWithName(name)
-	}
 
-	onExitCallback := func() {
-		globalSpec.Lock()
-		defer globalSpec.Unlock()
+		name := c.Query("model")
 
-		globalSpec.RemoveMeta()
-		// TODO: cleanup before saving.
+		spec.RLock()
+		defer spec.RUnlock()
 
-		Infof("Saving spec to %q", MustAbs(specFilepath))
-		err := SaveAsJSON(globalSpec, specFilepath)
-		if err != nil {
-			panic(err)
+		var mdl *x.XModel
+		for _, candidate := range spec.Models {
+			if candidate.Name == name {
+				mdl = candidate
+			}
 		}
-		if !doGen {
-			Ln(LimeBG(">>> Completed without generation <<<"))
-			os.Exit(0)
+		if mdl == nil {
+			Abort404(c, Sf("Model not found: %q", name))
+			return
+		}
+		if err := x.CheckModelCapabilities(mdl); err != nil {
+			Abort400(c, Sf("Model %q (kind=%s) has a selection not supported by its kind: %s", mdl.Name, mdl.Kind, err))
+			return
+		}
+		previewSpec := &x.XSpec{
+			Name:    spec.Name,
+			Models:  []*x.XModel{mdl},
+			RWMutex: &sync.RWMutex{},
 		}
-		// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
-		// NOTE: after this point, any modification to globalSpec will be volatile,
-		// i.e. discarded the instant this program hits os.Exit.
-		// <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<
-
-		// Sort stuff for visual convenience in the generated code:
-		globalSpec.Sort()
-
-		// Create output dir if it doesn't exist:
-		MustCreateFolderIfNotExists(outDir, os.ModePerm)
-
-		ts := time.Now()
-		// Create subfolder for package for generated assets:
-		packageAssetFolderName := feparser.FormatCodeQlName(globalSpec.Name)
-		packageAssetFolderPath := path.Join(outDir, packageAssetFolderName)
-		MustCreateFolderIfNotExists(packageAssetFolderPath, os.ModePerm)
-		// Create folder for assets generated during this run:
-		thisRunAssetFolderName := feparser.FormatCodeQlName(globalSpec.Name) + "_" + ts.Format(FilenameTimeFormat)
-		thisRunAssetFolderPath := path.Join(packageAssetFolderPath, thisRunAssetFolderName)
-		// Create a new assets folder inside the main assets folder:
-		MustCreateFolderIfNotExists(thisRunAssetFolderPath, os.ModePerm)
-
-		{
-			// Validate all specs:
-			for _, mdl := range globalSpec.Models {
-
-				handler := x.Router().GetHandler(mdl.Kind)
-				if handler == nil {
-					Fatalf(
-						"handler not found for kind %s",
-						mdl.Kind,
-					)
-				}
 
-				{
-					// Validate provided model:
-					err := handler.Validate(mdl)
-					if err != nil {
-						Fatalf(
-							"error while validating model %q (kind=%s): %s",
-							mdl.Name,
-							mdl.Kind,
-							err,
-						)
-					}
-				}
-			}
+		cqlFile, err := generateCodeQL(previewSpec, multiversion)
+		if err != nil {
+			Abort400(c, err.Error())
+			return
+		}
+		var buf bytes.Buffer
+		if err := cqlFile.Render(&buf); err != nil {
+			Abort400(c, Sf("error while rendering codeql: %s", err))
+			return
 		}
+		codeql := buf.String()
 
-		{ // Generate codeql:
-			cqlFile := cqljen.NewFile()
-			for _, hdr := range x.CqlFormatHeaderDoc(globalSpec.ListModules()) {
-				cqlFile.HeaderDoc(hdr)
-			}
+		vfs := x.NewVirtualFS()
+		if err := generateGo(vfs, previewSpec); err != nil {
+			Abort400(c, err.Error())
+			return
+		}
+		files := make([]M, 0)
+		for _, filePath := range vfs.Paths() {
+			content, _ := vfs.ReadFile(filePath)
+			files = append(files, M{
+				"path":    filePath,
+				"content": string(content),
+			})
+		}
 
-			// `go` is always imported:
-			cqlFile.Import("go")
+		c.IndentedJSON(200, M{
+			"codeql": codeql,
+			"files":  files,
+		})
+	})
 
-			cqlFile.Doc(x.CqlFormatHeaderDoc(globalSpec.ListModules())...)
-			cqlFile.Private().Module().Id(feparser.FormatCodeQlName(globalSpec.Name)).BlockFunc(func(moduleGroup *cqljen.Group) {
-				for _, mdl := range globalSpec.Models {
+	r.POST("/api/generate", func(c *gin.Context) {
+		// Save the spec(s), generate all the assets in a new folder
+		// (the server keeps running), and download them as an archive.
+		// The spec param is the file of the spec to generate (default: the active one),
+		// or "all" to generate all the specs of the workspace.
 
-					handler := x.Router().MustGetHandler(mdl.Kind)
-					{
-						// Generate codeql with the handler of the ModelKind;
-						// the handler might generate predicates, classes, etc.
-						// all within the module block.
-						err := handler.GenerateCodeQL(cqlFile, mdl, moduleGroup)
-						if err != nil {
-							Fatalf(
-								"error while generating codeql code for model %q (kind=%s): %s",
-								mdl.Name,
-								mdl.Kind,
-								err,
-							)
-						}
-					}
+		format := c.DefaultQuery("format", "zip")
+		if !SliceContains(x.ArchiveFormats, format) {
+			Abort400(c, Sf("Archive format not valid: %q", format))
+			return
+		}
 
+		entries := make([]*x.WorkspaceEntry, 0)
+		if c.Query("spec") == "all" {
+			for _, entry := range workspace.List() {
+				loaded, err := workspace.Load(entry.File)
+				if err != nil {
+					Abort400(c, err.Error())
+					return
+				}
+				entries = append(entries, loaded)
+			}
+		} else {
+			entry := requestEntry(c)
+			if entry == nil {
+				return
+			}
+			entries = append(entries, entry)
+		}
+
+		runDirs := make([]string, 0)
+		for _, entry := range entries {
+			runDir, err := func() (string, error) {
+				specEditMu.Lock()
+				defer specEditMu.Unlock()
+				spec := entry.Spec()
+				spec.Lock()
+				defer spec.Unlock()
+				// The spec gets sorted, so its revision changes:
+				defer publishSpecRevision(entry)
+				defer spec.BumpRevision()
+
+				if err := entry.Save(false); err != nil {
+					return "", fmt.Errorf("Error saving spec: %s", err)
 				}
 
-			})
-			{
-				// Save codeql assets:
-				assetFileName := feparser.FormatCodeQlName(globalSpec.Name) + ".qll"
-				assetFilepath := path.Join(thisRunAssetFolderPath, assetFileName)
+				// Sort stuff for visual convenience in the generated code:
+				spec.Sort()
 
-				// Create file codeql file:
-				codeqlFile, err := os.Create(assetFilepath)
-				if err != nil {
-					panic(err)
+				runDir, genErr := generateAssets(spec, outDir, multiversion, outputs)
+				// The meta is needed by the UI:
+				if err := spec.AddMeta(); err != nil {
+					return "", fmt.Errorf("Error adding meta: %s", err)
 				}
-				defer codeqlFile.Close()
-
-				// Write generated codeql to file:
-				Infof("Saving codeql assets to %q", MustAbs(assetFilepath))
-				err = cqlFile.Render(codeqlFile)
-				if err != nil {
-					panic(err)
+				if genErr != nil {
+					return "", fmt.Errorf("Error generating assets of spec %q: %s", spec.Name, genErr)
 				}
+				return runDir, nil
+			}()
+			if err != nil {
+				Abort400(c, err.Error())
+				return
 			}
+			runDirs = append(runDirs, runDir)
 		}
-		{
-			goTestsFolderPath := path.Join(thisRunAssetFolderPath, "tests")
-			// Create a folder for Go code:
-			MustCreateFolderIfNotExists(goTestsFolderPath, os.ModePerm)
-			// Generate Go code:
-			for _, mdl := range globalSpec.Models {
-
-				handler := x.Router().MustGetHandler(mdl.Kind)
-				{
-					err := handler.GenerateGo(goTestsFolderPath, mdl)
-					if err != nil {
-						Fatalf(
-							"error while generating Go code for model %q (kind=%s): %s",
-							mdl.Name,
-							mdl.Kind,
-							err,
-						)
-					}
-				}
 
+		var buf bytes.Buffer
+		if err := x.WriteArchive(&buf, format, runDirs...); err != nil {
+			abort(c, 500, Sf("Error creating archive: %s", err))
+			return
+		}
+		archiveName := "codemill_" + time.Now().Format(FilenameTimeFormat)
+		if len(runDirs) == 1 {
+			archiveName = filepath.Base(runDirs[0])
+		}
+		contentType := map[string]string{
+			"zip":    "application/zip",
+			"tar.gz": "application/gzip",
+		}[format]
+		c.Header("Content-Disposition", Sf("attachment; filename=%q", archiveName+"."+format))
+		c.Data(200, contentType, buf.Bytes())
+	})
+
+	r.GET("/api/workspace", func(c *gin.Context) {
+		// List the specs of the workspace:
+		active := workspace.Active()
+
+		results := make([]M, 0)
+		for _, entry := range workspace.List() {
+			result := M{
+				"file":   entry.File,
+				"loaded": entry.Spec() != nil,
+				"active": entry == active,
 			}
+			if entry.Spec() != nil {
+				result["name"] = entry.Spec().Name
+			}
+			results = append(results, result)
 		}
+		c.IndentedJSON(200, M{
+			"dir":     workspace.Dir,
+			"results": results,
+		})
+	})
 
-		Ln(LimeBG(">>> Generation completed <<<"))
-		os.Exit(0)
-	}
+	r.POST("/api/workspace/specs", func(c *gin.Context) {
+		// Create a new spec in the workspace folder, and activate it:
 
-	var once sync.Once
-	go Notify(
-		func(os.Signal) bool {
-			once.Do(onExitCallback)
-			return false
-		},
-		os.Kill,
-		os.Interrupt,
-	)
-	defer once.Do(onExitCallback)
+		var req struct {
+			Name string
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
 
-	r.GET("/api/spec", func(c *gin.Context) {
-		globalSpec.RLock()
-		defer globalSpec.RUnlock()
-		c.IndentedJSON(200, globalSpec)
+		entry, err := workspace.CreateSpec(req.Name)
+		if err != nil {
+			Abort400(c, Sf("Error creating spec: %s", err))
+			return
+		}
+		spec := entry.Spec()
+		spec.Lock()
+		err = entry.Save(true)
+		spec.Unlock()
+		if err != nil {
+			Abort400(c, Sf("Error saving spec: %s", err))
+			return
+		}
+
+		activateSpec(c, entry.File)
 	})
 
-	r.GET("/api/cached", func(c *gin.Context) {
-		// List already cached sources:
-		list := x.GetListCachedSources()
+	r.POST("/api/workspace/activate", func(c *gin.Context) {
+		// Save the active spec, and switch to another spec of the workspace:
 
-		sort.Slice(list, func(i, j int) bool {
-			return x.FormatPathVersion(list[i].Path, list[i].Version) < x.FormatPathVersion(list[j].Path, list[j].Version)
-		})
-		c.IndentedJSON(200, M{"results": list})
-	})
+		var req struct {
+			File string
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
 
-	r.GET("/api/models/kinds", func(c *gin.Context) {
-		// List available model kinds:
-		kinds := x.Router().ListModelKinds()
-		sort.Slice(kinds, func(i, j int) bool {
-			return kinds[i] < kinds[j]
-		})
-		c.IndentedJSON(200, M{"results": kinds})
+		activateSpec(c, req.File)
 	})
 
 	r.POST("/api/spec/models", func(c *gin.Context) {
 		// Add a new model to the spec:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
 		var req struct {
 			Name string
 			Kind x.ModelKind
@@ -378,16 +611,118 @@ func main() {
 			Kind: req.Kind,
 		}
 
-		err = globalSpec.PushModel(created)
+		err = spec.PushModel(created)
 		if err != nil {
 			Abort400(c, Sf("Error adding model: %s", err))
 			return
 		}
-		c.IndentedJSON(200, globalSpec)
+		c.IndentedJSON(200, spec)
+	})
+
+	r.PATCH("/api/spec/models", func(c *gin.Context) {
+		// Patch a model, i.e. rename it, and/or convert it to another kind:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
+		var req struct {
+			Where struct {
+				Model string
+			}
+			What struct {
+				Name string      // New name (optional).
+				Kind x.ModelKind // New kind (optional).
+			}
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
+
+		// The name check, the conversion and the renaming are done
+		// under the same lock, so that they are applied atomically:
+		warnings, err := spec.PatchModel(req.Where.Model, req.What.Name, req.What.Kind)
+		if err != nil {
+			Abort400(c, Sf("Error patching model: %s", err))
+			return
+		}
+
+		spec.Lock()
+		defer spec.Unlock()
+		if err := spec.AddMeta(); err != nil {
+			Abort400(c, Sf("Error adding meta: %s", err))
+			return
+		}
+		c.IndentedJSON(200, M{"warnings": warnings, "spec": spec})
+	})
+
+	r.DELETE("/api/spec/models", func(c *gin.Context) {
+		// Delete a model:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
+		var req struct {
+			Where struct {
+				Model string
+			}
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
+
+		err = spec.DeleteModelByName(req.Where.Model)
+		if err != nil {
+			Abort404(c, err.Error())
+			return
+		}
+		c.IndentedJSON(200, spec)
+	})
+
+	r.POST("/api/spec/models/duplicate", func(c *gin.Context) {
+		// Add a copy of a model (with all its selectors) to the spec:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
+		var req struct {
+			Where struct {
+				Model string
+			}
+			What struct {
+				Name string
+			}
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
+
+		_, err = spec.DuplicateModel(req.Where.Model, req.What.Name)
+		if err != nil {
+			Abort400(c, Sf("Error duplicating model: %s", err))
+			return
+		}
+		c.IndentedJSON(200, spec)
 	})
 
 	r.PATCH("/api/spec/structs", func(c *gin.Context) {
 		// Patch a struct, i.e. add/remove a field:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
 		var req struct {
 			Where struct {
 				Path    string
@@ -425,12 +760,19 @@ func main() {
 			return
 		}
 
-		err = globalSpec.ModifyModelByName(
+		err = spec.ModifyModelByName(
 			req.Where.Model,
 			func(mdl *x.XModel) error {
 				err := mdl.ModifyMethodByName(
 					req.Where.Method,
 					func(mt *x.XMethod) error {
+						caps, err := x.Router().GetMethodCapabilities(mdl.Kind, mt.Name)
+						if err != nil {
+							return err
+						}
+						if err := caps.CheckSelectorKind(x.SelectorKindStruct); err != nil {
+							return err
+						}
 
 						existingSel := mt.GetStructSelector(
 							req.Where.Path,
@@ -507,11 +849,15 @@ func main() {
 			return
 		}
 
-		c.IndentedJSON(200, globalSpec)
+		c.IndentedJSON(200, spec)
 	})
 
 	r.PATCH("/api/spec/funcs", func(c *gin.Context) {
 		// Patch a func (func/type-method/interface-method), i.e. select/unselect its components:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
 
 		const FlowKeyInp = "Inp"
 		const FlowKeyOut = "Out"
@@ -585,15 +931,22 @@ func main() {
 			}
 		}
 
-		err = globalSpec.ModifyModelByName(
+		err = spec.ModifyModelByName(
 			req.Where.Model,
 			func(mdl *x.XModel) error {
-				// Currently, only the tainttracking.Handler is the only handler
-				// that supports flow handling.
-				if req.Flow != nil && !ModelSupportsFuncFlow(mdl) {
-					return errors.New("This model does not support func flow qualifiers.")
+				caps, err := x.Router().GetMethodCapabilities(mdl.Kind, req.Where.Method)
+				if err != nil {
+					return err
 				}
-				err := mdl.ModifyMethodByName(
+				if req.Pos != nil {
+					err = caps.CheckMode(x.SelectionModePos)
+				} else {
+					err = caps.CheckMode(x.SelectionModeFlow)
+				}
+				if err != nil {
+					return err
+				}
+				err = mdl.ModifyMethodByName(
 					req.Where.Method,
 					func(mt *x.XMethod) error {
 
@@ -606,6 +959,17 @@ func main() {
 
 						// Handle Pos:
 						if req.Pos != nil {
+							if req.Pos.Value {
+								// Check the selection that would result:
+								pos := make([]bool, fn.Len())
+								if existingSel != nil {
+									copy(pos, existingSel.Pos)
+								}
+								pos[req.Pos.Index] = req.Pos.Value
+								if err := caps.CheckPos(meta, pos); err != nil {
+									return fmt.Errorf("func %q (model kind %s): %s", x.GetFuncName(fn), mdl.Kind, err)
+								}
+							}
 							if existingSel == nil {
 								// Add a new selector only if the value is true:
 								if req.Pos.Value {
@@ -614,23 +978,34 @@ func main() {
 
 									// If there is no existing selector,
 									// then create a new one:
-									newSel := &x.XSelector{
-										Kind: x.SelectorKindFunc,
-										Qualifier: &x.FuncQualifier{
-											BasicQualifier: x.BasicQualifier{
-												Path:    req.Where.Path,
-												Version: req.Where.Version,
-												ID:      req.What.FuncID,
-											},
-											Pos:      pos,
-											Name:     x.GetFuncName(fn),
-											Elements: meta,
+									newQual := &x.FuncQualifier{
+										BasicQualifier: x.BasicQualifier{
+											Path:    req.Where.Path,
+											Version: req.Where.Version,
+											ID:      req.What.FuncID,
 										},
+										Pos:      pos,
+										Name:     x.GetFuncName(fn),
+										Elements: meta,
+									}
+									if caps.ContentType {
+										// The guess is just a default; the user can change it.
+										newQual.ContentType = responsebody.GuessContentTypeFromFuncName(newQual.Name)
+									}
+									newSel := &x.XSelector{
+										Kind:      x.SelectorKindFunc,
+										Qualifier: newQual,
 									}
 
 									mt.Selectors = append(mt.Selectors, newSel)
 								}
 							} else {
+								if len(existingSel.Pos) != fn.Len() {
+									// e.g. nothing selected in a readable spec.
+									pos := make([]bool, fn.Len())
+									copy(pos, existingSel.Pos)
+									existingSel.Pos = pos
+								}
 								existingSel.Pos[req.Pos.Index] = req.Pos.Value
 								existingSel.Elements = meta
 
@@ -648,6 +1023,11 @@ func main() {
 
 						// Handle Flow:
 						if req.Flow != nil {
+							if req.Flow.Value {
+								if err := caps.CheckElement(meta, req.Flow.Index); err != nil {
+									return fmt.Errorf("func %q (model kind %s): %s", x.GetFuncName(fn), mdl.Kind, err)
+								}
+							}
 							// TODO:
 							if existingSel == nil {
 								// Add a new selector only if the value is true:
@@ -768,11 +1148,83 @@ func main() {
 			return
 		}
 
-		c.IndentedJSON(200, globalSpec)
+		c.IndentedJSON(200, spec)
+	})
+
+	r.PATCH("/api/spec/funcs/contenttype", func(c *gin.Context) {
+		// Set the content-type of a func selector:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
+		var req struct {
+			Where struct {
+				Path    string
+				Version string
+				Model   string
+				Method  string
+			}
+			What struct {
+				FuncID      string
+				ContentType string
+			}
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
+
+		err = spec.ModifyModelByName(
+			req.Where.Model,
+			func(mdl *x.XModel) error {
+				err := mdl.ModifyMethodByName(
+					req.Where.Method,
+					func(mt *x.XMethod) error {
+						caps, err := x.Router().GetMethodCapabilities(mdl.Kind, mt.Name)
+						if err != nil {
+							return err
+						}
+						if !caps.ContentType {
+							return errors.New("This method does not support setting a content-type.")
+						}
+
+						existingSel := mt.GetFuncSelector(
+							req.Where.Path,
+							req.Where.Version,
+							req.What.FuncID,
+						)
+						if existingSel == nil {
+							return fmt.Errorf("Func selector not found: %q", req.What.FuncID)
+						}
+
+						existingSel.ContentType = strings.TrimSpace(req.What.ContentType)
+						return nil
+					},
+				)
+				if err != nil {
+					return err
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			Abort400(c, Sf("Error modifying model: %s", err))
+			return
+		}
+
+		c.IndentedJSON(200, spec)
 	})
 
 	r.PATCH("/api/spec/funcs/flow/enable", func(c *gin.Context) {
 		// Enable/disable a flow selector:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
 		type FlowValueSet struct {
 			Enable bool // Enable selector.
 		}
@@ -807,15 +1259,17 @@ func main() {
 			return
 		}
 
-		err = globalSpec.ModifyModelByName(
+		err = spec.ModifyModelByName(
 			req.Where.Model,
 			func(mdl *x.XModel) error {
-				// Currently, only the tainttracking.Handler is the only handler
-				// that supports flow handling.
-				if !ModelSupportsFuncFlow(mdl) {
-					return errors.New("This model does not support func flow qualifiers.")
+				caps, err := x.Router().GetMethodCapabilities(mdl.Kind, req.Where.Method)
+				if err != nil {
+					return err
 				}
-				err := mdl.ModifyMethodByName(
+				if err := caps.CheckMode(x.SelectionModeFlow); err != nil {
+					return err
+				}
+				err = mdl.ModifyMethodByName(
 					req.Where.Method,
 					func(mt *x.XMethod) error {
 
@@ -856,11 +1310,16 @@ func main() {
 			return
 		}
 
-		c.IndentedJSON(200, globalSpec)
+		c.IndentedJSON(200, spec)
 	})
 
 	r.DELETE("/api/spec/funcs/flow/blocks", func(c *gin.Context) {
 		// Delete a block:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
 		type FlowValueSet struct {
 			BlockIndex int
 		}
@@ -895,13 +1354,17 @@ func main() {
 			return
 		}
 
-		err = globalSpec.ModifyModelByName(
+		err = spec.ModifyModelByName(
 			req.Where.Model,
 			func(mdl *x.XModel) error {
-				if !ModelSupportsFuncFlow(mdl) {
-					return errors.New("This model does not support func flow qualifiers.")
+				caps, err := x.Router().GetMethodCapabilities(mdl.Kind, req.Where.Method)
+				if err != nil {
+					return err
 				}
-				err := mdl.ModifyMethodByName(
+				if err := caps.CheckMode(x.SelectionModeFlow); err != nil {
+					return err
+				}
+				err = mdl.ModifyMethodByName(
 					req.Where.Method,
 					func(mt *x.XMethod) error {
 
@@ -942,11 +1405,16 @@ func main() {
 			return
 		}
 
-		c.IndentedJSON(200, globalSpec)
+		c.IndentedJSON(200, spec)
 	})
 
 	r.PATCH("/api/spec/types", func(c *gin.Context) {
 		// Patch a type selector:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
 		var req struct {
 			Where struct {
 				Path    string
@@ -978,12 +1446,19 @@ func main() {
 			return
 		}
 
-		err = globalSpec.ModifyModelByName(
+		err = spec.ModifyModelByName(
 			req.Where.Model,
 			func(mdl *x.XModel) error {
 				err := mdl.ModifyMethodByName(
 					req.Where.Method,
 					func(mt *x.XMethod) error {
+						caps, err := x.Router().GetMethodCapabilities(mdl.Kind, mt.Name)
+						if err != nil {
+							return err
+						}
+						if err := caps.CheckSelectorKind(x.SelectorKindType); err != nil {
+							return err
+						}
 
 						existingSel := mt.GetTypeSelector(
 							req.Where.Path,
@@ -1036,7 +1511,45 @@ func main() {
 			return
 		}
 
-		c.IndentedJSON(200, globalSpec)
+		c.IndentedJSON(200, spec)
+	})
+
+	r.POST("/api/spec/migrate", func(c *gin.Context) {
+		// Move all the selectors of a module to another version:
+		spec := requestSpec(c)
+		if spec == nil {
+			return
+		}
+
+		var req struct {
+			Path        string
+			FromVersion string
+			ToVersion   string
+			DryRun      bool
+		}
+		err := c.BindJSON(&req)
+		if err != nil {
+			Q(err)
+			Abort400(c, err.Error())
+			return
+		}
+
+		spec.Lock()
+		defer spec.Unlock()
+
+		report, err := spec.MigrateModule(req.Path, req.FromVersion, req.ToVersion, LoadPackage, req.DryRun)
+		if err != nil {
+			Abort400(c, Sf("Error migrating module: %s", err))
+			return
+		}
+		if !req.DryRun {
+			if err := spec.AddMeta(); err != nil {
+				Abort400(c, Sf("Error adding meta: %s", err))
+				return
+			}
+		}
+
+		c.IndentedJSON(200, M{"report": report, "spec": spec})
 	})
 
 	r.GET("/api/search", func(c *gin.Context) {
@@ -1149,18 +1662,97 @@ func main() {
 	})
 
 	if runServer {
-		r.Run("0.0.0.0:8070")
+		if err := server.Run(r); err != nil {
+			// Return (instead of exiting) so that the deferred
+			// callback saves the specs:
+			Errorf("%s", err)
+			return
+		}
 	}
 }
 
-func ModelSupportsFuncFlow(mdl *x.XModel) bool {
-	// Currently, only the tainttracking.Handler is the only handler
-	// that supports flow handling.
-	if mdl.Kind == tainttracking.Kind {
-		return true
+// registerHandlers registers all the ModelKind handlers in the router.
+func registerHandlers() {
+	rt := x.Router()
+	// Register ModelKind handlers in the router:
+	{
+		// untrustedflowsource handler:
+		err := rt.RegisterHandler(untrustedflowsource.Kind, &untrustedflowsource.Handler{})
+		if err != nil {
+			Fatalf("error while registering handler: %s", err)
+		}
+
+		// tainttracking handler:
+		err = rt.RegisterHandler(tainttracking.Kind, &tainttracking.Handler{})
+		if err != nil {
+			Fatalf("error while registering handler: %s", err)
+		}
+
+		// http redirect handler:
+		err = rt.RegisterHandler(redirect.Kind, &redirect.Handler{})
+		if err != nil {
+			Fatalf("error while registering handler: %s", err)
+		}
+
+		// http responsebody handler:
+		err = rt.RegisterHandler(responsebody.Kind, &responsebody.Handler{})
+		if err != nil {
+			Fatalf("error while registering handler: %s", err)
+		}
+
+		// http headerwrite handler:
+		err = rt.RegisterHandler(headerwrite.Kind, &headerwrite.Handler{})
+		if err != nil {
+			Fatalf("error while registering handler: %s", err)
+		}
+	}
+	// Register the ModelKind handlers of the plugins:
+	for _, command := range plugin.ParseCommands(os.Getenv("CODEMILL_PLUGINS")) {
+		handler, err := plugin.Start(command)
+		if err != nil {
+			Fatalf("error while starting plugin: %s", err)
+		}
+		err = rt.RegisterHandler(handler.Kind(), handler)
+		if err != nil {
+			Fatalf("error while registering handler of plugin %q: %s", command[0], err)
+		}
+		Infof("Registered handler of plugin %q for kind %s", command[0], handler.Kind())
+	}
+}
+
+// parseOutputFormats parses the comma-separated
+// list of output formats of the --output flag.
+func parseOutputFormats(s string) (map[string]bool, error) {
+	outputs := make(map[string]bool)
+	for _, format := range strings.Split(s, ",") {
+		format = strings.TrimSpace(format)
+		switch format {
+		case "qll", "mad-yaml", "mad-csv":
+			outputs[format] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown output format: %q", format)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, errors.New("no output format provided")
 	}
-	return false
+	return outputs, nil
 }
+
+// writeFileWith creates the file and writes it with the provided func.
+func writeFileWith(filepath string, write func(w io.Writer) error) error {
+	file, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := write(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
 func LoadPackage(path string, version string) (*feparser.FEPackage, error) {
 
 	if path == "" {
@@ -1346,3 +1938,37 @@ func Abort404(c *gin.Context, errorString string) {
 func abort(c *gin.Context, statusCode int, errorString string) {
 	c.AbortWithStatusJSON(statusCode, M{"error": errorString})
 }
+
+// activateSpec saves the active spec, makes the spec of the
+// provided file the active one, and responds with it.
+func activateSpec(c *gin.Context, file string) {
+	specEditMu.Lock()
+	defer specEditMu.Unlock()
+
+	if current := workspace.Active(); current != nil && current.File != file {
+		spec := current.Spec()
+		spec.Lock()
+		err := current.Save(true)
+		spec.Unlock()
+		if err != nil {
+			Abort400(c, Sf("Error saving spec: %s", err))
+			return
+		}
+	}
+
+	entry, err := workspace.Activate(file)
+	if err != nil {
+		Abort404(c, err.Error())
+		return
+	}
+	c.Header(specFileHeader, entry.File)
+	specEvents.Publish("activate", M{
+		"file": entry.File,
+	})
+
+	spec := entry.Spec()
+	spec.RLock()
+	defer spec.RUnlock()
+	c.Header("ETag", spec.ETag())
+	c.IndentedJSON(200, spec)
+}
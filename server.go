@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "github.com/gagliardetto/utilz"
+	"github.com/gin-gonic/gin"
+)
+
+// serverOptions are the options of the http server (see the flags in main).
+type serverOptions struct {
+	Listen         string
+	Token          string
+	AllowedOrigins string
+	TLSCert        string
+	TLSKey         string
+
+	host           string
+	port           string
+	allowedOrigins []string
+}
+
+// Setup validates the options, and generates the token if requested.
+func (opts *serverOptions) Setup() error {
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be provided together")
+	}
+	host, port, err := net.SplitHostPort(opts.Listen)
+	if err != nil {
+		return fmt.Errorf("--listen not valid: %s", err)
+	}
+	opts.host, opts.port = host, port
+
+	if opts.Token == "auto" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		opts.Token = hex.EncodeToString(buf)
+	}
+
+	for _, origin := range strings.Split(opts.AllowedOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		normalized, ok := normalizeOrigin(origin)
+		if !ok {
+			return fmt.Errorf("--allowed-origins: origin not valid: %q", origin)
+		}
+		opts.allowedOrigins = append(opts.allowedOrigins, normalized)
+	}
+
+	if !isLoopbackHost(host) && opts.Token == "" {
+		Warnf("The http server is reachable from the network, and anyone can modify the specs; consider using --token.")
+	}
+	return nil
+}
+
+func (opts *serverOptions) scheme() string {
+	if opts.TLSCert != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// URL returns the URL of the UI (including the token, if any).
+func (opts *serverOptions) URL() string {
+	host := opts.host
+	if host == "" || net.ParseIP(host) != nil && net.ParseIP(host).IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	u := &url.URL{
+		Scheme: opts.scheme(),
+		Host:   net.JoinHostPort(host, opts.port),
+		Path:   "/",
+	}
+	if opts.Token != "" {
+		u.RawQuery = url.Values{"token": []string{opts.Token}}.Encode()
+	}
+	return u.String()
+}
+
+// Run runs the http server (with TLS, if configured).
+func (opts *serverOptions) Run(r *gin.Engine) error {
+	Infof("Serving the UI at %s", opts.URL())
+	if opts.TLSCert != "" {
+		return r.RunTLS(opts.Listen, opts.TLSCert, opts.TLSKey)
+	}
+	return r.Run(opts.Listen)
+}
+
+// Middlewares returns the middlewares that check the
+// host, the origin, and the token of the requests.
+func (opts *serverOptions) Middlewares() []gin.HandlerFunc {
+	middlewares := []gin.HandlerFunc{
+		opts.checkHost,
+		opts.checkOrigin,
+	}
+	if opts.Token != "" {
+		middlewares = append(middlewares, opts.checkToken)
+	}
+	return middlewares
+}
+
+// checkHost rejects, when listening on a loopback address, the requests
+// for other hosts (i.e. DNS rebinding from a web page).
+func (opts *serverOptions) checkHost(c *gin.Context) {
+	if !isLoopbackHost(opts.host) {
+		return
+	}
+	host := c.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !isLoopbackHost(host) {
+		abort(c, http.StatusForbidden, Sf("Host not allowed: %q", c.Request.Host))
+	}
+}
+
+// checkOrigin rejects the requests from web pages of other origins;
+// the requests that modify something must come from the same origin
+// (or from a client that is not a browser, i.e. without Origin and Referer).
+func (opts *serverOptions) checkOrigin(c *gin.Context) {
+	mutating := isMutatingRequest(c.Request)
+	origin := c.GetHeader("Origin")
+	if origin == "" && mutating {
+		origin = c.GetHeader("Referer")
+	}
+	if origin == "" {
+		// The browsers tell that a request is cross-site even when they send
+		// neither Origin nor Referer (e.g. with `referrerpolicy="no-referrer"`):
+		if mutating && isCrossSiteFetch(c.GetHeader("Sec-Fetch-Site")) {
+			abort(c, http.StatusForbidden, "Cross-site request not allowed")
+		}
+		return
+	}
+	normalized, ok := normalizeOrigin(origin)
+	if ok && (normalized == opts.scheme()+"://"+strings.ToLower(c.Request.Host) || SliceContains(opts.allowedOrigins, normalized)) {
+		return
+	}
+	abort(c, http.StatusForbidden, Sf("Origin not allowed: %q", origin))
+}
+
+// checkToken rejects the requests without the token: the token can be provided
+// as `Authorization: Bearer <token>`, or with the ?token= param of the URL
+// (e.g. the one printed at start), which stores it in a cookie.
+func (opts *serverOptions) checkToken(c *gin.Context) {
+	if bearer := c.GetHeader("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		if opts.isToken(strings.TrimPrefix(bearer, "Bearer ")) {
+			return
+		}
+		abort(c, http.StatusUnauthorized, "Token not valid")
+		return
+	}
+
+	cookieName := "codemill_token_" + opts.port
+	if cookie, err := c.Cookie(cookieName); err == nil && opts.isToken(cookie) {
+		return
+	}
+
+	if token := c.Query("token"); token != "" && opts.isToken(token) {
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     cookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   opts.TLSCert != "",
+			SameSite: http.SameSiteStrictMode,
+		})
+		if c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			// Remove the token from the address bar:
+			query := c.Request.URL.Query()
+			query.Del("token")
+			redirect := *c.Request.URL
+			redirect.RawQuery = query.Encode()
+			c.Redirect(http.StatusFound, redirect.RequestURI())
+			c.Abort()
+		}
+		return
+	}
+
+	abort(c, http.StatusUnauthorized, "Token required: open the URL printed by codemill at start, or provide the --token as `Authorization: Bearer <token>`")
+}
+
+func (opts *serverOptions) isToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(opts.Token)) == 1
+}
+
+// normalizeOrigin returns the scheme://host of the provided origin (or URL).
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// downloadPaths are the paths of the GET requests that download
+// packages (i.e. that modify the cache of the sources).
+var downloadPaths = map[string]bool{
+	"/api/source":   true,
+	"/api/versions": true,
+}
+
+// isMutatingRequest returns true if the request modifies something
+// (i.e. it has an unsafe method, or it downloads packages).
+func isMutatingRequest(req *http.Request) bool {
+	return !isSafeMethod(req.Method) || downloadPaths[req.URL.Path]
+}
+
+// isCrossSiteFetch returns true if the Sec-Fetch-Site header
+// tells that the request comes from another site.
+func isCrossSiteFetch(secFetchSite string) bool {
+	return secFetchSite == "cross-site" || secFetchSite == "same-site"
+}
+
+// isLoopbackHost returns true if the host is
+// localhost or a loopback IP address.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
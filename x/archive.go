@@ -0,0 +1,114 @@
+package x
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ArchiveFormats are the supported formats of the archives of the generated assets.
+var ArchiveFormats = []string{"zip", "tar.gz"}
+
+// WriteArchive writes the content of the folders (i.e. the assets generated in a run)
+// as an archive in the provided format; the files of each folder are inside a folder
+// named after it.
+func WriteArchive(w io.Writer, format string, dirs ...string) error {
+	switch format {
+	case "zip":
+		return writeZip(w, dirs)
+	case "tar.gz":
+		return writeTarGz(w, dirs)
+	default:
+		return fmt.Errorf("unknown archive format: %q", format)
+	}
+}
+
+// walkArchive calls the provided func for each file of the folders,
+// with the path the file has in the archive (slash-separated).
+func walkArchive(dirs []string, fn func(path string, name string, info os.FileInfo) error) error {
+	for _, dir := range dirs {
+		base := filepath.Base(dir)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			return fn(path, filepath.ToSlash(filepath.Join(base, rel)), info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeZip(w io.Writer, dirs []string) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(dirs, func(path string, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileTo(fw, path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, dirs []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walkArchive(dirs, func(path string, name string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileTo(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
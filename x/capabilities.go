@@ -0,0 +1,291 @@
+package x
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/gagliardetto/utilz"
+)
+
+// SelectionMode is how the func selectors of a method select the elements of a func.
+type SelectionMode string
+
+const (
+	// SelectionModePos: the selected elements (FuncQualifier.Pos) play all the same role,
+	// e.g. they are all sources.
+	SelectionModePos SelectionMode = "Pos"
+	// SelectionModeFlow: the selected elements are the inputs and outputs
+	// of flows (FuncQualifier.Flows).
+	SelectionModeFlow SelectionMode = "Flow"
+)
+
+// ElementRole is the role of an element in a func.
+type ElementRole string
+
+const (
+	ElementRoleReceiver  ElementRole = "Receiver"
+	ElementRoleParameter ElementRole = "Parameter"
+	ElementRoleResult    ElementRole = "Result"
+)
+
+// AllElementRoles are all the roles of the elements of a func.
+var AllElementRoles = []ElementRole{
+	ElementRoleReceiver,
+	ElementRoleParameter,
+	ElementRoleResult,
+}
+
+// MethodCapabilities describes what the selectors
+// of a method of a ModelKind can select.
+type MethodCapabilities struct {
+	Name string // Name of the method.
+
+	// SelectorKinds are the kinds of selectors that can be added to the method.
+	SelectorKinds []SelectorKind
+	// Mode is how the func selectors select the elements of a func.
+	Mode SelectionMode
+	// Roles are the roles of the elements of a func that can be selected.
+	Roles []ElementRole
+	// MaxSelections is the max number of elements that can be
+	// selected in a func (Pos mode); zero means no limit.
+	MaxSelections int
+	// ContentType is true if the func selectors carry a content-type.
+	ContentType bool
+}
+
+// GetCapabilities returns the capabilities of the methods of the provided kind.
+func (rt *ModelKindRouter) GetCapabilities(kind ModelKind) ([]*MethodCapabilities, error) {
+	handler := rt.GetHandler(kind)
+	if handler == nil {
+		return nil, fmt.Errorf("handler not found for kind %s", kind)
+	}
+	return handler.Capabilities(), nil
+}
+
+// GetMethodCapabilities returns the capabilities of the method of the provided kind.
+func (rt *ModelKindRouter) GetMethodCapabilities(kind ModelKind, methodName string) (*MethodCapabilities, error) {
+	capabilities, err := rt.GetCapabilities(kind)
+	if err != nil {
+		return nil, err
+	}
+	if caps := findMethodCapabilities(capabilities, methodName); caps != nil {
+		return caps, nil
+	}
+	return nil, fmt.Errorf("method %q not found in kind %s", methodName, kind)
+}
+
+// SupportsSelectorKind returns true if selectors of the provided kind can be added to the method.
+func (caps *MethodCapabilities) SupportsSelectorKind(kind SelectorKind) bool {
+	for _, supported := range caps.SelectorKinds {
+		if supported == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSelectorKind returns an error if selectors of the provided
+// kind cannot be added to the method.
+func (caps *MethodCapabilities) CheckSelectorKind(kind SelectorKind) error {
+	if !caps.SupportsSelectorKind(kind) {
+		return fmt.Errorf("method %q does not support %s selectors", caps.Name, kind)
+	}
+	return nil
+}
+
+// CheckMode returns an error if the func selectors of the
+// method do not select elements in the provided mode.
+func (caps *MethodCapabilities) CheckMode(mode SelectionMode) error {
+	if err := caps.CheckSelectorKind(SelectorKindFunc); err != nil {
+		return err
+	}
+	if caps.Mode != mode {
+		return fmt.Errorf("method %q selects the elements of funcs in %s mode, not in %s mode", caps.Name, caps.Mode, mode)
+	}
+	return nil
+}
+
+// HasRole returns true if the elements with the provided role can be selected.
+func (caps *MethodCapabilities) HasRole(role ElementRole) bool {
+	for _, supported := range caps.Roles {
+		if supported == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckElement returns an error if the element at the provided
+// (absolute) index of the func cannot be selected.
+func (caps *MethodCapabilities) CheckElement(meta *FuncQualifierElementsMeta, index int) error {
+	role, elem := meta.ElementAt(index)
+	if elem == nil {
+		return fmt.Errorf("element index out of bounds: %v", index)
+	}
+	if !caps.HasRole(role) {
+		return fmt.Errorf("%s cannot be selected: method %q can select only %s", elem.Describe(role), caps.Name, formatRoles(caps.Roles))
+	}
+	return nil
+}
+
+// CheckPos returns an error if the provided Pos selection is not valid for the method.
+func (caps *MethodCapabilities) CheckPos(meta *FuncQualifierElementsMeta, pos []bool) error {
+	count := 0
+	for index, ok := range pos {
+		if !ok {
+			continue
+		}
+		count++
+		if err := caps.CheckElement(meta, index); err != nil {
+			return err
+		}
+	}
+	if caps.MaxSelections > 0 && count > caps.MaxSelections {
+		return fmt.Errorf("method %q can select at most %v element(s) per func, but %v are selected", caps.Name, caps.MaxSelections, count)
+	}
+	return nil
+}
+
+// CheckFlows returns an error if the provided flows are not valid for the method.
+func (caps *MethodCapabilities) CheckFlows(meta *FuncQualifierElementsMeta, flows *FlowSpec) error {
+	if flows == nil {
+		return nil
+	}
+	for _, block := range flows.Blocks {
+		for _, elems := range [][]bool{block.Inp, block.Out} {
+			for index, ok := range elems {
+				if !ok {
+					continue
+				}
+				if err := caps.CheckElement(meta, index); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckSelector returns an error if the selector cannot be added to the method;
+// the func selectors are checked against the provided meta of the func
+// (or the meta of the selector, if nil).
+func (caps *MethodCapabilities) CheckSelector(sel *XSelector, meta *FuncQualifierElementsMeta) error {
+	if err := caps.CheckSelectorKind(sel.Kind); err != nil {
+		return err
+	}
+	qual := sel.GetFuncQualifier()
+	if qual == nil {
+		return nil
+	}
+	if meta == nil {
+		meta = qual.Elements
+	}
+	if meta == nil {
+		return fmt.Errorf("elements of func %q not known", qual.ID)
+	}
+	switch caps.Mode {
+	case SelectionModePos:
+		if qual.Flows != nil && !AllBlocksEmpty(qual.Flows.Blocks...) {
+			return caps.CheckMode(SelectionModeFlow)
+		}
+		return caps.CheckPos(meta, qual.Pos)
+	case SelectionModeFlow:
+		if !AllFalse(qual.Pos...) {
+			return caps.CheckMode(SelectionModePos)
+		}
+		return caps.CheckFlows(meta, qual.Flows)
+	default:
+		return fmt.Errorf("unknown selection mode: %q", caps.Mode)
+	}
+}
+
+// CheckModelCapabilities returns an error for the first selector of the model
+// that the capabilities of its method do not support (e.g. a result selected
+// for a method that can select only parameters), which the generators
+// of the kind would not be able to handle.
+func CheckModelCapabilities(mdl *XModel) error {
+	capabilities, err := Router().GetCapabilities(mdl.Kind)
+	if err != nil {
+		return err
+	}
+	for _, mt := range mdl.Methods {
+		caps := findMethodCapabilities(capabilities, mt.Name)
+		if caps == nil {
+			// The methods are validated by the handler.
+			continue
+		}
+		for _, sel := range mt.Selectors {
+			if err := caps.CheckSelector(sel, funcElementsMetaOf(sel)); err != nil {
+				basicQual := sel.GetBasicQualifier()
+				return fmt.Errorf("method %q: %s %q of %s: %s", mt.Name, strings.ToLower(string(sel.Kind)), basicQual.ID, basicQual.PathVersion(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func findMethodCapabilities(capabilities []*MethodCapabilities, methodName string) *MethodCapabilities {
+	for _, caps := range capabilities {
+		if caps.Name == methodName {
+			return caps
+		}
+	}
+	return nil
+}
+
+// funcElementsMetaOf returns the meta of the elements of the func of the selector
+// (from the loaded source, if available); nil if not a func selector.
+func funcElementsMetaOf(sel *XSelector) *FuncQualifierElementsMeta {
+	qual := sel.GetFuncQualifier()
+	if qual == nil {
+		return nil
+	}
+	if source := GetCachedSource(qual.Path, qual.Version); source != nil {
+		if fn := FindFuncByID(source, qual.ID); fn != nil {
+			return CompileFuncQualifierElementsMeta(fn)
+		}
+	}
+	return qual.Elements
+}
+
+// ElementAt returns the role and the meta of the element at the provided
+// absolute index; the meta is nil if the index is out of bounds.
+func (meta *FuncQualifierElementsMeta) ElementAt(index int) (ElementRole, *FuncElementMeta) {
+	if meta.Receiver != nil && meta.Receiver.AI == index {
+		return ElementRoleReceiver, meta.Receiver
+	}
+	for _, elem := range meta.Parameters {
+		if elem.AI == index {
+			return ElementRoleParameter, elem
+		}
+	}
+	for _, elem := range meta.Results {
+		if elem.AI == index {
+			return ElementRoleResult, elem
+		}
+	}
+	return "", nil
+}
+
+// Describe returns a description of the element (e.g. `parameter 1 (body []byte)`).
+func (elem *FuncElementMeta) Describe(role ElementRole) string {
+	desc := strings.ToLower(string(role))
+	if role != ElementRoleReceiver {
+		desc += Sf(" %v", elem.RI)
+	}
+	if elem.Name != "" {
+		return Sf("%s (%s %s)", desc, elem.Name, elem.TypeString)
+	}
+	return Sf("%s (%s)", desc, elem.TypeString)
+}
+
+func formatRoles(roles []ElementRole) string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, strings.ToLower(string(role))+"s")
+	}
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return strings.Join(names, " and ")
+}
@@ -0,0 +1,131 @@
+package x
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gagliardetto/feparser"
+)
+
+// frameworkImportPrefix is the prefix of the imports of the framework models in go.qll.
+const frameworkImportPrefix = "import semmle.go.frameworks."
+
+// Uninstall removes the codeql module with the provided name, its tests, and its
+// import from the codeql-go checkout; the elements that are already missing are ignored.
+func (layout *CodeQLGoLayout) Uninstall(name string) error {
+	if err := CheckFrameworkName(name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(layout.FrameworksDir(), name+".qll")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(layout.FrameworkTestsDir(), name)); err != nil {
+		return err
+	}
+	_, err := layout.RemoveFrameworkImport(name)
+	return err
+}
+
+// CheckFrameworkName returns an error if the name is not the one of a
+// codeql module (e.g. it is a path), as it is used as a file name in
+// the codeql-go checkout (see Uninstall).
+func CheckFrameworkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || name != feparser.FormatCodeQlName(name) {
+		return fmt.Errorf("codeql module name not valid: %q", name)
+	}
+	return nil
+}
+
+// AddFrameworkImport adds the import of the framework model to go.qll,
+// in sorted position among the imports of the other framework models;
+// the returned bool is false if the import was already there.
+func (layout *CodeQLGoLayout) AddFrameworkImport(name string) (bool, error) {
+	return layout.editGoQll(func(lines []string) ([]string, bool) {
+		return InsertFrameworkImport(lines, name)
+	})
+}
+
+// RemoveFrameworkImport removes the import of the framework model from go.qll;
+// the returned bool is false if there was no such import.
+func (layout *CodeQLGoLayout) RemoveFrameworkImport(name string) (bool, error) {
+	return layout.editGoQll(func(lines []string) ([]string, bool) {
+		return DeleteFrameworkImport(lines, name)
+	})
+}
+
+func (layout *CodeQLGoLayout) editGoQll(edit func(lines []string) ([]string, bool)) (bool, error) {
+	content, err := ioutil.ReadFile(layout.GoQll())
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(string(content), "\n")
+	lines, changed := edit(lines)
+	if !changed {
+		return false, nil
+	}
+	if err := ioutil.WriteFile(layout.GoQll(), []byte(strings.Join(lines, "\n")), 0666); err != nil {
+		return false, fmt.Errorf("error while writing %s: %s", layout.GoQll(), err)
+	}
+	return true, nil
+}
+
+// InsertFrameworkImport inserts the import of the framework model in the
+// lines of go.qll, before the first framework import that sorts after it
+// (or after the last framework import); if there are no framework imports,
+// the import is added after the last import. The returned bool is false
+// if the import is already there.
+func InsertFrameworkImport(lines []string, name string) ([]string, bool) {
+	importStmt := FormatFrameworkImport(name)
+	insertAt := -1
+	lastImport := -1
+	lastFrameworkImport := -1
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		if text == importStmt {
+			return lines, false
+		}
+		if !strings.HasPrefix(text, "import ") {
+			continue
+		}
+		lastImport = i
+		if !strings.HasPrefix(text, frameworkImportPrefix) {
+			continue
+		}
+		lastFrameworkImport = i
+		if insertAt == -1 && text > importStmt {
+			insertAt = i
+		}
+	}
+	switch {
+	case insertAt != -1:
+	case lastFrameworkImport != -1:
+		insertAt = lastFrameworkImport + 1
+	case lastImport != -1:
+		insertAt = lastImport + 1
+	default:
+		insertAt = 0
+	}
+
+	res := make([]string, 0, len(lines)+1)
+	res = append(res, lines[:insertAt]...)
+	res = append(res, importStmt)
+	res = append(res, lines[insertAt:]...)
+	return res, true
+}
+
+// DeleteFrameworkImport deletes the import of the framework model from
+// the lines of go.qll; the returned bool is false if there was no such import.
+func DeleteFrameworkImport(lines []string, name string) ([]string, bool) {
+	importStmt := FormatFrameworkImport(name)
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.TrimSpace(line) == importStmt {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res, len(res) != len(lines)
+}
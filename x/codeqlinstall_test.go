@@ -0,0 +1,182 @@
+package x_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gagliardetto/codemill/x"
+)
+
+func TestInsertFrameworkImport(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []string
+	}{
+		{
+			name: "sorted position",
+			lines: []string{
+				"import go",
+				"import semmle.go.frameworks.Beego",
+				"import semmle.go.frameworks.Gin",
+				"",
+				"module X { }",
+			},
+			expected: []string{
+				"import go",
+				"import semmle.go.frameworks.Beego",
+				"import semmle.go.frameworks.Echo",
+				"import semmle.go.frameworks.Gin",
+				"",
+				"module X { }",
+			},
+		},
+		{
+			name: "after the last framework import",
+			lines: []string{
+				"import semmle.go.frameworks.Beego",
+				"import semmle.go.frameworks.Chi",
+				"import semmle.go.security.FlowSources",
+			},
+			expected: []string{
+				"import semmle.go.frameworks.Beego",
+				"import semmle.go.frameworks.Chi",
+				"import semmle.go.frameworks.Echo",
+				"import semmle.go.security.FlowSources",
+			},
+		},
+		{
+			name: "after the last import",
+			lines: []string{
+				"import go",
+				"import semmle.go.Types",
+				"",
+				"module X { }",
+			},
+			expected: []string{
+				"import go",
+				"import semmle.go.Types",
+				"import semmle.go.frameworks.Echo",
+				"",
+				"module X { }",
+			},
+		},
+		{
+			name: "no imports",
+			lines: []string{
+				"module X { }",
+			},
+			expected: []string{
+				"import semmle.go.frameworks.Echo",
+				"module X { }",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := x.InsertFrameworkImport(tt.lines, "Echo")
+			if !changed {
+				t.Fatal("expected the import to be inserted")
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected:\n%q\ngot:\n%q", tt.expected, got)
+			}
+
+			// Inserting it again does nothing:
+			again, changed := x.InsertFrameworkImport(got, "Echo")
+			if changed {
+				t.Error("expected the import not to be inserted again")
+			}
+			if !reflect.DeepEqual(again, tt.expected) {
+				t.Errorf("expected:\n%q\ngot:\n%q", tt.expected, again)
+			}
+		})
+	}
+}
+
+func TestDeleteFrameworkImport(t *testing.T) {
+	lines := []string{
+		"import go",
+		"import semmle.go.frameworks.Beego",
+		"  import semmle.go.frameworks.Echo",
+		"import semmle.go.frameworks.Gin",
+	}
+	got, changed := x.DeleteFrameworkImport(lines, "Echo")
+	if !changed {
+		t.Fatal("expected the import to be deleted")
+	}
+	expected := []string{
+		"import go",
+		"import semmle.go.frameworks.Beego",
+		"import semmle.go.frameworks.Gin",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, got)
+	}
+
+	got, changed = x.DeleteFrameworkImport(expected, "Echo")
+	if changed {
+		t.Error("expected no import to be deleted")
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, got)
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	root, err := ioutil.TempDir("", "codemill-codeql-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	layout := &x.CodeQLGoLayout{Root: root}
+
+	testsDir := filepath.Join(layout.FrameworkTestsDir(), "Echo")
+	for _, dir := range []string{testsDir, layout.FrameworksDir()} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(layout.FrameworksDir(), "Echo.qll"), []byte("module Echo { }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	goQll := "import go\nimport semmle.go.frameworks.Echo\nimport semmle.go.frameworks.Gin\n"
+	if err := ioutil.WriteFile(layout.GoQll(), []byte(goQll), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// The names that are not the ones of a codeql module are rejected
+	// (and nothing is removed):
+	for _, name := range []string{"", ".", "..", "../Echo", "Echo/..", `..\Echo`, "echo", "Echo Labstack"} {
+		if err := layout.Uninstall(name); err == nil {
+			t.Errorf("expected name %q to be rejected", name)
+		}
+	}
+	if _, err := os.Stat(testsDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := layout.Uninstall("Echo"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{testsDir, filepath.Join(layout.FrameworksDir(), "Echo.qll")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", path)
+		}
+	}
+	content, err := ioutil.ReadFile(layout.GoQll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "import go\nimport semmle.go.frameworks.Gin\n"; string(content) != expected {
+		t.Errorf("expected go.qll:\n%s\ngot:\n%s", expected, content)
+	}
+
+	// Uninstalling it again does nothing:
+	if err := layout.Uninstall("Echo"); err != nil {
+		t.Fatal(err)
+	}
+}
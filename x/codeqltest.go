@@ -0,0 +1,333 @@
+package x
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// CodeQLGoLayout is the layout of a codeql-go checkout.
+type CodeQLGoLayout struct {
+	Root string
+}
+
+// FrameworksDir is where the framework models (.qll) are.
+func (layout *CodeQLGoLayout) FrameworksDir() string {
+	return filepath.Join(layout.Root, "ql", "src", "semmle", "go", "frameworks")
+}
+
+// FrameworkTestsDir is where the tests of the framework models are.
+func (layout *CodeQLGoLayout) FrameworkTestsDir() string {
+	return filepath.Join(layout.Root, "ql", "test", "library-tests", "semmle", "go", "frameworks")
+}
+
+// GoQll is the file that imports all the framework models.
+func (layout *CodeQLGoLayout) GoQll() string {
+	return filepath.Join(layout.Root, "ql", "src", "go.qll")
+}
+
+// QLPath is the search path of the codeql-go libraries.
+func (layout *CodeQLGoLayout) QLPath() string {
+	return filepath.Join(layout.Root, "ql")
+}
+
+// FormatFrameworkImport returns the import statement of the framework model.
+func FormatFrameworkImport(name string) string {
+	return "import semmle.go.frameworks." + name
+}
+
+// GeneratedAssets are the assets generated for a spec in one run:
+// the codeql module (<Name>.qll) and the tests folder.
+type GeneratedAssets struct {
+	Dir  string
+	Name string
+}
+
+// FindGeneratedAssets returns the generated assets in the provided folder;
+// if the folder is the folder of the spec (i.e. it contains the folders of
+// the runs), the assets of the latest run are returned.
+func FindGeneratedAssets(dir string) (*GeneratedAssets, error) {
+	qlls, err := filepath.Glob(filepath.Join(dir, "*.qll"))
+	if err != nil {
+		return nil, err
+	}
+	if len(qlls) == 1 {
+		return &GeneratedAssets{
+			Dir:  dir,
+			Name: strings.TrimSuffix(filepath.Base(qlls[0]), ".qll"),
+		}, nil
+	}
+	if len(qlls) > 1 {
+		return nil, fmt.Errorf("more than one .qll file in %s", dir)
+	}
+
+	// Find the folder of the latest run:
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var latest os.FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if latest == nil || entry.ModTime().After(latest.ModTime()) {
+			latest = entry
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no generated assets found in %s", dir)
+	}
+	return FindGeneratedAssets(filepath.Join(dir, latest.Name()))
+}
+
+// QllPath is the path of the generated codeql module.
+func (assets *GeneratedAssets) QllPath() string {
+	return filepath.Join(assets.Dir, assets.Name+".qll")
+}
+
+// TestsDir is the path of the generated tests folder.
+func (assets *GeneratedAssets) TestsDir() string {
+	return filepath.Join(assets.Dir, "tests")
+}
+
+// CopyInto copies the codeql module and the tests into the codeql-go checkout
+// (replacing the previous ones), and returns the folder where the tests were copied;
+// the import of the module is added to go.qll (in sorted position) if missing.
+func (assets *GeneratedAssets) CopyInto(layout *CodeQLGoLayout) (string, error) {
+	if err := CheckFrameworkName(assets.Name); err != nil {
+		return "", err
+	}
+	if err := copyFile(assets.QllPath(), filepath.Join(layout.FrameworksDir(), assets.Name+".qll")); err != nil {
+		return "", fmt.Errorf("error while copying codeql module: %s", err)
+	}
+	testsDst := filepath.Join(layout.FrameworkTestsDir(), assets.Name)
+	if err := os.RemoveAll(testsDst); err != nil {
+		return "", err
+	}
+	if err := copyDir(assets.TestsDir(), testsDst); err != nil {
+		return "", fmt.Errorf("error while copying tests: %s", err)
+	}
+
+	if _, err := layout.AddFrameworkImport(assets.Name); err != nil {
+		return "", fmt.Errorf("error while adding import to go.qll: %s", err)
+	}
+	return testsDst, nil
+}
+
+func copyFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0666)
+}
+
+func copyDir(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), os.ModePerm)
+		}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+}
+
+// CodeQLTestRunner runs codeql tests with a local codeql CLI.
+type CodeQLTestRunner struct {
+	// CLIPath is the path to the codeql executable.
+	CLIPath     string
+	SearchPaths []string
+	// Output (optional) receives a copy of the output of the CLI.
+	Output io.Writer
+}
+
+// Run runs the tests in the provided folders, and returns the parsed results;
+// failing tests are not an error.
+func (runner *CodeQLTestRunner) Run(testDirs ...string) (*CodeQLTestResult, error) {
+	args := []string{"test", "run"}
+	for _, searchPath := range runner.SearchPaths {
+		args = append(args, "--search-path="+searchPath)
+	}
+	args = append(args, testDirs...)
+
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	if runner.Output != nil {
+		out = io.MultiWriter(&buf, runner.Output)
+	}
+	cmd := exec.Command(runner.CLIPath, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+
+	result := ParseCodeQLTestOutput(buf.String())
+	if err != nil {
+		if _, isExit := err.(*exec.ExitError); !isExit || (result.Failed == 0 && len(result.Failures) == 0) {
+			return result, fmt.Errorf("error while running %s: %s", runner.CLIPath, err)
+		}
+	}
+	return result, nil
+}
+
+// CodeQLTestResult is the parsed output of `codeql test run`.
+type CodeQLTestResult struct {
+	Passed      int
+	Failed      int
+	FailedTests []string
+	Failures    []*CodeQLTestFailure
+}
+
+// CodeQLTestFailure is a missing or unexpected result of an inline expectations test.
+type CodeQLTestFailure struct {
+	TestDir string // The folder of the test (i.e. of the failed .ql file).
+	File    string // Relative to the test folder.
+	Line    int
+	Element string // Empty for compilation errors.
+	Message string // e.g. `Missing result:taintSink=`, or `ERROR: could not resolve module Foo`
+
+	Model    string
+	Method   string
+	Selector *XSelector
+}
+
+func (failure *CodeQLTestFailure) Error() string {
+	var culprit string
+	if failure.Selector != nil {
+		basicQual := failure.Selector.GetBasicQualifier()
+		culprit = Sf("model %q, method %q, selector %q of %s: ", failure.Model, failure.Method, basicQual.ID, basicQual.PathVersion())
+	} else if failure.Model != "" {
+		culprit = Sf("model %q: ", failure.Model)
+	}
+	if failure.Element == "" {
+		return Sf("%s%s:%v: %s", culprit, filepath.Join(failure.TestDir, failure.File), failure.Line, failure.Message)
+	}
+	return Sf("%s%s:%v: %s (%s)", culprit, filepath.Join(failure.TestDir, failure.File), failure.Line, failure.Message, failure.Element)
+}
+
+var (
+	rxCodeQLTestFailed   = regexp.MustCompile(`^\[\d+/\d+.*\] FAILED\(\w+\) (.+)$`)
+	rxCodeQLTestSummary  = regexp.MustCompile(`^(\d+) tests? passed; (\d+) tests? failed`)
+	rxCodeQLTestAllPass  = regexp.MustCompile(`^All (\d+) tests? passed`)
+	rxCodeQLTestDiffLine = regexp.MustCompile(`^\+\|\s*([^|]+?):(\d+):\d+:\d+:\d+\s*\|\s*([^|]*?)\s*\|\s*(.+?)\s*\|\s*$`)
+	rxCodeQLTestError    = regexp.MustCompile(`^(ERROR: .+?) \((.+?):(\d+),\d+-\d+\)$`)
+)
+
+// ParseCodeQLTestOutput parses the output of `codeql test run`:
+// the added lines of the diff between the .expected file and the actual
+// results (i.e. the missing and unexpected results), and the errors
+// of the queries that don't compile, are the failures.
+func ParseCodeQLTestOutput(output string) *CodeQLTestResult {
+	result := &CodeQLTestResult{}
+	pending := make([]*CodeQLTestFailure, 0)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := rxCodeQLTestDiffLine.FindStringSubmatch(line); m != nil {
+			lineNumber, _ := strconv.Atoi(m[2])
+			pending = append(pending, &CodeQLTestFailure{
+				File:    m[1],
+				Line:    lineNumber,
+				Element: m[3],
+				Message: m[4],
+			})
+			continue
+		}
+		if m := rxCodeQLTestError.FindStringSubmatch(line); m != nil {
+			lineNumber, _ := strconv.Atoi(m[3])
+			pending = append(pending, &CodeQLTestFailure{
+				TestDir: filepath.Dir(m[2]),
+				File:    filepath.Base(m[2]),
+				Line:    lineNumber,
+				Message: m[1],
+			})
+			continue
+		}
+		if m := rxCodeQLTestFailed.FindStringSubmatch(line); m != nil {
+			// The diff (or the compilation errors) precede the line of the failed test:
+			testFile := strings.TrimSpace(m[1])
+			result.FailedTests = append(result.FailedTests, testFile)
+			for _, failure := range pending {
+				if failure.TestDir == "" {
+					failure.TestDir = filepath.Dir(testFile)
+				}
+			}
+			result.Failures = append(result.Failures, pending...)
+			pending = pending[:0]
+			continue
+		}
+		if m := rxCodeQLTestSummary.FindStringSubmatch(line); m != nil {
+			result.Passed, _ = strconv.Atoi(m[1])
+			result.Failed, _ = strconv.Atoi(m[2])
+			continue
+		}
+		if m := rxCodeQLTestAllPass.FindStringSubmatch(line); m != nil {
+			result.Passed, _ = strconv.Atoi(m[1])
+		}
+	}
+	result.Failures = append(result.Failures, pending...)
+	if result.Failed == 0 {
+		result.Failed = len(result.FailedTests)
+	}
+	return result
+}
+
+// Attribute maps the failures back to the models and selectors of the
+// spec, using the folders of the tests (the tests of each model are in
+// a folder named after the model), and the generated test code.
+func (result *CodeQLTestResult) Attribute(spec *XSpec, testsRoot string) {
+	for _, failure := range result.Failures {
+		rel, err := filepath.Rel(testsRoot, failure.TestDir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		mdlDirName := strings.Split(filepath.ToSlash(rel), "/")[0]
+		var mdl *XModel
+		for _, candidate := range spec.Models {
+			if feparser.NewCodeQlName(candidate.Name) == mdlDirName {
+				mdl = candidate
+			}
+		}
+		if mdl == nil {
+			continue
+		}
+		failure.Model = mdl.Name
+
+		src, err := ioutil.ReadFile(filepath.Join(failure.TestDir, failure.File))
+		if err != nil {
+			continue
+		}
+		method, sel := AttributeTestLine(mdl, strings.Split(string(src), "\n"), failure.Line)
+		failure.Method = method
+		failure.Selector = sel
+	}
+}
+
+// AttributeTestLine returns the method and selector of the model
+// that produced the test case at the provided line (1-based) of
+// a generated go test.
+func AttributeTestLine(mdl *XModel, lines []string, line int) (string, *XSelector) {
+	key := newTestCaseKeys(mdl, mdl.ListAllPathVersions()).Attribute(lines, line)
+	if key == nil {
+		return "", nil
+	}
+	return key.Method, key.Selector
+}
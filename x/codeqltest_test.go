@@ -0,0 +1,337 @@
+package x_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gagliardetto/codemill/handlers/tainttracking"
+	"github.com/gagliardetto/codemill/x"
+	"github.com/gagliardetto/feparser"
+)
+
+// The test binary is also the fake codeql CLI: when run with fakeCodeQLEnv set,
+// it writes its args to that file, prints the content of fakeCodeQLOutputEnv,
+// and exits with the code in fakeCodeQLExitEnv.
+const (
+	fakeCodeQLEnv       = "CODEMILL_FAKE_CODEQL_ARGS"
+	fakeCodeQLOutputEnv = "CODEMILL_FAKE_CODEQL_OUTPUT"
+	fakeCodeQLExitEnv   = "CODEMILL_FAKE_CODEQL_EXIT"
+)
+
+func TestMain(m *testing.M) {
+	if argsPath := os.Getenv(fakeCodeQLEnv); argsPath != "" {
+		os.Exit(fakeCodeQL(argsPath))
+	}
+	os.Exit(m.Run())
+}
+
+func fakeCodeQL(argsPath string) int {
+	if err := ioutil.WriteFile(argsPath, []byte(strings.Join(os.Args[1:], "\n")), 0644); err != nil {
+		return 100
+	}
+	output, err := ioutil.ReadFile(os.Getenv(fakeCodeQLOutputEnv))
+	if err != nil {
+		return 100
+	}
+	os.Stdout.Write(output)
+	code, _ := strconv.Atoi(os.Getenv(fakeCodeQLExitEnv))
+	return code
+}
+
+// runFakeCodeQL runs the runner with the fake codeql CLI, that prints
+// the provided output and exits with the provided code; it returns
+// the args the CLI was run with.
+func runFakeCodeQL(t *testing.T, output string, exitCode int, testDirs ...string) (*x.CodeQLTestResult, []string, error) {
+	dir, err := ioutil.TempDir("", "codemill-codeql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	argsPath := filepath.Join(dir, "args")
+	outputPath := filepath.Join(dir, "output")
+	if err := ioutil.WriteFile(outputPath, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(fakeCodeQLEnv, argsPath)
+	os.Setenv(fakeCodeQLOutputEnv, outputPath)
+	os.Setenv(fakeCodeQLExitEnv, strconv.Itoa(exitCode))
+	defer func() {
+		os.Unsetenv(fakeCodeQLEnv)
+		os.Unsetenv(fakeCodeQLOutputEnv)
+		os.Unsetenv(fakeCodeQLExitEnv)
+	}()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &x.CodeQLTestRunner{
+		CLIPath:     executable,
+		SearchPaths: []string{"/opt/codeql", "/src/codeql-go/ql"},
+	}
+	result, runErr := runner.Run(testDirs...)
+
+	args, err := ioutil.ReadFile(argsPath)
+	if err != nil {
+		t.Fatalf("fake codeql not run: %s", err)
+	}
+	return result, strings.Split(string(args), "\n"), runErr
+}
+
+const codeqlOutputPass = `Executing 2 tests in 2 directories.
+Extracting test database in /tests/Foo/ModelFoo.
+Compiling queries in /tests/Foo/ModelFoo.
+[1/2 comp 1.1s eval 312ms] PASSED /tests/Foo/ModelFoo/TaintFlows.ql
+[2/2 comp 1s eval 298ms] PASSED /tests/Bar/ModelBar/TaintFlows.ql
+All 2 tests passed.
+`
+
+const codeqlOutputFail = `Executing 2 tests in 2 directories.
+Extracting test database in /tests/Foo/ModelFoo.
+Compiling queries in /tests/Foo/ModelFoo.
+--- expected
++++ actual
+@@ -1,1 +1,3 @@
+ | test.go:12:3:12:40 | comment | OK |
++| test.go:8:2:8:16 | comment | Missing result:taintSink= |
++| test.go:20:9:20:21 | call to Foo | Unexpected result:taintSink= |
+[1/2 comp 1.1s eval 312ms] FAILED(RESULT) /tests/Foo/ModelFoo/TaintFlows.ql
+[2/2 comp 1s eval 298ms] PASSED /tests/Bar/ModelBar/TaintFlows.ql
+1 tests passed; 1 tests failed:
+  FAILED: /tests/Foo/ModelFoo/TaintFlows.ql
+`
+
+const codeqlOutputCompileError = `Executing 1 tests in 1 directories.
+Extracting test database in /tests/Foo/ModelFoo.
+Compiling queries in /tests/Foo/ModelFoo.
+ERROR: could not resolve module Codemill (/tests/Foo/ModelFoo/TaintFlows.ql:3,8-16)
+ERROR: could not resolve type TaintTracking::Configuration (/tests/Foo/ModelFoo/TaintFlows.ql:5,24-51)
+[1/1 comp 412ms] FAILED(COMPILATION) /tests/Foo/ModelFoo/TaintFlows.ql
+0 tests passed; 1 tests failed:
+  FAILED: /tests/Foo/ModelFoo/TaintFlows.ql
+`
+
+func TestParseCodeQLTestOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected *x.CodeQLTestResult
+	}{
+		{
+			name:   "pass",
+			output: codeqlOutputPass,
+			expected: &x.CodeQLTestResult{
+				Passed: 2,
+			},
+		},
+		{
+			name:   "fail",
+			output: codeqlOutputFail,
+			expected: &x.CodeQLTestResult{
+				Passed:      1,
+				Failed:      1,
+				FailedTests: []string{"/tests/Foo/ModelFoo/TaintFlows.ql"},
+				Failures: []*x.CodeQLTestFailure{
+					{
+						TestDir: "/tests/Foo/ModelFoo",
+						File:    "test.go",
+						Line:    8,
+						Element: "comment",
+						Message: "Missing result:taintSink=",
+					},
+					{
+						TestDir: "/tests/Foo/ModelFoo",
+						File:    "test.go",
+						Line:    20,
+						Element: "call to Foo",
+						Message: "Unexpected result:taintSink=",
+					},
+				},
+			},
+		},
+		{
+			name:   "compile error",
+			output: codeqlOutputCompileError,
+			expected: &x.CodeQLTestResult{
+				Failed:      1,
+				FailedTests: []string{"/tests/Foo/ModelFoo/TaintFlows.ql"},
+				Failures: []*x.CodeQLTestFailure{
+					{
+						TestDir: "/tests/Foo/ModelFoo",
+						File:    "TaintFlows.ql",
+						Line:    3,
+						Message: "ERROR: could not resolve module Codemill",
+					},
+					{
+						TestDir: "/tests/Foo/ModelFoo",
+						File:    "TaintFlows.ql",
+						Line:    5,
+						Message: "ERROR: could not resolve type TaintTracking::Configuration",
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := x.ParseCodeQLTestOutput(tt.output)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got:\n%s\nexpected:\n%s", formatTestResult(got), formatTestResult(tt.expected))
+			}
+		})
+	}
+}
+
+func formatTestResult(result *x.CodeQLTestResult) string {
+	lines := []string{
+		"passed: " + strconv.Itoa(result.Passed),
+		"failed: " + strconv.Itoa(result.Failed),
+		"failed tests: " + strings.Join(result.FailedTests, ", "),
+	}
+	for _, failure := range result.Failures {
+		lines = append(lines, failure.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCodeQLTestRunnerRun(t *testing.T) {
+	t.Run("pass", func(t *testing.T) {
+		result, args, err := runFakeCodeQL(t, codeqlOutputPass, 0, "/tests/Foo", "/tests/Bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedArgs := []string{
+			"test",
+			"run",
+			"--search-path=/opt/codeql",
+			"--search-path=/src/codeql-go/ql",
+			"/tests/Foo",
+			"/tests/Bar",
+		}
+		if !reflect.DeepEqual(args, expectedArgs) {
+			t.Errorf("args: got %q, expected %q", args, expectedArgs)
+		}
+		if result.Passed != 2 || result.Failed != 0 {
+			t.Errorf("got %v passed, %v failed", result.Passed, result.Failed)
+		}
+	})
+	t.Run("failing tests are not an error", func(t *testing.T) {
+		result, _, err := runFakeCodeQL(t, codeqlOutputFail, 1, "/tests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Failed != 1 || len(result.Failures) != 2 {
+			t.Errorf("got %v failed, %v failures", result.Failed, len(result.Failures))
+		}
+	})
+	t.Run("compile errors are failures", func(t *testing.T) {
+		result, _, err := runFakeCodeQL(t, codeqlOutputCompileError, 1, "/tests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Failed != 1 || len(result.Failures) != 2 {
+			t.Errorf("got %v failed, %v failures", result.Failed, len(result.Failures))
+		}
+	})
+	t.Run("CLI error", func(t *testing.T) {
+		_, _, err := runFakeCodeQL(t, "A fatal error occurred: no tests found\n", 2, "/tests")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestCodeQLTestResultAttribute(t *testing.T) {
+	registerTestHandlers(t)
+
+	pkg := newTestPackage()
+	x.SetCachedSource(testPkgPath, "v1.0.0", pkg)
+	unescape := x.FindFuncByID(pkg, feparser.FormatID("Function", "Unescape"))
+
+	spec := x.NewXSpecWithName("Test")
+	mdl := &x.XModel{
+		Name:    "Foo",
+		Kind:    tainttracking.Kind,
+		Methods: x.NewScavengeMethods(tainttracking.Kind),
+	}
+	mdl.Methods[0].Selectors = append(mdl.Methods[0].Selectors, &x.XSelector{
+		Kind: x.SelectorKindFunc,
+		Qualifier: &x.FuncQualifier{
+			BasicQualifier: x.BasicQualifier{
+				Path:    testPkgPath,
+				Version: "v1.0.0",
+				ID:      feparser.FormatID("Function", "Unescape"),
+			},
+			Name: "Unescape",
+			Flows: &x.FlowSpec{
+				Enabled: true,
+				Blocks: []*x.FlowBlock{
+					{
+						Inp: []bool{true, false, false, false},
+						Out: []bool{false, false, true, false},
+					},
+				},
+			},
+		},
+	})
+	spec.Models = append(spec.Models, mdl)
+
+	testsRoot, err := ioutil.TempDir("", "codemill-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testsRoot)
+	testDir := filepath.Join(testsRoot, feparser.NewCodeQlName(mdl.Name), "ModelFooForLib")
+	if err := os.MkdirAll(testDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	src := strings.Join([]string{
+		"package main",
+		"",
+		`import "example.com/lib"`,
+		"",
+		"func TaintStepTest_LibUnescape_B0I0O0(sourceCQL interface{}) interface{} {",
+		"\t// The flow is from `in` into `r0`.",
+		"",
+		"\t// " + unescape.GetFunc().Signature,
+		"\tfromByte := sourceCQL.([]byte)",
+		"\tintoByte, _ := lib.Unescape(fromByte, nil)",
+		"\treturn intoByte",
+		"}",
+		"",
+		"func main() {}",
+		"",
+	}, "\n")
+	if err := ioutil.WriteFile(filepath.Join(testDir, "test.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := &x.CodeQLTestResult{
+		Failed: 1,
+		Failures: []*x.CodeQLTestFailure{
+			{TestDir: testDir, File: "test.go", Line: 10, Message: "Missing result:taintSink="},
+			{TestDir: testDir, File: "test.go", Line: 14, Message: "Unexpected result:taintSink="},
+			{TestDir: filepath.Join(testsRoot, "Other"), File: "test.go", Line: 1, Message: "Missing result:taintSink="},
+		},
+	}
+	result.Attribute(spec, testsRoot)
+
+	attributed := result.Failures[0]
+	if attributed.Model != "Foo" || attributed.Method != mdl.Methods[0].Name || attributed.Selector != mdl.Methods[0].Selectors[0] {
+		t.Errorf("failure in the test case of Unescape attributed to model %q, method %q, selector %v", attributed.Model, attributed.Method, attributed.Selector)
+	}
+	notAttributed := result.Failures[1]
+	if notAttributed.Model != "Foo" || notAttributed.Selector != nil {
+		t.Errorf("failure outside test cases attributed to model %q, selector %v", notAttributed.Model, notAttributed.Selector)
+	}
+	otherModel := result.Failures[2]
+	if otherModel.Model != "" || otherModel.Selector != nil {
+		t.Errorf("failure of unknown model attributed to model %q, selector %v", otherModel.Model, otherModel.Selector)
+	}
+}
@@ -15,7 +15,7 @@ InpLoop:
 
 		inpElTyp, _, inpRelIndex, err := fn.GetRelativeElement(inpPos)
 		if err != nil {
-			Fatalf("Error while GetRelativeElement: %s", err)
+			Failf("Error while GetRelativeElement: %s", err)
 		}
 
 		switch inpElTyp {
@@ -134,3 +134,11 @@ func GenCqlParamQual(idName string, dotName string, fn FuncInterface, parameterI
 
 	return
 }
+
+// CqlFormatModelClassName returns the name of the codeql class generated
+// for the provided model and package; the name is namespaced
+// by both, so that classes of different models (of the same kind or not),
+// and of different packages of the same model, never clash.
+func CqlFormatModelClassName(modelName string, pathVersion string) string {
+	return feparser.NewCodeQlName(modelName, pathVersion)
+}
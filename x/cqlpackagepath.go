@@ -0,0 +1,217 @@
+package x
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	cqljen "github.com/gagliardetto/cqlgen/jen"
+	"github.com/gagliardetto/golang-go/cmd/go/not-internal/search"
+	. "github.com/gagliardetto/utilz"
+	"golang.org/x/mod/module"
+)
+
+// CqlPackagePath is a `packagePath()` predicate of the codeql module,
+// that returns the import path of a go module (any major version).
+type CqlPackagePath struct {
+	// Name is the name of the predicate.
+	Name string
+	// ModulePath is the path of the module without the major
+	// version suffix (e.g. `github.com/foo/bar` for `github.com/foo/bar/v2`,
+	// and `gopkg.in/yaml` for `gopkg.in/yaml.v2`).
+	ModulePath string
+
+	used bool
+}
+
+// CqlPackagePaths are the `packagePath()` predicates of a codeql module.
+type CqlPackagePaths struct {
+	mu         *sync.Mutex
+	predicates []*CqlPackagePath
+	// modules maps the package paths to the path of their module
+	// (with the major version suffix).
+	modules map[string]string
+}
+
+// NewCqlPackagePaths returns the `packagePath()` predicates
+// of the modules of the provided packages (one per module).
+func NewCqlPackagePaths(packages []*BasicQualifier) *CqlPackagePaths {
+	pp := &CqlPackagePaths{
+		mu:      &sync.Mutex{},
+		modules: make(map[string]string),
+	}
+
+	byModulePath := make(map[string]*CqlPackagePath)
+	for _, pkg := range packages {
+		if search.IsStandardImportPath(pkg.Path) {
+			continue
+		}
+		modPath := ModulePathOf(pkg.Path, pkg.Version)
+		pp.modules[pkg.Path] = modPath
+
+		prefix := TrimModuleMajorVersion(modPath)
+		if _, ok := byModulePath[prefix]; ok {
+			continue
+		}
+		pred := &CqlPackagePath{
+			ModulePath: prefix,
+		}
+		byModulePath[prefix] = pred
+		pp.predicates = append(pp.predicates, pred)
+	}
+
+	sort.Slice(pp.predicates, func(i, j int) bool {
+		return pp.predicates[i].ModulePath < pp.predicates[j].ModulePath
+	})
+
+	// Name the predicates:
+	if len(pp.predicates) == 1 {
+		pp.predicates[0].Name = "packagePath"
+	} else {
+		taken := make(map[string]bool)
+		for _, pred := range pp.predicates {
+			name := "packagePath" + ToCamel(lastPathElement(pred.ModulePath))
+			for i := 2; taken[name]; i++ {
+				name = Sf("packagePath%s%v", ToCamel(lastPathElement(pred.ModulePath)), i)
+			}
+			taken[name] = true
+			pred.Name = name
+		}
+	}
+	return pp
+}
+
+var (
+	rxGopkgInMajor        = regexp.MustCompile(`\.v\d+(-unstable)?$`)
+	rxMajorVersionElement = regexp.MustCompile(`^v([2-9]|[1-9]\d+)$`)
+)
+
+// ModulePathOf returns the path of the go module of the package:
+// that is the path of the module of the loaded package, if any;
+// otherwise, the path is inferred from the package path.
+func ModulePathOf(pkgPath string, version string) string {
+	source := GetCachedSource(pkgPath, version)
+	if source != nil && source.Module != nil && source.Module.Path != "" {
+		if source.Module.Path == pkgPath || strings.HasPrefix(pkgPath, source.Module.Path+"/") {
+			return source.Module.Path
+		}
+	}
+
+	parts := strings.Split(pkgPath, "/")
+	if parts[0] == "gopkg.in" {
+		for i := 1; i < len(parts); i++ {
+			if rxGopkgInMajor.MatchString(parts[i]) {
+				return strings.Join(parts[:i+1], "/")
+			}
+		}
+		return pkgPath
+	}
+	// A major version element (e.g. `/v2`) ends the module path:
+	for i := 1; i < len(parts); i++ {
+		if rxMajorVersionElement.MatchString(parts[i]) {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	switch parts[0] {
+	case "github.com", "gitlab.com", "bitbucket.org":
+		if len(parts) >= 3 {
+			return strings.Join(parts[:3], "/")
+		}
+	}
+	return pkgPath
+}
+
+// TrimModuleMajorVersion removes the major version suffix from the module path
+// (e.g. `/v2` of `github.com/foo/bar/v2`, and `.v2` of `gopkg.in/yaml.v2`).
+func TrimModuleMajorVersion(modPath string) string {
+	prefix, _, ok := module.SplitPathVersion(modPath)
+	if !ok {
+		return modPath
+	}
+	return prefix
+}
+
+func lastPathElement(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// Format returns the codeql expression of the provided package path:
+// the root package of the module calls the predicate of the module,
+// and the other packages of the module are `package(modulePath, subPath)`;
+// the second return value is false if the package has no predicate.
+func (pp *CqlPackagePaths) Format(pkgPath string) (cqljen.Code, bool) {
+	modPath, ok := pp.modules[pkgPath]
+	if !ok {
+		return nil, false
+	}
+	prefix := TrimModuleMajorVersion(modPath)
+	for _, pred := range pp.predicates {
+		if pred.ModulePath != prefix {
+			continue
+		}
+		subPath := strings.TrimPrefix(strings.TrimPrefix(pkgPath, modPath), "/")
+		if subPath != "" {
+			// The root package might not be imported (i.e. the predicate would have no results),
+			// so the subpackages are matched on their own:
+			return cqlPackageCall(prefix, subPath), true
+		}
+		pp.mu.Lock()
+		pred.used = true
+		pp.mu.Unlock()
+		return cqljen.Id(pred.Name).Call(), true
+	}
+	return nil, false
+}
+
+// Generate adds the predicates that have been used
+// (i.e. formatted) to the provided module block.
+func (pp *CqlPackagePaths) Generate(moduleGroup *cqljen.Group) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for _, pred := range pp.predicates {
+		if !pred.used {
+			continue
+		}
+		moduleGroup.Doc(Sf("Gets the package path of the `%s` module (any major version).", pred.ModulePath))
+		moduleGroup.Private().String().Id(pred.Name).Call().BlockFunc(func(group *cqljen.Group) {
+			group.Id("result").Eq().Add(cqlPackageCall(pred.ModulePath, ""))
+		})
+	}
+}
+
+// CodeQLContext is the context of the generation of a codeql module,
+// provided to the handlers.
+type CodeQLContext struct {
+	// ImportAdder adds the imports to the codeql file.
+	ImportAdder
+	// PackagePaths are the `packagePath()` predicates of the module;
+	// nil disables them.
+	PackagePaths *CqlPackagePaths
+}
+
+// FormatPackagePath returns the codeql expression that matches the
+// provided package path: standard library packages are string literals;
+// the other packages call the `packagePath()` predicate of their module
+// (see PackagePaths), or else `package(modulePath, subPath)`.
+func (ctx *CodeQLContext) FormatPackagePath(path string) cqljen.Code {
+	if isStd := search.IsStandardImportPath(path); isStd {
+		return cqljen.Lit(path)
+	}
+	if ctx.PackagePaths != nil {
+		if code, ok := ctx.PackagePaths.Format(path); ok {
+			return code
+		}
+	}
+	modPath := ModulePathOf(path, "")
+	return cqlPackageCall(
+		TrimModuleMajorVersion(modPath),
+		strings.TrimPrefix(strings.TrimPrefix(path, modPath), "/"),
+	)
+}
+
+// cqlPackageCall returns a call to the `package(mod, path)` predicate of codeql,
+// which matches the packages of any major version of the module.
+func cqlPackageCall(modulePath string, subPath string) cqljen.Code {
+	return cqljen.Id("package").Call(cqljen.List(cqljen.Lit(modulePath), cqljen.Lit(subPath)))
+}
@@ -0,0 +1,526 @@
+package x
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic codes:
+const (
+	DiagInvalidJSON      = "invalid-json"
+	DiagInvalidName      = "invalid-name"
+	DiagDuplicateName    = "duplicate-name"
+	DiagInvalidKind      = "invalid-kind"
+	DiagHandler          = "handler"
+	DiagInvalidSelector  = "invalid-selector"
+	DiagDuplicateSel     = "duplicate-selector"
+	DiagMissingSource    = "missing-source"
+	DiagStaleID          = "stale-id"
+	DiagStaleField       = "stale-field"
+	DiagPosLength        = "pos-length"
+	DiagEmptySelector    = "empty-selector"
+	DiagEmptyFlowBlock   = "empty-flow-block"
+	DiagInvalidFlowBlock = "invalid-flow-block"
+	DiagUnpairedSelector = "unpaired-selector"
+	DiagMultiversion     = "multiversion-conflict"
+	DiagUnsupported      = "unsupported-selection"
+)
+
+// Diagnostic is a single problem found in a spec.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Pointer  string // JSON pointer (RFC 6901) to the offending value inside the spec file.
+	Line     int    `json:",omitempty"` // 1-based; zero if unknown.
+	Column   int    `json:",omitempty"` // 1-based; zero if unknown.
+}
+
+// DiagnosticReport collects all the problems found in a spec,
+// instead of stopping at the first one.
+type DiagnosticReport struct {
+	File        string `json:",omitempty"`
+	Errors      int
+	Warnings    int
+	Diagnostics []*Diagnostic
+}
+
+func NewDiagnosticReport(file string) *DiagnosticReport {
+	return &DiagnosticReport{
+		File:        file,
+		Diagnostics: make([]*Diagnostic, 0),
+	}
+}
+
+// Errorf adds an error diagnostic to the report.
+func (rep *DiagnosticReport) Errorf(code string, pointer string, format string, a ...interface{}) {
+	rep.add(SeverityError, code, pointer, fmt.Sprintf(format, a...))
+}
+
+// Warnf adds a warning diagnostic to the report.
+func (rep *DiagnosticReport) Warnf(code string, pointer string, format string, a ...interface{}) {
+	rep.add(SeverityWarning, code, pointer, fmt.Sprintf(format, a...))
+}
+
+func (rep *DiagnosticReport) add(severity Severity, code string, pointer string, message string) {
+	rep.Diagnostics = append(rep.Diagnostics, &Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  message,
+		Pointer:  pointer,
+	})
+	switch severity {
+	case SeverityError:
+		rep.Errors++
+	case SeverityWarning:
+		rep.Warnings++
+	}
+}
+
+// HasErrors returns true if the report contains at least one error.
+func (rep *DiagnosticReport) HasErrors() bool {
+	return rep.Errors > 0
+}
+
+// ModelDiagnoser can be implemented by a ModelKindHandler to report
+// kind-specific problems of a model; modelPointer is the JSON pointer
+// of the model inside the spec file.
+type ModelDiagnoser interface {
+	Diagnose(report *DiagnosticReport, mdl *XModel, modelPointer string)
+}
+
+// JSONPointer formats the provided tokens as a JSON pointer (RFC 6901).
+func JSONPointer(tokens ...interface{}) string {
+	var buf strings.Builder
+	for _, tok := range tokens {
+		buf.WriteString("/")
+		s := fmt.Sprint(tok)
+		s = strings.Replace(s, "~", "~0", -1)
+		s = strings.Replace(s, "/", "~1", -1)
+		buf.WriteString(s)
+	}
+	return buf.String()
+}
+
+// DiagnoseSpecFile loads the spec file at the provided path
+// and collects all the problems found in it; the returned report
+// contains the line and column of each problem.
+// The loader is used to check that the selectors still match the sources.
+func DiagnoseSpecFile(path string, loader PackageLoader) (*DiagnosticReport, error) {
+	if IsReadableSpecPath(path) {
+		// The locations of the readable format are not resolved;
+		// the JSON pointers refer to the equivalent JSON spec.
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading spec file: %s", err)
+		}
+		report := NewDiagnosticReport(path)
+		in, err := decodeReadableSpec(data)
+		if err != nil {
+			report.Errorf(DiagInvalidJSON, "", "cannot parse spec file: %s", err)
+			return report, nil
+		}
+		spec := in.toSpec(loader, func(selErr *readableSelectorError) {
+			if selErr.LoadFunc {
+				// Reported by DiagnoseSpec (missing source, or stale ID).
+				return
+			}
+			report.Errorf(DiagInvalidSelector, selErr.Pointer, "%s", selErr.Err)
+		})
+		DiagnoseSpec(report, spec, loader)
+		return report, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading spec file: %s", err)
+	}
+	report := NewDiagnosticReport(path)
+
+	spec := newXSpec()
+	if err := json.Unmarshal(data, spec); err != nil {
+		report.Errorf(DiagInvalidJSON, "", "cannot parse spec file: %s", err)
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			report.Diagnostics[0].Line, report.Diagnostics[0].Column = offsetToLineColumn(data, int(syntaxErr.Offset))
+		}
+		return report, nil
+	}
+
+	DiagnoseSpec(report, spec, loader)
+
+	if err := report.resolveLocations(data); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// DiagnoseSpec adds to the report all the problems found in the provided spec.
+// The spec is not modified (i.e. names are not normalized, and empty selectors are not removed).
+func DiagnoseSpec(report *DiagnosticReport, spec *XSpec, loader PackageLoader) {
+	if ToCamel(spec.Name) == "" {
+		report.Errorf(DiagInvalidName, JSONPointer("Name"), "spec name %q is not valid", spec.Name)
+	}
+
+	// Load all the used sources once:
+	missingSources := make(map[string]error)
+	for _, mod := range spec.ListModules() {
+		if err := mod.Validate(); err != nil {
+			continue
+		}
+		if _, err := loader(mod.Path, mod.Version); err != nil {
+			missingSources[mod.PathVersion()] = err
+		}
+	}
+
+	modelNames := make(map[string]int)
+	for modelIndex, mdl := range spec.Models {
+		modelPointer := JSONPointer("Models", modelIndex)
+
+		name := ToCamel(mdl.Name)
+		if name == "" {
+			report.Errorf(DiagInvalidName, modelPointer+"/Name", "model name %q is not valid", mdl.Name)
+		} else if prev, ok := modelNames[name]; ok {
+			report.Errorf(DiagDuplicateName, modelPointer+"/Name", "model name %q is already used by %s", mdl.Name, JSONPointer("Models", prev))
+		} else {
+			modelNames[name] = modelIndex
+		}
+
+		if !IsValidModelKind(mdl.Kind) {
+			report.Errorf(DiagInvalidKind, modelPointer+"/Kind", "model kind not valid: %q", mdl.Kind)
+		} else {
+			handler := Router().MustGetHandler(mdl.Kind)
+			diagnoser, isDiagnoser := handler.(ModelDiagnoser)
+			if err := handler.Validate(mdl); err != nil {
+				var unpaired *UnpairedSelectorError
+				if !(isDiagnoser && errors.As(err, &unpaired)) {
+					// The diagnoser reports all the unpaired selectors (with their location).
+					report.Errorf(DiagHandler, modelPointer, "%s", err)
+				}
+			}
+			if isDiagnoser {
+				diagnoser.Diagnose(report, mdl, modelPointer)
+			}
+		}
+
+		methodNames := make(map[string]int)
+		for methodIndex, mtd := range mdl.Methods {
+			methodPointer := JSONPointer("Models", modelIndex, "Methods", methodIndex)
+
+			if strings.TrimSpace(mtd.Name) == "" {
+				report.Errorf(DiagInvalidName, methodPointer+"/Name", "method name is empty")
+			} else if prev, ok := methodNames[mtd.Name]; ok {
+				report.Errorf(DiagDuplicateName, methodPointer+"/Name", "method name %q is already used by %s", mtd.Name, JSONPointer("Models", modelIndex, "Methods", prev))
+			} else {
+				methodNames[mtd.Name] = methodIndex
+			}
+
+			diagnoseSelectors(report, mtd, methodPointer, missingSources)
+			diagnoseCapabilities(report, mdl, mtd, methodPointer)
+		}
+
+		_, conflicts := UnionVersions(mdl)
+		for _, conflict := range conflicts {
+			report.Warnf(
+				DiagMultiversion,
+				modelPointer+JSONPointer("Methods", conflict.methodIndex, "Selectors", conflict.selectorIndexes[0], "Qualifier"),
+				"%q of %s has different selections across versions %s (fails the generation with --multiversion)",
+				conflict.ID,
+				conflict.Path,
+				strings.Join(conflict.Versions, ", "),
+			)
+		}
+	}
+}
+
+// diagnoseCapabilities reports the selectors that the
+// capabilities of the method (of the handler) do not support.
+func diagnoseCapabilities(report *DiagnosticReport, mdl *XModel, mtd *XMethod, methodPointer string) {
+	if !IsValidModelKind(mdl.Kind) {
+		return
+	}
+	caps, err := Router().GetMethodCapabilities(mdl.Kind, mtd.Name)
+	if err != nil {
+		// Unknown methods are reported by the handler.
+		return
+	}
+	for selectorIndex, sel := range mtd.Selectors {
+		if err := caps.CheckSelector(sel, funcElementsMetaOf(sel)); err != nil {
+			report.Errorf(DiagUnsupported, methodPointer+JSONPointer("Selectors", selectorIndex, "Qualifier"), "%s", err)
+		}
+	}
+}
+
+func diagnoseSelectors(report *DiagnosticReport, mtd *XMethod, methodPointer string, missingSources map[string]error) {
+	seen := make(map[string]int)
+	for selectorIndex, sel := range mtd.Selectors {
+		selectorPointer := methodPointer + JSONPointer("Selectors", selectorIndex)
+		qualifierPointer := selectorPointer + "/Qualifier"
+
+		basicQual := sel.GetBasicQualifier()
+		if err := basicQual.Validate(); err != nil {
+			report.Errorf(DiagInvalidSelector, qualifierPointer, "%s", err)
+			continue
+		}
+
+		key := FormatPathVersion(basicQual.Path, basicQual.Version) + "#" + basicQual.ID
+		if prev, ok := seen[key]; ok {
+			report.Errorf(DiagDuplicateSel, qualifierPointer+"/ID", "%q is already selected by %s", basicQual.ID, methodPointer+JSONPointer("Selectors", prev))
+		} else {
+			seen[key] = selectorIndex
+		}
+
+		if err, ok := missingSources[basicQual.PathVersion()]; ok {
+			report.Errorf(DiagMissingSource, qualifierPointer+"/Version", "source %s not available: %s", basicQual.PathVersion(), err)
+			continue
+		}
+		source := GetCachedSource(basicQual.Path, basicQual.Version)
+		if source == nil {
+			report.Errorf(DiagMissingSource, qualifierPointer+"/Version", "source %s not available", basicQual.PathVersion())
+			continue
+		}
+
+		switch qual := sel.Qualifier.(type) {
+		case *FuncQualifier:
+			diagnoseFuncQualifier(report, qual, source, qualifierPointer)
+		case *StructQualifier:
+			st := FindStructByID(source, qual.ID)
+			if st == nil {
+				report.Errorf(DiagStaleID, qualifierPointer+"/ID", "struct %q not found in %s", qual.ID, qual.PathVersion())
+				continue
+			}
+			if len(qual.Fields) == 0 {
+				report.Warnf(DiagEmptySelector, qualifierPointer+"/Fields", "no fields selected")
+			}
+			fieldNames := make([]string, 0, len(qual.Fields))
+			for fieldName := range qual.Fields {
+				fieldNames = append(fieldNames, fieldName)
+			}
+			sort.Strings(fieldNames)
+			for _, fieldName := range fieldNames {
+				if FindFieldByName(st, fieldName) == nil {
+					report.Errorf(DiagStaleField, qualifierPointer+JSONPointer("Fields", fieldName), "field %q not found in struct %q", fieldName, qual.ID)
+				}
+			}
+		case *TypeQualifier:
+			if FindTypeByID(source, qual.ID) == nil {
+				report.Errorf(DiagStaleID, qualifierPointer+"/ID", "type %q not found in %s", qual.ID, qual.PathVersion())
+				continue
+			}
+			if !qual.Value {
+				report.Warnf(DiagEmptySelector, qualifierPointer+"/Value", "type is not selected")
+			}
+		}
+	}
+}
+
+func diagnoseFuncQualifier(report *DiagnosticReport, qual *FuncQualifier, source *feparser.FEPackage, qualifierPointer string) {
+	fn := FindFuncByID(source, qual.ID)
+	if fn == nil {
+		report.Errorf(DiagStaleID, qualifierPointer+"/ID", "func %q not found in %s", qual.ID, qual.PathVersion())
+		return
+	}
+	if AllFalse(qual.Pos...) && (qual.Flows == nil || AllBlocksEmpty(qual.Flows.Blocks...)) {
+		report.Warnf(DiagEmptySelector, qualifierPointer, "nothing selected for func %q", qual.ID)
+	}
+	if len(qual.Pos) > 0 && len(qual.Pos) != fn.Len() {
+		report.Errorf(DiagPosLength, qualifierPointer+"/Pos", "Pos has %v elements, but func %q has %v", len(qual.Pos), qual.ID, fn.Len())
+	}
+	if qual.Flows == nil {
+		return
+	}
+	for blockIndex, block := range qual.Flows.Blocks {
+		blockPointer := qualifierPointer + JSONPointer("Flows", "Blocks", blockIndex)
+		if AllFalse(block.Inp...) && AllFalse(block.Out...) {
+			report.Warnf(DiagEmptyFlowBlock, blockPointer, "flow block %v is empty", blockIndex)
+			continue
+		}
+		if len(block.Inp) != fn.Len() || len(block.Out) != fn.Len() {
+			report.Errorf(DiagInvalidFlowBlock, blockPointer, "flow block %v has lengths Inp=%v, Out=%v; func %q has %v elements", blockIndex, len(block.Inp), len(block.Out), qual.ID, fn.Len())
+			continue
+		}
+		if AllFalse(block.Inp...) {
+			report.Errorf(DiagInvalidFlowBlock, blockPointer+"/Inp", "Inp of flow block %v is all false", blockIndex)
+		}
+		if AllFalse(block.Out...) {
+			report.Errorf(DiagInvalidFlowBlock, blockPointer+"/Out", "Out of flow block %v is all false", blockIndex)
+		}
+	}
+}
+
+// DiagnoseUnpairedSelectors reports the func selectors of the method named `first`
+// that don't have a counterpart (same path, version and ID) in the method named `second`,
+// and vice versa.
+func DiagnoseUnpairedSelectors(report *DiagnosticReport, mdl *XModel, modelPointer string, first string, second string) {
+	forEachUnpairedSelector(mdl, first, second, func(from int, selectorIndex int, unpaired *UnpairedSelectorError) {
+		report.Errorf(
+			DiagUnpairedSelector,
+			modelPointer+JSONPointer("Methods", from, "Selectors", selectorIndex, "Qualifier", "ID"),
+			"%s",
+			unpaired,
+		)
+	})
+}
+
+// resolveLocations sets the line and column of each diagnostic,
+// by finding its JSON pointer inside the provided JSON document.
+func (rep *DiagnosticReport) resolveLocations(data []byte) error {
+	offsets := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := walkJSONOffsets(dec, data, "", offsets); err != nil {
+		return fmt.Errorf("error while locating diagnostics: %s", err)
+	}
+	for _, diag := range rep.Diagnostics {
+		offset, ok := offsets[diag.Pointer]
+		if !ok {
+			continue
+		}
+		diag.Line, diag.Column = offsetToLineColumn(data, offset)
+	}
+	return nil
+}
+
+// walkJSONOffsets records the offset of the start
+// of each value of the JSON document, by JSON pointer.
+func walkJSONOffsets(dec *json.Decoder, data []byte, pointer string, offsets map[string]int) error {
+	offsets[pointer] = skipJSONSeparators(data, int(dec.InputOffset()))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	switch delim {
+	case '{':
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := keyTok.(string)
+			if !ok {
+				return fmt.Errorf("object key is not a string: %v", keyTok)
+			}
+			if err := walkJSONOffsets(dec, data, pointer+JSONPointer(key), offsets); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for index := 0; dec.More(); index++ {
+			if err := walkJSONOffsets(dec, data, pointer+"/"+strconv.Itoa(index), offsets); err != nil {
+				return err
+			}
+		}
+	}
+	// Consume the closing delimiter:
+	_, err = dec.Token()
+	return err
+}
+
+func skipJSONSeparators(data []byte, offset int) int {
+	for offset < len(data) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+func offsetToLineColumn(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
+}
+
+// SARIF returns the report formatted as a SARIF 2.1.0 log.
+func (rep *DiagnosticReport) SARIF() interface{} {
+	type region struct {
+		StartLine   int `json:"startLine,omitempty"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region *region `json:"region,omitempty"`
+		} `json:"physicalLocation"`
+		LogicalLocations []map[string]string `json:"logicalLocations,omitempty"`
+	}
+	type result struct {
+		RuleID    string            `json:"ruleId"`
+		Level     string            `json:"level"`
+		Message   map[string]string `json:"message"`
+		Locations []*location       `json:"locations"`
+	}
+
+	results := make([]*result, 0)
+	ruleIDs := make([]string, 0)
+	for _, diag := range rep.Diagnostics {
+		loc := &location{}
+		loc.PhysicalLocation.ArtifactLocation.URI = rep.File
+		if diag.Line > 0 {
+			loc.PhysicalLocation.Region = &region{
+				StartLine:   diag.Line,
+				StartColumn: diag.Column,
+			}
+		}
+		if diag.Pointer != "" {
+			loc.LogicalLocations = []map[string]string{{"fullyQualifiedName": diag.Pointer}}
+		}
+		results = append(results, &result{
+			RuleID:    diag.Code,
+			Level:     string(diag.Severity),
+			Message:   map[string]string{"text": diag.Message},
+			Locations: []*location{loc},
+		})
+		if !SliceContains(ruleIDs, diag.Code) {
+			ruleIDs = append(ruleIDs, diag.Code)
+		}
+	}
+	sort.Strings(ruleIDs)
+	rules := make([]map[string]string, 0)
+	for _, id := range ruleIDs {
+		rules = append(rules, map[string]string{"id": id})
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":  "codemill",
+						"rules": rules,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+}
@@ -0,0 +1,513 @@
+package x
+
+import (
+	"sort"
+	"strings"
+
+	. "github.com/gagliardetto/utilz"
+)
+
+type SpecChangeOp string
+
+const (
+	SpecChangeAdded    SpecChangeOp = "added"
+	SpecChangeRemoved  SpecChangeOp = "removed"
+	SpecChangeModified SpecChangeOp = "modified"
+)
+
+// SpecChange is a semantic difference between two specs;
+// if Model is empty, the change is about the spec itself;
+// if Qualifier is nil, the change is about the model.
+type SpecChange struct {
+	Op        SpecChangeOp
+	Model     string          `json:",omitempty"`
+	Method    string          `json:",omitempty"`
+	Kind      SelectorKind    `json:",omitempty"`
+	Qualifier *BasicQualifier `json:",omitempty"`
+	Signature string          `json:",omitempty"`
+	Details   []string        `json:",omitempty"`
+}
+
+// DiffSpecs returns the semantic differences between
+// the `from` spec and the `to` spec; selectors are matched by
+// model, method, path, version and ID.
+// If the sources are cached, the positions are described
+// by element (e.g. `param:key`) instead of by index.
+func DiffSpecs(from *XSpec, to *XSpec) []*SpecChange {
+	changes := make([]*SpecChange, 0)
+
+	if from.Name != to.Name {
+		changes = append(changes, &SpecChange{
+			Op:      SpecChangeModified,
+			Details: []string{Sf("Name: %q -> %q", from.Name, to.Name)},
+		})
+	}
+
+	for _, name := range unionModelNames(from, to) {
+		fromModel := from.modelByName(name)
+		toModel := to.modelByName(name)
+
+		switch {
+		case fromModel == nil:
+			changes = append(changes, &SpecChange{Op: SpecChangeAdded, Model: name, Details: []string{Sf("Kind: %s", toModel.Kind)}})
+		case toModel == nil:
+			changes = append(changes, &SpecChange{Op: SpecChangeRemoved, Model: name, Details: []string{Sf("Kind: %s", fromModel.Kind)}})
+		case fromModel.Kind != toModel.Kind:
+			changes = append(changes, &SpecChange{Op: SpecChangeModified, Model: name, Details: []string{Sf("Kind: %s -> %s", fromModel.Kind, toModel.Kind)}})
+		}
+
+		for _, methodName := range unionMethodNames(fromModel, toModel) {
+			fromMethod := methodOrNil(fromModel, methodName)
+			toMethod := methodOrNil(toModel, methodName)
+
+			for _, key := range unionSelectorKeys(fromMethod, toMethod) {
+				fromSel := selectorByKey(fromMethod, key)
+				toSel := selectorByKey(toMethod, key)
+
+				change := &SpecChange{
+					Model:  name,
+					Method: methodName,
+				}
+				switch {
+				case fromSel == nil:
+					change.Op = SpecChangeAdded
+					change.Kind = toSel.Kind
+					change.Qualifier = toSel.GetBasicQualifier()
+					change.Details = describeSelection(toSel)
+				case toSel == nil:
+					change.Op = SpecChangeRemoved
+					change.Kind = fromSel.Kind
+					change.Qualifier = fromSel.GetBasicQualifier()
+					change.Details = describeSelection(fromSel)
+				default:
+					details := diffSelectors(fromSel, toSel)
+					if len(details) == 0 {
+						continue
+					}
+					change.Op = SpecChangeModified
+					change.Kind = toSel.Kind
+					change.Qualifier = toSel.GetBasicQualifier()
+					change.Details = details
+				}
+				if fn := LookupFunc(change.Qualifier); fn != nil {
+					change.Signature = fn.GetFunc().Signature
+				}
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	return changes
+}
+
+func (spec *XSpec) modelByName(name string) *XModel {
+	for _, mdl := range spec.Models {
+		if mdl.Name == name {
+			return mdl
+		}
+	}
+	return nil
+}
+
+func methodOrNil(mdl *XModel, name string) *XMethod {
+	if mdl == nil {
+		return nil
+	}
+	return mdl.Methods.ByName(name)
+}
+
+func unionModelNames(specs ...*XSpec) []string {
+	names := make([]string, 0)
+	for _, spec := range specs {
+		if spec == nil {
+			continue
+		}
+		for _, mdl := range spec.Models {
+			if !containsExact(names, mdl.Name) {
+				names = append(names, mdl.Name)
+			}
+		}
+	}
+	return names
+}
+
+func unionMethodNames(models ...*XModel) []string {
+	names := make([]string, 0)
+	for _, mdl := range models {
+		if mdl == nil {
+			continue
+		}
+		for _, mtd := range mdl.Methods {
+			if !containsExact(names, mtd.Name) {
+				names = append(names, mtd.Name)
+			}
+		}
+	}
+	return names
+}
+
+func selectorKey(sel *XSelector) string {
+	basicQual := sel.GetBasicQualifier()
+	return basicQual.PathVersion() + "#" + basicQual.ID
+}
+
+func unionSelectorKeys(methods ...*XMethod) []string {
+	keys := make([]string, 0)
+	for _, mtd := range methods {
+		if mtd == nil {
+			continue
+		}
+		for _, sel := range mtd.Selectors {
+			if key := selectorKey(sel); !containsExact(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func selectorByKey(mtd *XMethod, key string) *XSelector {
+	if mtd == nil {
+		return nil
+	}
+	for _, sel := range mtd.Selectors {
+		if selectorKey(sel) == key {
+			return sel
+		}
+	}
+	return nil
+}
+
+// containsExact is a case-sensitive version of SliceContains.
+func containsExact(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// describeSelection describes what is selected by the selector.
+func describeSelection(sel *XSelector) []string {
+	switch qual := sel.Qualifier.(type) {
+	case *FuncQualifier:
+		fn := LookupFunc(&qual.BasicQualifier)
+		details := make([]string, 0)
+		if refs := FormatElementRefs(fn, qual.Pos); len(refs) > 0 {
+			details = append(details, "Pos: "+strings.Join(refs, ", "))
+		}
+		if qual.Flows != nil {
+			for _, block := range qual.Flows.Blocks {
+				details = append(details, "Flow: "+formatFlowBlock(fn, block))
+			}
+			if !qual.Flows.Enabled && len(qual.Flows.Blocks) > 0 {
+				details = append(details, "Flows: disabled")
+			}
+		}
+		if qual.ContentType != "" {
+			details = append(details, Sf("ContentType: %q", qual.ContentType))
+		}
+		return details
+	case *StructQualifier:
+		return []string{"Fields: " + strings.Join(sortedFieldNames(qual), ", ")}
+	case *TypeQualifier:
+		return []string{Sf("Value: %v", qual.Value)}
+	default:
+		panic(Sf("Unknown type: %T", sel.Qualifier))
+	}
+}
+
+// diffSelectors describes the differences between
+// two selectors of the same element.
+func diffSelectors(from *XSelector, to *XSelector) []string {
+	if from.Kind != to.Kind {
+		return []string{Sf("Kind: %s -> %s", from.Kind, to.Kind)}
+	}
+	details := make([]string, 0)
+
+	switch fromQual := from.Qualifier.(type) {
+	case *FuncQualifier:
+		toQual := to.GetFuncQualifier()
+		fn := LookupFunc(&toQual.BasicQualifier)
+
+		{
+			var posChanges []string
+			for index := 0; index < len(fromQual.Pos) || index < len(toQual.Pos); index++ {
+				wasSet := index < len(fromQual.Pos) && fromQual.Pos[index]
+				isSet := index < len(toQual.Pos) && toQual.Pos[index]
+				if wasSet && !isSet {
+					posChanges = append(posChanges, "-"+FormatElementRef(fn, index))
+				}
+				if !wasSet && isSet {
+					posChanges = append(posChanges, "+"+FormatElementRef(fn, index))
+				}
+			}
+			if len(posChanges) > 0 {
+				details = append(details, "Pos: "+strings.Join(posChanges, ", "))
+			}
+		}
+		{
+			fromEnabled := fromQual.Flows != nil && fromQual.Flows.Enabled
+			toEnabled := toQual.Flows != nil && toQual.Flows.Enabled
+			if fromEnabled != toEnabled {
+				details = append(details, Sf("Flows.Enabled: %v -> %v", fromEnabled, toEnabled))
+			}
+			fromBlocks := formatFlowBlocks(fn, fromQual.Flows)
+			toBlocks := formatFlowBlocks(fn, toQual.Flows)
+			for _, block := range fromBlocks {
+				if !containsExact(toBlocks, block) {
+					details = append(details, "-Flow: "+block)
+				}
+			}
+			for _, block := range toBlocks {
+				if !containsExact(fromBlocks, block) {
+					details = append(details, "+Flow: "+block)
+				}
+			}
+		}
+		if fromQual.ContentType != toQual.ContentType {
+			details = append(details, Sf("ContentType: %q -> %q", fromQual.ContentType, toQual.ContentType))
+		}
+	case *StructQualifier:
+		toQual := to.GetStructQualifier()
+		for _, name := range sortedFieldNames(fromQual) {
+			if _, ok := toQual.Fields[name]; !ok {
+				details = append(details, "-Field: "+name)
+			}
+		}
+		for _, name := range sortedFieldNames(toQual) {
+			if _, ok := fromQual.Fields[name]; !ok {
+				details = append(details, "+Field: "+name)
+			}
+		}
+	case *TypeQualifier:
+		toQual := to.GetTypeQualifier()
+		if fromQual.Value != toQual.Value {
+			details = append(details, Sf("Value: %v -> %v", fromQual.Value, toQual.Value))
+		}
+	default:
+		panic(Sf("Unknown type: %T", from.Qualifier))
+	}
+
+	return details
+}
+
+func formatFlowBlock(fn FuncInterface, block *FlowBlock) string {
+	return Sf(
+		"%s -> %s",
+		strings.Join(FormatElementRefs(fn, block.Inp), ", "),
+		strings.Join(FormatElementRefs(fn, block.Out), ", "),
+	)
+}
+
+func formatFlowBlocks(fn FuncInterface, flows *FlowSpec) []string {
+	res := make([]string, 0)
+	if flows == nil {
+		return res
+	}
+	for _, block := range flows.Blocks {
+		res = append(res, formatFlowBlock(fn, block))
+	}
+	return res
+}
+
+func sortedFieldNames(qual *StructQualifier) []string {
+	names := make([]string, 0, len(qual.Fields))
+	for name := range qual.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MergeConflict is an element changed in different ways on the two sides of a merge.
+type MergeConflict struct {
+	Model     string          `json:",omitempty"`
+	Method    string          `json:",omitempty"`
+	Qualifier *BasicQualifier `json:",omitempty"`
+	Message   string
+}
+
+func (conflict *MergeConflict) Error() string {
+	var location []string
+	if conflict.Model != "" {
+		location = append(location, conflict.Model)
+	}
+	if conflict.Method != "" {
+		location = append(location, conflict.Method)
+	}
+	if conflict.Qualifier != nil {
+		location = append(location, conflict.Qualifier.PathVersion()+" "+conflict.Qualifier.ID)
+	}
+	if len(location) == 0 {
+		return conflict.Message
+	}
+	return strings.Join(location, ": ") + ": " + conflict.Message
+}
+
+// MergeSpecs does a three-way merge of the `ours` and `theirs` specs,
+// which both derive from the `base` spec; changes are resolved at the
+// selector level (i.e. by path, version and ID of each selector of each method).
+// If an element is changed in different ways on both sides,
+// a conflict is reported, and the `ours` version of it is kept.
+func MergeSpecs(base *XSpec, ours *XSpec, theirs *XSpec) (*XSpec, []*MergeConflict) {
+	merged := newXSpec()
+	conflicts := make([]*MergeConflict, 0)
+
+	{
+		name, conflict := merge3(base.Name, ours.Name, theirs.Name)
+		if conflict {
+			conflicts = append(conflicts, &MergeConflict{
+				Message: Sf("Name changed on both sides: %q, %q", ours.Name, theirs.Name),
+			})
+		}
+		merged.Name = name
+	}
+
+	for _, name := range unionModelNames(ours, theirs) {
+		baseModel := base.modelByName(name)
+		ourModel := ours.modelByName(name)
+		theirModel := theirs.modelByName(name)
+
+		if ourModel == nil || theirModel == nil {
+			// Added or removed on one side:
+			switch {
+			case modelsEqual(baseModel, ourModel):
+				if theirModel != nil {
+					merged.Models = append(merged.Models, theirModel)
+				}
+			case modelsEqual(baseModel, theirModel):
+				if ourModel != nil {
+					merged.Models = append(merged.Models, ourModel)
+				}
+			default:
+				conflicts = append(conflicts, &MergeConflict{
+					Model:   name,
+					Message: "model modified on one side and removed on the other",
+				})
+				if ourModel != nil {
+					merged.Models = append(merged.Models, ourModel)
+				}
+			}
+			continue
+		}
+
+		mergedModel := &XModel{
+			Name:    name,
+			Methods: make(XMethodSlice, 0),
+		}
+		{
+			var baseKind ModelKind
+			if baseModel != nil {
+				baseKind = baseModel.Kind
+			}
+			kind, conflict := merge3(string(baseKind), string(ourModel.Kind), string(theirModel.Kind))
+			if conflict {
+				conflicts = append(conflicts, &MergeConflict{
+					Model:   name,
+					Message: Sf("Kind changed on both sides: %s, %s", ourModel.Kind, theirModel.Kind),
+				})
+			}
+			mergedModel.Kind = ModelKind(kind)
+		}
+
+		for _, methodName := range unionMethodNames(ourModel, theirModel) {
+			baseMethod := methodOrNil(baseModel, methodName)
+			ourMethod := methodOrNil(ourModel, methodName)
+			theirMethod := methodOrNil(theirModel, methodName)
+
+			mergedMethod := &XMethod{
+				Name:      methodName,
+				Selectors: make([]*XSelector, 0),
+			}
+			if ourMethod != nil {
+				mergedMethod.Description = ourMethod.Description
+			} else {
+				mergedMethod.Description = theirMethod.Description
+			}
+
+			for _, key := range unionSelectorKeys(ourMethod, theirMethod) {
+				ourSel := selectorByKey(ourMethod, key)
+				theirSel := selectorByKey(theirMethod, key)
+				sel, conflict := mergeSelector(selectorByKey(baseMethod, key), ourSel, theirSel)
+				if conflict {
+					qual := ourSel
+					if qual == nil {
+						qual = theirSel
+					}
+					conflicts = append(conflicts, &MergeConflict{
+						Model:     name,
+						Method:    methodName,
+						Qualifier: qual.GetBasicQualifier(),
+						Message:   "selector changed on both sides",
+					})
+				}
+				if sel != nil {
+					mergedMethod.Selectors = append(mergedMethod.Selectors, sel)
+				}
+			}
+			mergedModel.Methods = append(mergedModel.Methods, mergedMethod)
+		}
+		merged.Models = append(merged.Models, mergedModel)
+	}
+
+	return merged, conflicts
+}
+
+// merge3 does a three-way merge of a string value.
+func merge3(base string, ours string, theirs string) (string, bool) {
+	switch {
+	case ours == theirs:
+		return ours, false
+	case base == ours:
+		return theirs, false
+	case base == theirs:
+		return ours, false
+	default:
+		return ours, true
+	}
+}
+
+// mergeSelector does a three-way merge of a selector;
+// a nil selector means that the selector is not present.
+func mergeSelector(base *XSelector, ours *XSelector, theirs *XSelector) (*XSelector, bool) {
+	switch {
+	case selectorsEqual(ours, theirs):
+		return ours, false
+	case selectorsEqual(base, ours):
+		return theirs, false
+	case selectorsEqual(base, theirs):
+		return ours, false
+	default:
+		return ours, true
+	}
+}
+
+func selectorsEqual(a *XSelector, b *XSelector) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return len(diffSelectors(a, b)) == 0
+}
+
+func modelsEqual(a *XModel, b *XModel) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind != b.Kind || len(a.Methods) != len(b.Methods) {
+		return false
+	}
+	for _, mtd := range a.Methods {
+		other := b.Methods.ByName(mtd.Name)
+		if other == nil || len(mtd.Selectors) != len(other.Selectors) {
+			return false
+		}
+		for _, sel := range mtd.Selectors {
+			if !selectorsEqual(sel, selectorByKey(other, selectorKey(sel))) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package x
+
+import (
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// FormatElementRef returns a human-readable reference to the element
+// at the provided absolute index of the func: `receiver`,
+// `param:<name>` (or `param:<index>` if the parameter is not named),
+// or `result:<index>`.
+// If the func is not known, the absolute index is returned as `#<index>`.
+func FormatElementRef(fn FuncInterface, index int) string {
+	if fn == nil {
+		return Sf("#%v", index)
+	}
+	elem, raw, relIndex, err := fn.GetRelativeElement(index)
+	if err != nil {
+		return Sf("#%v", index)
+	}
+	switch elem {
+	case feparser.ElementReceiver:
+		return "receiver"
+	case feparser.ElementParameter:
+		if typ, ok := raw.(*feparser.FEType); ok && isUniqueParamName(fn, typ.VarName) {
+			return "param:" + typ.VarName
+		}
+		return Sf("param:%v", relIndex)
+	case feparser.ElementResult:
+		return Sf("result:%v", relIndex)
+	default:
+		return Sf("#%v", index)
+	}
+}
+
+// FormatElementRefs returns the references of the
+// elements that are set to true in the provided positions.
+func FormatElementRefs(fn FuncInterface, positions []bool) []string {
+	refs := make([]string, 0)
+	for index, ok := range positions {
+		if ok {
+			refs = append(refs, FormatElementRef(fn, index))
+		}
+	}
+	return refs
+}
+
+func isUniqueParamName(fn FuncInterface, name string) bool {
+	if name == "" || name == "_" {
+		return false
+	}
+	count := 0
+	for _, param := range fn.GetFunc().Parameters {
+		if param.VarName == name {
+			count++
+		}
+	}
+	return count == 1
+}
+
+// LookupFunc returns the func of the qualifier from the
+// cached sources, or nil if the source or the func are not found.
+func LookupFunc(qual *BasicQualifier) FuncInterface {
+	source := GetCachedSource(qual.Path, qual.Version)
+	if source == nil {
+		return nil
+	}
+	return FindFuncByID(source, qual.ID)
+}
@@ -0,0 +1,32 @@
+package x
+
+import (
+	"fmt"
+)
+
+// generationError is the error with which Failf fails a generation.
+type generationError struct {
+	err error
+}
+
+// Failf fails the generation of the assets of a model from where an error
+// cannot be returned (e.g. from within the closures of the jen groups):
+// it panics with an error that is then returned by the generator, which
+// must defer CatchGenerationError.
+func Failf(format string, a ...interface{}) {
+	panic(&generationError{err: fmt.Errorf(format, a...)})
+}
+
+// CatchGenerationError sets as *err the error of a generation failed by Failf;
+// other panics are re-panicked. It must be deferred.
+func CatchGenerationError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if genErr, ok := r.(*generationError); ok {
+		*err = genErr.err
+		return
+	}
+	panic(r)
+}
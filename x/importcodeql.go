@@ -0,0 +1,902 @@
+package x
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gagliardetto/feparser"
+	"github.com/gagliardetto/golang-go/cmd/go/not-internal/search"
+	. "github.com/gagliardetto/utilz"
+)
+
+// The codeql importer reads hand-written codeql models (.qll files),
+// and converts the classes it understands into models of a spec
+// (see CodeQLImporter.Kinds for the kind of each class):
+// - for the kinds whose methods select flows (e.g. TaintTracking), each `inp`/`outp`
+// pair becomes a flow block; the pairs are read from the characteristic predicate,
+// or from the `hasTaintFlow` predicate;
+// - for the other kinds (e.g. UntrustedFlowSource), the outputs of funcs,
+// the read fields of structs, and the types become selectors.
+// The importer does not understand codeql: it only recognizes the
+// `hasQualifiedName(...)`, `implements(...)`, `isReceiver()`, `isParameter(...)`,
+// `isResult(...)`, `getResult(...)`, `getArgument(...)` patterns (and the
+// variables bound to string literals) inside the characteristic predicate
+// of the class; everything else is reported as a warning.
+
+// CodeQLImporter converts codeql models into an XSpec.
+type CodeQLImporter struct {
+	// Versions is the version to use for each package path;
+	// if a path is not in the map, the latest version is loaded
+	// (for standard library packages, the local version is used).
+	Versions map[string]string
+	Loader   PackageLoader
+	// Kinds is the kind of the models imported from the classes
+	// that extend each codeql class (e.g. "TaintTracking::FunctionModel").
+	Kinds map[string]ModelKind
+
+	Warnings []string
+}
+
+func NewCodeQLImporter(loader PackageLoader, versions map[string]string, kinds map[string]ModelKind) *CodeQLImporter {
+	if versions == nil {
+		versions = make(map[string]string)
+	}
+	return &CodeQLImporter{
+		Versions: versions,
+		Loader:   loader,
+		Kinds:    kinds,
+		Warnings: make([]string, 0),
+	}
+}
+
+func (imp *CodeQLImporter) warnf(format string, a ...interface{}) {
+	imp.Warnings = append(imp.Warnings, fmt.Sprintf(format, a...))
+}
+
+var (
+	rxCqlClassHeader   = regexp.MustCompile(`class\s+(\w+)\s+extends\s+([^{]+)\{`)
+	rxCqlPathPredicate = regexp.MustCompile(`string\s+(\w+)\s*\(\s*\)\s*\{\s*result\s*=\s*(?:package\s*\(\s*)?"([^"]+)"`)
+	rxCqlPackageCall   = regexp.MustCompile(`package\s*\(\s*"([^"]+)"\s*,\s*"([^"]*)"\s*\)`)
+	rxCqlBindEq        = regexp.MustCompile(`^(\w+)\s*=\s*("[^"]*"|\[[^\]]*\])$`)
+	rxCqlBindEqRev     = regexp.MustCompile(`^("[^"]*")\s*=\s*(\w+)$`)
+	rxCqlBindIn        = regexp.MustCompile(`^(\w+)\s+in\s+(\[[^\]]*\])$`)
+	rxCqlQualifiedName = regexp.MustCompile(`\b(hasQualifiedName|implements)\s*\(`)
+	rxCqlPosCall       = regexp.MustCompile(`\b(\w+)\s*\.\s*(isReceiver|isParameter|isResult)\s*\(`)
+	rxCqlNodeCall      = regexp.MustCompile(`\.\s*(getReceiver|getArgument|getResult)\s*\(`)
+	rxCqlAnyFrom       = regexp.MustCompile(`^any\s*\(\s*int\s+(\w+)\s*\|\s*(\w+)\s*>=\s*(\d+)\s*\)$`)
+	rxCqlStringLit     = regexp.MustCompile(`"([^"]*)"`)
+)
+
+// ImportFile adds to the spec the models found in the provided codeql source.
+func (imp *CodeQLImporter) ImportFile(spec *XSpec, filename string, src string) error {
+	src = stripCqlComments(src)
+	src = resolveCqlPathPredicates(src)
+
+	for _, loc := range rxCqlClassHeader.FindAllStringSubmatchIndex(src, -1) {
+		className := src[loc[2]:loc[3]]
+		extends := src[loc[4]:loc[5]]
+		openIndex := loc[1] - 1
+		closeIndex := matchCqlDelim(src, openIndex)
+		if closeIndex < 0 {
+			return fmt.Errorf("%s: class %s: unbalanced braces", filename, className)
+		}
+		body := src[openIndex+1 : closeIndex]
+
+		kind := imp.kindOf(extends)
+		if kind == "" {
+			continue
+		}
+		if !IsValidModelKind(kind) {
+			imp.warnf("%s: class %s: model kind %q not registered", filename, className, kind)
+			continue
+		}
+
+		mdl, err := imp.importClass(filename, className, kind, body)
+		if err != nil {
+			return err
+		}
+		if mdl == nil {
+			continue
+		}
+		mdl.Name = uniqueModelName(spec, ToCamel(className))
+		spec.Models = append(spec.Models, mdl)
+	}
+	return nil
+}
+
+// kindOf returns the kind of the models imported from
+// a class with the provided supertypes, or an empty string.
+func (imp *CodeQLImporter) kindOf(extends string) ModelKind {
+	superClasses := make([]string, 0, len(imp.Kinds))
+	for superClass := range imp.Kinds {
+		superClasses = append(superClasses, superClass)
+	}
+	sort.Strings(superClasses)
+	for _, superClass := range superClasses {
+		for _, super := range splitCqlTop(extends, ",") {
+			if strings.TrimSpace(super) == superClass {
+				return imp.Kinds[superClass]
+			}
+		}
+	}
+	return ""
+}
+
+func uniqueModelName(spec *XSpec, name string) string {
+	if !spec.HasModelName(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := Sf("%s%v", name, i)
+		if !spec.HasModelName(candidate) {
+			return candidate
+		}
+	}
+}
+
+// importedElement is an element recognized in a conjunction
+// of the characteristic predicate of a class.
+type importedElement struct {
+	Kind    SelectorKind
+	Path    string
+	ID      string
+	Fields  []string
+	Inp     []int // Flow inputs (TaintTracking).
+	Out     []int // Flow outputs (TaintTracking), or outputs (UntrustedFlowSource).
+	inpRefs []cqlPosRef
+	outRefs []cqlPosRef
+}
+
+func (imp *CodeQLImporter) importClass(filename string, className string, kind ModelKind, body string) (*XModel, error) {
+	charpredRx := regexp.MustCompile(`\b` + regexp.QuoteMeta(className) + `\s*\(\s*\)\s*\{`)
+	loc := charpredRx.FindStringIndex(body)
+	if loc == nil {
+		imp.warnf("%s: class %s: characteristic predicate not found", filename, className)
+		return nil, nil
+	}
+	closeIndex := matchCqlDelim(body, loc[1]-1)
+	if closeIndex < 0 {
+		return nil, fmt.Errorf("%s: class %s: unbalanced braces", filename, className)
+	}
+	charpred := body[loc[1]:closeIndex]
+
+	inputVars := findCqlVarsOfType(body, "FunctionInput")
+	outputVars := findCqlVarsOfType(body, "FunctionOutput")
+	fieldVars := findCqlVarsOfType(body, "Field")
+
+	conjunctions, err := cqlToDNF(parseCqlExpr(charpred), 10000)
+	if err != nil {
+		imp.warnf("%s: class %s: %s", filename, className, err)
+		return nil, nil
+	}
+
+	mdl := &XModel{
+		Kind:    kind,
+		Methods: NewScavengeMethods(kind),
+	}
+	self := mdl.Methods[0]
+	flowMode := selectsFlows(kind, self.Name)
+
+	var taintFlows []*importedFlow
+	if flowMode {
+		taintFlows = imp.taintFlowsOf(filename, className, body, inputVars, outputVars)
+	}
+
+	for _, atoms := range conjunctions {
+		elements, err := imp.elementsFromConjunction(atoms, inputVars, outputVars, fieldVars)
+		if err != nil {
+			imp.warnf("%s: class %s: %s", filename, className, err)
+			continue
+		}
+		for _, elem := range elements {
+			if flowMode && elem.Kind == SelectorKindFunc && len(elem.inpRefs) == 0 && len(elem.outRefs) == 0 && len(taintFlows) > 0 {
+				// The flows are declared in hasTaintFlow:
+				for _, flow := range taintFlows {
+					flowElem := *elem
+					flowElem.inpRefs = flow.inpRefs
+					flowElem.outRefs = flow.outRefs
+					if err := imp.addElement(flowMode, self, &flowElem); err != nil {
+						imp.warnf("%s: class %s: %s", filename, className, err)
+					}
+				}
+				continue
+			}
+			if err := imp.addElement(flowMode, self, elem); err != nil {
+				imp.warnf("%s: class %s: %s", filename, className, err)
+			}
+		}
+	}
+
+	if len(self.Selectors) == 0 {
+		imp.warnf("%s: class %s: no selectors recognized", filename, className)
+		return nil, nil
+	}
+	return mdl, nil
+}
+
+// elementsFromConjunction returns the elements selected by a conjunction of atoms.
+func (imp *CodeQLImporter) elementsFromConjunction(atoms []string, inputVars []string, outputVars []string, fieldVars []string) ([]*importedElement, error) {
+	bindings := make(map[string][]string)
+	for _, atom := range atoms {
+		if m := rxCqlBindEq.FindStringSubmatch(atom); m != nil {
+			bindings[m[1]] = parseCqlStrings(m[2])
+		} else if m := rxCqlBindEqRev.FindStringSubmatch(atom); m != nil {
+			bindings[m[2]] = parseCqlStrings(m[1])
+		} else if m := rxCqlBindIn.FindStringSubmatch(atom); m != nil {
+			bindings[m[1]] = parseCqlStrings(m[2])
+		}
+	}
+	resolve := func(arg string) ([]string, error) {
+		arg = strings.TrimSpace(arg)
+		if strings.HasPrefix(arg, `"`) || strings.HasPrefix(arg, "[") {
+			return parseCqlStrings(arg), nil
+		}
+		if values, ok := bindings[arg]; ok {
+			return values, nil
+		}
+		return nil, fmt.Errorf("cannot resolve %q to a string", arg)
+	}
+
+	var inpRefs, outRefs []cqlPosRef
+	for _, atom := range atoms {
+		atomInpRefs, atomOutRefs, err := parseCqlPosCalls(atom, inputVars, outputVars)
+		if err != nil {
+			return nil, err
+		}
+		inpRefs = append(inpRefs, atomInpRefs...)
+		outRefs = append(outRefs, atomOutRefs...)
+		for _, loc := range rxCqlNodeCall.FindAllStringSubmatchIndex(atom, -1) {
+			name := atom[loc[2]:loc[3]]
+			role := map[string]string{"getReceiver": "isReceiver", "getArgument": "isParameter", "getResult": "isResult"}[name]
+			ref, err := parseCqlPosCall(role, atom, loc[1]-1)
+			if err != nil {
+				return nil, err
+			}
+			outRefs = append(outRefs, ref)
+		}
+	}
+
+	elements := make([]*importedElement, 0)
+	for _, atom := range atoms {
+		for _, loc := range rxCqlQualifiedName.FindAllStringSubmatchIndex(atom, -1) {
+			callName := atom[loc[2]:loc[3]]
+			closeIndex := matchCqlDelim(atom, loc[1]-1)
+			if closeIndex < 0 {
+				return nil, fmt.Errorf("unbalanced parens in %q", atom)
+			}
+			rawArgs := splitCqlTop(atom[loc[1]:closeIndex], ",")
+			args := make([][]string, 0, len(rawArgs))
+			for _, rawArg := range rawArgs {
+				values, err := resolve(rawArg)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", strings.TrimSpace(atom), err)
+				}
+				args = append(args, values)
+			}
+			if len(args) < 2 || len(args[0]) != 1 {
+				return nil, fmt.Errorf("%s: unexpected arguments", strings.TrimSpace(atom))
+			}
+			path := args[0][0]
+			receiver := strings.TrimSpace(atom[:loc[0]])
+
+			switch {
+			case strings.HasSuffix(receiver, "getType().") || strings.HasSuffix(receiver, "getType() ."):
+				// Type:
+				for _, typeName := range args[1] {
+					elements = append(elements, &importedElement{
+						Kind: SelectorKindType,
+						Path: path,
+						ID:   feparser.FormatID("Type", typeName),
+					})
+				}
+			case len(args) == 3 && isCqlFieldReceiver(receiver, fieldVars):
+				// Struct fields:
+				for _, structName := range args[1] {
+					elements = append(elements, &importedElement{
+						Kind:   SelectorKindStruct,
+						Path:   path,
+						ID:     feparser.FormatID("Struct", structName),
+						Fields: args[2],
+					})
+				}
+			case len(args) == 2:
+				// Functions:
+				for _, funcName := range args[1] {
+					elements = append(elements, &importedElement{
+						Kind:    SelectorKindFunc,
+						Path:    path,
+						ID:      feparser.FormatID("Function", funcName),
+						inpRefs: inpRefs,
+						outRefs: outRefs,
+					})
+				}
+			case len(args) == 3:
+				// Methods:
+				idKind := "TypeMethod"
+				if callName == "implements" {
+					idKind = "InterfaceMethod"
+				}
+				for _, typeName := range args[1] {
+					for _, methodName := range args[2] {
+						elements = append(elements, &importedElement{
+							Kind:    SelectorKindFunc,
+							Path:    path,
+							ID:      feparser.FormatID(idKind, typeName, methodName),
+							inpRefs: inpRefs,
+							outRefs: outRefs,
+						})
+					}
+				}
+			default:
+				return nil, fmt.Errorf("%s: unexpected number of arguments", strings.TrimSpace(atom))
+			}
+		}
+	}
+	return elements, nil
+}
+
+// parseCqlPosCalls returns the refs of the isReceiver/isParameter/isResult
+// calls of the atom on the provided input and output variables.
+func parseCqlPosCalls(atom string, inputVars []string, outputVars []string) (inpRefs []cqlPosRef, outRefs []cqlPosRef, err error) {
+	for _, loc := range rxCqlPosCall.FindAllStringSubmatchIndex(atom, -1) {
+		varName := atom[loc[2]:loc[3]]
+		ref, err := parseCqlPosCall(atom[loc[4]:loc[5]], atom, loc[1]-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case containsExact(inputVars, varName):
+			inpRefs = append(inpRefs, ref)
+		case containsExact(outputVars, varName):
+			outRefs = append(outRefs, ref)
+		}
+	}
+	return inpRefs, outRefs, nil
+}
+
+// importedFlow is a flow declared in the hasTaintFlow predicate of a class.
+type importedFlow struct {
+	inpRefs []cqlPosRef
+	outRefs []cqlPosRef
+}
+
+var rxCqlHasTaintFlow = regexp.MustCompile(`\bpredicate\s+hasTaintFlow\s*\([^)]*\)\s*\{`)
+
+// taintFlowsOf returns the flows declared in the hasTaintFlow predicate of the class
+// (one per disjunct); a predicate that only binds the fields of the class
+// (e.g. `input = inp and output = outp`) declares no flows.
+func (imp *CodeQLImporter) taintFlowsOf(filename string, className string, body string, inputVars []string, outputVars []string) []*importedFlow {
+	loc := rxCqlHasTaintFlow.FindStringIndex(body)
+	if loc == nil {
+		return nil
+	}
+	closeIndex := matchCqlDelim(body, loc[1]-1)
+	if closeIndex < 0 {
+		imp.warnf("%s: class %s: hasTaintFlow: unbalanced braces", filename, className)
+		return nil
+	}
+	conjunctions, err := cqlToDNF(parseCqlExpr(body[loc[1]:closeIndex]), 10000)
+	if err != nil {
+		imp.warnf("%s: class %s: hasTaintFlow: %s", filename, className, err)
+		return nil
+	}
+
+	flows := make([]*importedFlow, 0)
+	for _, atoms := range conjunctions {
+		flow := &importedFlow{}
+		for _, atom := range atoms {
+			if rxCqlQualifiedName.MatchString(atom) {
+				imp.warnf("%s: class %s: hasTaintFlow: flows that depend on the func are not supported: %s", filename, className, strings.TrimSpace(atom))
+				return nil
+			}
+			inpRefs, outRefs, err := parseCqlPosCalls(atom, inputVars, outputVars)
+			if err != nil {
+				imp.warnf("%s: class %s: hasTaintFlow: %s", filename, className, err)
+				return nil
+			}
+			flow.inpRefs = append(flow.inpRefs, inpRefs...)
+			flow.outRefs = append(flow.outRefs, outRefs...)
+		}
+		if len(flow.inpRefs) == 0 && len(flow.outRefs) == 0 {
+			// Only bindings (the flows are in the characteristic predicate).
+			continue
+		}
+		if len(flow.inpRefs) == 0 || len(flow.outRefs) == 0 {
+			imp.warnf("%s: class %s: hasTaintFlow: flow without input or output: %s", filename, className, strings.Join(atoms, " and "))
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+// selectsFlows returns true if the method of the
+// provided kind selects flows (see SelectionModeFlow).
+func selectsFlows(kind ModelKind, methodName string) bool {
+	caps, err := Router().GetMethodCapabilities(kind, methodName)
+	return err == nil && caps.Mode == SelectionModeFlow
+}
+
+func isCqlFieldReceiver(receiver string, fieldVars []string) bool {
+	if strings.HasSuffix(receiver, "getField().") {
+		return true
+	}
+	for _, v := range fieldVars {
+		if strings.HasSuffix(receiver, v+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// addElement adds the element to the method,
+// merging it with the existing selector of the same element (if any).
+func (imp *CodeQLImporter) addElement(flowMode bool, mtd *XMethod, elem *importedElement) error {
+	version, err := imp.versionOf(elem.Path)
+	if err != nil {
+		return err
+	}
+	source, err := imp.Loader(elem.Path, version)
+	if err != nil {
+		return fmt.Errorf("error while loading package %s: %s", FormatPathVersion(elem.Path, version), err)
+	}
+	basicQual := BasicQualifier{
+		Path:    elem.Path,
+		Version: version,
+		ID:      elem.ID,
+	}
+
+	switch elem.Kind {
+	case SelectorKindType:
+		typ := FindTypeByID(source, elem.ID)
+		if typ == nil {
+			return fmt.Errorf("type %q not found in %s", elem.ID, basicQual.PathVersion())
+		}
+		if mtd.GetTypeSelector(basicQual.Path, basicQual.Version, basicQual.ID) == nil {
+			mtd.Selectors = append(mtd.Selectors, &XSelector{
+				Kind: SelectorKindType,
+				Qualifier: &TypeQualifier{
+					BasicQualifier: basicQual,
+					TypeName:       typ.TypeName,
+					KindString:     typ.KindString,
+					Value:          true,
+				},
+			})
+		}
+		return nil
+	case SelectorKindStruct:
+		st := FindStructByID(source, elem.ID)
+		if st == nil {
+			return fmt.Errorf("struct %q not found in %s", elem.ID, basicQual.PathVersion())
+		}
+		qual := mtd.GetStructSelector(basicQual.Path, basicQual.Version, basicQual.ID)
+		if qual == nil {
+			qual = &StructQualifier{
+				BasicQualifier: basicQual,
+				TypeName:       st.TypeName,
+				Fields:         make(map[string]*FieldMeta),
+			}
+			mtd.Selectors = append(mtd.Selectors, &XSelector{Kind: SelectorKindStruct, Qualifier: qual})
+		}
+		for _, fieldName := range elem.Fields {
+			if FindFieldByName(st, fieldName) == nil {
+				imp.warnf("field %q not found in struct %q of %s", fieldName, elem.ID, basicQual.PathVersion())
+				continue
+			}
+			qual.Fields[fieldName] = nil
+		}
+		return nil
+	}
+
+	fn := FindFuncByID(source, elem.ID)
+	if fn == nil && strings.HasPrefix(elem.ID, "TypeMethod-") {
+		// hasQualifiedName is used also for interface methods:
+		elem.ID = "InterfaceMethod-" + strings.TrimPrefix(elem.ID, "TypeMethod-")
+		basicQual.ID = elem.ID
+		fn = FindFuncByID(source, elem.ID)
+	}
+	if fn == nil {
+		return fmt.Errorf("func %q not found in %s", elem.ID, basicQual.PathVersion())
+	}
+	inp, err := cqlPosRefsToPositions(fn, elem.inpRefs)
+	if err != nil {
+		return fmt.Errorf("func %q: %s", elem.ID, err)
+	}
+	out, err := cqlPosRefsToPositions(fn, elem.outRefs)
+	if err != nil {
+		return fmt.Errorf("func %q: %s", elem.ID, err)
+	}
+
+	if flowMode && (AllFalse(inp...) || AllFalse(out...)) {
+		return fmt.Errorf("func %q: flow without input or output", elem.ID)
+	}
+	if !flowMode && AllFalse(out...) {
+		return fmt.Errorf("func %q: no output", elem.ID)
+	}
+
+	qual := mtd.GetFuncSelector(basicQual.Path, basicQual.Version, basicQual.ID)
+	if qual == nil {
+		qual = &FuncQualifier{
+			BasicQualifier: basicQual,
+			Name:           GetFuncName(fn),
+		}
+		mtd.Selectors = append(mtd.Selectors, &XSelector{Kind: SelectorKindFunc, Qualifier: qual})
+	}
+
+	if flowMode {
+		if qual.Flows == nil {
+			qual.Flows = &FlowSpec{
+				Enabled: true,
+				Blocks:  make([]*FlowBlock, 0),
+			}
+		}
+		qual.Flows.Blocks = append(qual.Flows.Blocks, &FlowBlock{Inp: inp, Out: out})
+		return nil
+	}
+
+	if qual.Pos == nil {
+		qual.Pos = make([]bool, fn.Len())
+	}
+	for index, ok := range out {
+		if ok {
+			qual.Pos[index] = true
+		}
+	}
+	return nil
+}
+
+func (imp *CodeQLImporter) versionOf(path string) (string, error) {
+	if version, ok := imp.Versions[path]; ok {
+		return version, nil
+	}
+	if search.IsStandardImportPath(path) {
+		imp.Versions[path] = "local"
+		return "local", nil
+	}
+	// Use the latest version:
+	pkg, err := imp.Loader(path, "")
+	if err != nil {
+		return "", fmt.Errorf("error while loading package %s: %s", path, err)
+	}
+	if pkg.Module == nil || pkg.Module.Version == "" {
+		return "", fmt.Errorf("version of %s is not known; please specify it", path)
+	}
+	imp.Versions[path] = pkg.Module.Version
+	return pkg.Module.Version, nil
+}
+
+// cqlPosRef is an element referenced by
+// isReceiver/isParameter/isResult (or getReceiver/getArgument/getResult).
+type cqlPosRef struct {
+	Role string // isReceiver, isParameter, isResult.
+	All  bool   // `_`
+	From int    // any(int i | i >= From)
+	Idx  []int  // Empty if no argument (e.g. isResult()).
+}
+
+func parseCqlPosCall(role string, text string, openIndex int) (cqlPosRef, error) {
+	closeIndex := matchCqlDelim(text, openIndex)
+	if closeIndex < 0 {
+		return cqlPosRef{}, fmt.Errorf("unbalanced parens in %q", text)
+	}
+	arg := strings.TrimSpace(text[openIndex+1 : closeIndex])
+	ref := cqlPosRef{Role: role, From: -1}
+	switch {
+	case arg == "":
+	case arg == "_":
+		ref.All = true
+	case rxCqlAnyFrom.MatchString(arg):
+		from, _ := strconv.Atoi(rxCqlAnyFrom.FindStringSubmatch(arg)[3])
+		ref.From = from
+	default:
+		for _, part := range splitCqlTop(strings.Trim(arg, "[]"), ",") {
+			part = strings.TrimSpace(part)
+			if m := rxCqlAnyFrom.FindStringSubmatch(part); m != nil {
+				ref.From, _ = strconv.Atoi(m[3])
+				continue
+			}
+			index, err := strconv.Atoi(part)
+			if err != nil {
+				return cqlPosRef{}, fmt.Errorf("%s(%s): index not supported", role, arg)
+			}
+			ref.Idx = append(ref.Idx, index)
+		}
+	}
+	return ref, nil
+}
+
+// cqlPosRefsToPositions converts the refs to the absolute positions of the func.
+func cqlPosRefsToPositions(fn FuncInterface, refs []cqlPosRef) ([]bool, error) {
+	lenReceiver, lenParams, lenResults := fn.Lengths()
+	positions := make([]bool, fn.Len())
+	set := func(offset int, length int, ref cqlPosRef) error {
+		if ref.All {
+			for i := 0; i < length; i++ {
+				positions[offset+i] = true
+			}
+		}
+		if ref.From >= 0 {
+			for i := ref.From; i < length; i++ {
+				positions[offset+i] = true
+			}
+		}
+		for _, index := range ref.Idx {
+			if index >= length {
+				return fmt.Errorf("%s(%v) out of bounds", ref.Role, index)
+			}
+			positions[offset+index] = true
+		}
+		return nil
+	}
+	for _, ref := range refs {
+		switch ref.Role {
+		case "isReceiver":
+			if lenReceiver == 0 {
+				return nil, errors.New("func has no receiver")
+			}
+			positions[0] = true
+		case "isParameter":
+			if err := set(lenReceiver, lenParams, ref); err != nil {
+				return nil, err
+			}
+		case "isResult":
+			if !ref.All && ref.From < 0 && len(ref.Idx) == 0 {
+				// isResult() is the only result:
+				ref.Idx = []int{0}
+			}
+			if err := set(lenReceiver+lenParams, lenResults, ref); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return positions, nil
+}
+
+func findCqlVarsOfType(text string, typeName string) []string {
+	rx := regexp.MustCompile(`(?:\w+::)?\b` + typeName + `\s+(\w+)`)
+	vars := make([]string, 0)
+	for _, m := range rx.FindAllStringSubmatch(text, -1) {
+		if !containsExact(vars, m[1]) {
+			vars = append(vars, m[1])
+		}
+	}
+	return vars
+}
+
+func parseCqlStrings(text string) []string {
+	res := make([]string, 0)
+	for _, m := range rxCqlStringLit.FindAllStringSubmatch(text, -1) {
+		res = append(res, m[1])
+	}
+	return res
+}
+
+// stripCqlComments removes line and block comments.
+func stripCqlComments(src string) string {
+	var buf strings.Builder
+	inString := false
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if inString {
+			buf.WriteByte(c)
+			if c == '\\' && i+1 < len(src) {
+				i++
+				buf.WriteByte(src[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			buf.WriteByte(c)
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			buf.WriteByte('\n')
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return buf.String()
+			}
+			i += 2 + end + 1
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// resolveCqlPathPredicates replaces the calls to predicates that return
+// a package path (e.g. `packagePath()`), and the calls to `package(mod, path)`,
+// with the path string literal.
+func resolveCqlPathPredicates(src string) string {
+	for _, m := range rxCqlPathPredicate.FindAllStringSubmatch(src, -1) {
+		rx := regexp.MustCompile(`\b` + regexp.QuoteMeta(m[1]) + `\s*\(\s*\)`)
+		src = rx.ReplaceAllStringFunc(src, func(s string) string {
+			return strconv.Quote(m[2])
+		})
+	}
+	return rxCqlPackageCall.ReplaceAllStringFunc(src, func(s string) string {
+		m := rxCqlPackageCall.FindStringSubmatch(s)
+		if m[2] == "" {
+			return strconv.Quote(m[1])
+		}
+		return strconv.Quote(m[1] + "/" + m[2])
+	})
+}
+
+// matchCqlDelim returns the index of the delimiter that closes the one
+// at the provided index, or -1.
+func matchCqlDelim(text string, openIndex int) int {
+	depth := 0
+	inString := false
+	for i := openIndex; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitCqlTop splits the text at the separator (a keyword like `or`,
+// or a symbol like `,`), ignoring the separators nested in delimiters or strings.
+func splitCqlTop(text string, sep string) []string {
+	isWord := regexp.MustCompile(`^\w+$`).MatchString(sep)
+	parts := make([]string, 0)
+	depth := 0
+	inString := false
+	last := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+			continue
+		case '(', '[', '{':
+			depth++
+			continue
+		case ')', ']', '}':
+			depth--
+			continue
+		}
+		if depth != 0 || !strings.HasPrefix(text[i:], sep) {
+			continue
+		}
+		if isWord {
+			before := i == 0 || !isCqlWordChar(text[i-1])
+			after := i+len(sep) >= len(text) || !isCqlWordChar(text[i+len(sep)])
+			if !before || !after {
+				continue
+			}
+		}
+		parts = append(parts, text[last:i])
+		last = i + len(sep)
+		i = last - 1
+	}
+	return append(parts, text[last:])
+}
+
+func isCqlWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// cqlExpr is a boolean expression: either an atom, or a conjunction (And),
+// or a disjunction (Or) of expressions.
+type cqlExpr struct {
+	Atom     string
+	And      bool
+	Children []*cqlExpr
+}
+
+func parseCqlExpr(text string) *cqlExpr {
+	text = strings.TrimSpace(text)
+
+	if parts := splitCqlTop(text, "or"); len(parts) > 1 {
+		expr := &cqlExpr{}
+		for _, part := range parts {
+			expr.Children = append(expr.Children, parseCqlExpr(part))
+		}
+		return expr
+	}
+	if parts := splitCqlTop(text, "and"); len(parts) > 1 {
+		expr := &cqlExpr{And: true}
+		for _, part := range parts {
+			expr.Children = append(expr.Children, parseCqlExpr(part))
+		}
+		return expr
+	}
+	if strings.HasPrefix(text, "(") && matchCqlDelim(text, 0) == len(text)-1 {
+		return parseCqlExpr(text[1 : len(text)-1])
+	}
+	if strings.HasPrefix(text, "exists") {
+		openIndex := strings.Index(text, "(")
+		if matchCqlDelim(text, openIndex) == len(text)-1 {
+			// exists(declarations | range | body) is the conjunction
+			// of the declarations (kept as an atom), the range and the body:
+			sections := splitCqlTop(text[openIndex+1:len(text)-1], "|")
+			expr := &cqlExpr{And: true}
+			expr.Children = append(expr.Children, &cqlExpr{Atom: strings.TrimSpace(sections[0])})
+			for _, section := range sections[1:] {
+				expr.Children = append(expr.Children, parseCqlExpr(section))
+			}
+			return expr
+		}
+	}
+	return &cqlExpr{Atom: text}
+}
+
+// cqlToDNF converts the expression into a disjunction of
+// conjunctions of atoms.
+func cqlToDNF(expr *cqlExpr, limit int) ([][]string, error) {
+	if expr.Children == nil {
+		if expr.Atom == "" {
+			return [][]string{{}}, nil
+		}
+		return [][]string{{expr.Atom}}, nil
+	}
+	if !expr.And {
+		res := make([][]string, 0)
+		for _, child := range expr.Children {
+			sub, err := cqlToDNF(child, limit)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, sub...)
+		}
+		return res, nil
+	}
+	res := [][]string{{}}
+	for _, child := range expr.Children {
+		sub, err := cqlToDNF(child, limit)
+		if err != nil {
+			return nil, err
+		}
+		product := make([][]string, 0, len(res)*len(sub))
+		for _, a := range res {
+			for _, b := range sub {
+				conj := make([]string, 0, len(a)+len(b))
+				conj = append(conj, a...)
+				conj = append(conj, b...)
+				product = append(product, conj)
+			}
+		}
+		if len(product) > limit {
+			return nil, errors.New("characteristic predicate too complex to import")
+		}
+		res = product
+	}
+	return res, nil
+}
@@ -0,0 +1,289 @@
+package x_test
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gagliardetto/codemill/handlers/tainttracking"
+	"github.com/gagliardetto/codemill/handlers/untrustedflowsource"
+	"github.com/gagliardetto/codemill/x"
+	"github.com/gagliardetto/feparser"
+)
+
+var registerTestHandlersOnce sync.Once
+
+func registerTestHandlers(t *testing.T) {
+	registerTestHandlersOnce.Do(func() {
+		rt := x.Router()
+		if err := rt.RegisterHandler(tainttracking.Kind, &tainttracking.Handler{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.RegisterHandler(untrustedflowsource.Kind, &untrustedflowsource.Handler{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+const testPkgPath = "example.com/lib"
+
+// newTestPackage returns a package with the funcs Unescape(in, out []byte) ([]byte, error)
+// and Read() (string, error), the method (*Decoder).Decode(v interface{}) error,
+// the struct Request (with the fields Body and Header), and the type Token.
+func newTestPackage() *feparser.FEPackage {
+	unescape := &feparser.FEFunc{
+		ID:         feparser.FormatID("Function", "Unescape"),
+		Name:       "Unescape",
+		Signature:  "func Unescape(in []byte, out []byte) ([]byte, error)",
+		PkgPath:    testPkgPath,
+		Parameters: []*feparser.FEType{{VarName: "in"}, {VarName: "out"}},
+		Results:    []*feparser.FEType{{}, {}},
+	}
+	read := &feparser.FEFunc{
+		ID:      feparser.FormatID("Function", "Read"),
+		Name:    "Read",
+		PkgPath: testPkgPath,
+		Results: []*feparser.FEType{{}, {}},
+	}
+	decode := &feparser.FETypeMethod{
+		ID:       feparser.FormatID("TypeMethod", "Decoder", "Decode"),
+		Receiver: &feparser.FEReceiver{FEType: feparser.FEType{TypeName: "Decoder", VarName: "dec"}},
+		Func: &feparser.FEFunc{
+			Name:       "Decode",
+			Parameters: []*feparser.FEType{{VarName: "v"}},
+			Results:    []*feparser.FEType{{}},
+		},
+	}
+	request := &feparser.FEStruct{
+		FEType: &feparser.FEType{TypeName: "Request"},
+		ID:     feparser.FormatID("Struct", "Request"),
+		Fields: []*feparser.FEField{
+			{FEType: &feparser.FEType{VarName: "Body"}},
+			{FEType: &feparser.FEType{VarName: "Header"}},
+		},
+	}
+	token := &feparser.FEType{
+		ID:       feparser.FormatID("Type", "Token"),
+		TypeName: "Token",
+	}
+	return &feparser.FEPackage{
+		PkgPath:     testPkgPath,
+		Funcs:       []*feparser.FEFunc{unescape, read},
+		TypeMethods: []*feparser.FETypeMethod{decode},
+		Structs:     []*feparser.FEStruct{request},
+		Types:       []*feparser.FEType{token},
+	}
+}
+
+// summarizeModel returns one line per selector of the model.
+func summarizeModel(mdl *x.XModel, pkg *feparser.FEPackage) []string {
+	lines := make([]string, 0)
+	for _, mt := range mdl.Methods {
+		for _, sel := range mt.Selectors {
+			switch qual := sel.Qualifier.(type) {
+			case *x.FuncQualifier:
+				fn := x.FindFuncByID(pkg, qual.ID)
+				if qual.Flows != nil {
+					for _, block := range qual.Flows.Blocks {
+						lines = append(lines, fmt.Sprintf(
+							"%s: %s %v -> %v",
+							mt.Name,
+							qual.ID,
+							x.FormatElementRefs(fn, block.Inp),
+							x.FormatElementRefs(fn, block.Out),
+						))
+					}
+				} else {
+					lines = append(lines, fmt.Sprintf("%s: %s %v", mt.Name, qual.ID, x.FormatElementRefs(fn, qual.Pos)))
+				}
+			case *x.StructQualifier:
+				fields := make([]string, 0, len(qual.Fields))
+				for name := range qual.Fields {
+					fields = append(fields, name)
+				}
+				sort.Strings(fields)
+				lines = append(lines, fmt.Sprintf("%s: %s %v", mt.Name, qual.ID, fields))
+			case *x.TypeQualifier:
+				lines = append(lines, fmt.Sprintf("%s: %s", mt.Name, qual.ID))
+			}
+		}
+	}
+	return lines
+}
+
+func TestCodeQLImporter(t *testing.T) {
+	registerTestHandlers(t)
+
+	tests := []struct {
+		name     string
+		src      string
+		kind     x.ModelKind
+		selected []string
+		warnings []string // Substrings of the expected warnings.
+	}{
+		{
+			name: "flows in the characteristic predicate",
+			src: `
+import go
+
+private class UnescapeModel extends TaintTracking::FunctionModel {
+  FunctionInput inp;
+  FunctionOutput outp;
+
+  UnescapeModel() {
+    // Unescape(in, out)
+    hasQualifiedName("example.com/lib", "Unescape") and
+    (inp.isParameter(0) and outp.isResult(0))
+    or
+    this.(Method).hasQualifiedName("example.com/lib", "Decoder", "Decode") and
+    (inp.isReceiver() and outp.isParameter(0))
+  }
+
+  override predicate hasTaintFlow(FunctionInput input, FunctionOutput output) {
+    input = inp and output = outp
+  }
+}
+`,
+			kind: tainttracking.Kind,
+			selected: []string{
+				"Self: Function-Unescape [param:in] -> [result:0]",
+				"Self: TypeMethod-Decoder-Decode [receiver] -> [param:v]",
+			},
+		},
+		{
+			name: "flows in hasTaintFlow",
+			src: `
+class Unescape extends TaintTracking::FunctionModel {
+  Unescape() { this.hasQualifiedName(packagePath(), "Unescape") }
+
+  override predicate hasTaintFlow(FunctionInput inp, FunctionOutput outp) {
+    inp.isParameter(0) and outp.isResult(0)
+    or
+    inp.isParameter(1) and outp.isResult(0)
+  }
+}
+
+string packagePath() { result = package("example.com/lib", "") }
+`,
+			kind: tainttracking.Kind,
+			selected: []string{
+				"Self: Function-Unescape [param:in] -> [result:0]",
+				"Self: Function-Unescape [param:out] -> [result:0]",
+			},
+		},
+		{
+			name: "hasTaintFlow that depends on the func",
+			src: `
+class Unescape extends TaintTracking::FunctionModel {
+  Unescape() { hasQualifiedName("example.com/lib", ["Unescape", "Read"]) }
+
+  override predicate hasTaintFlow(FunctionInput inp, FunctionOutput outp) {
+    this.hasQualifiedName("example.com/lib", "Unescape") and inp.isParameter(0) and outp.isResult(0)
+  }
+}
+`,
+			kind: tainttracking.Kind,
+			warnings: []string{
+				"hasTaintFlow: flows that depend on the func are not supported",
+				`func "Function-Unescape": flow without input or output`,
+				`func "Function-Read": flow without input or output`,
+				"no selectors recognized",
+			},
+		},
+		{
+			name: "untrusted flow sources",
+			src: `
+class LibSource extends UntrustedFlowSource::Range {
+  LibSource() {
+    exists(Field f, string fieldName |
+      f.hasQualifiedName("example.com/lib", "Request", fieldName) and
+      this = f.getARead() and
+      fieldName in ["Body", "Header", "Missing"]
+    )
+    or
+    exists(DataFlow::CallNode call |
+      call.getTarget().hasQualifiedName("example.com/lib", "Read") and
+      this = call.getResult(0)
+    )
+    or
+    exists(Type typ | typ.hasQualifiedName("example.com/lib", "Token") |
+      this.getType() = typ
+    )
+  }
+}
+`,
+			kind: untrustedflowsource.Kind,
+			selected: []string{
+				"Self: Struct-Request [Body Header]",
+				"Self: Function-Read [result:0]",
+			},
+			warnings: []string{
+				`field "Missing" not found in struct "Struct-Request"`,
+			},
+		},
+		{
+			name: "classes of other kinds are skipped",
+			src: `
+class Sanitizer extends TaintTracking::Sanitizer {
+  Sanitizer() { hasQualifiedName("example.com/lib", "Unescape") }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg := newTestPackage()
+			loader := func(path string, version string) (*feparser.FEPackage, error) {
+				if path != testPkgPath {
+					return nil, fmt.Errorf("package %s not found", path)
+				}
+				return pkg, nil
+			}
+			importer := x.NewCodeQLImporter(loader, map[string]string{testPkgPath: "v1.0.0"}, map[string]x.ModelKind{
+				"TaintTracking::FunctionModel": tainttracking.Kind,
+				"UntrustedFlowSource::Range":   untrustedflowsource.Kind,
+			})
+			spec := x.NewXSpecWithName("Test")
+			if err := importer.ImportFile(spec, "test.qll", tt.src); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tt.selected) == 0 {
+				if len(spec.Models) != 0 {
+					t.Fatalf("expected no models, got %q", summarizeModel(spec.Models[0], pkg))
+				}
+			} else {
+				if len(spec.Models) != 1 {
+					t.Fatalf("expected 1 model, got %v (warnings: %v)", len(spec.Models), importer.Warnings)
+				}
+				mdl := spec.Models[0]
+				if mdl.Kind != tt.kind {
+					t.Errorf("expected kind %s, got %s", tt.kind, mdl.Kind)
+				}
+				if got := summarizeModel(mdl, pkg); !reflect.DeepEqual(got, tt.selected) {
+					t.Errorf("selected:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(tt.selected, "\n"))
+				}
+			}
+
+			for _, expected := range tt.warnings {
+				found := false
+				for _, warning := range importer.Warnings {
+					if strings.Contains(warning, expected) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("warning %q not found in %q", expected, importer.Warnings)
+				}
+			}
+			if len(tt.warnings) == 0 && len(importer.Warnings) > 0 {
+				t.Errorf("unexpected warnings: %q", importer.Warnings)
+			}
+		})
+	}
+}
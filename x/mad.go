@@ -0,0 +1,301 @@
+package x
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// Models-as-data are the data extensions (rows of sources, sinks, and summaries)
+// consumed by newer versions of codeql, as an alternative to .qll models.
+
+// MaDExtensible is the extensible predicate a models-as-data row is added to.
+type MaDExtensible string
+
+const (
+	MaDSourceModel  MaDExtensible = "sourceModel"
+	MaDSinkModel    MaDExtensible = "sinkModel"
+	MaDSummaryModel MaDExtensible = "summaryModel"
+)
+
+// MaDPack is the codeql pack the models-as-data extend.
+const MaDPack = "codeql/go-all"
+
+// MaDProvenance is the provenance of the generated rows.
+const MaDProvenance = "manual"
+
+// MaDRow is a models-as-data row.
+type MaDRow struct {
+	Extensible MaDExtensible
+	Package    string
+	Type       string
+	Subtypes   bool
+	Name       string
+	Signature  string
+	Ext        string
+	Input      string // Only for sinks and summaries.
+	Output     string // Only for sources and summaries.
+	Kind       string
+	Provenance string
+}
+
+// Columns returns the values of the row, in the order of the columns of its extensible.
+func (row *MaDRow) Columns() []interface{} {
+	columns := []interface{}{row.Package, row.Type, row.Subtypes, row.Name, row.Signature, row.Ext}
+	switch row.Extensible {
+	case MaDSourceModel:
+		columns = append(columns, row.Output)
+	case MaDSinkModel:
+		columns = append(columns, row.Input)
+	case MaDSummaryModel:
+		columns = append(columns, row.Input, row.Output)
+	}
+	return append(columns, row.Kind, row.Provenance)
+}
+
+// MaDColumnNames returns the names of the columns of the provided extensible.
+func MaDColumnNames(extensible MaDExtensible) []string {
+	names := []string{"package", "type", "subtypes", "name", "signature", "ext"}
+	switch extensible {
+	case MaDSourceModel:
+		names = append(names, "output")
+	case MaDSinkModel:
+		names = append(names, "input")
+	case MaDSummaryModel:
+		names = append(names, "input", "output")
+	}
+	return append(names, "kind", "provenance")
+}
+
+// ModelsAsDataGenerator is implemented by the handlers whose
+// models can (also) be expressed as models-as-data rows.
+type ModelsAsDataGenerator interface {
+	// GenerateModelsAsData generates the models-as-data
+	// rows equivalent to the provided model; the selections
+	// that cannot be expressed as models-as-data are all
+	// reported in the returned error (see MaDUnsupported).
+	GenerateModelsAsData(mdl *XModel) ([]*MaDRow, error)
+}
+
+// MaDUnsupported collects the selections of a model that cannot be
+// expressed as models-as-data, so that they are reported together.
+type MaDUnsupported []string
+
+// Addf adds an unsupported selection (and why it is not supported).
+func (unsupported *MaDUnsupported) Addf(format string, args ...interface{}) {
+	*unsupported = append(*unsupported, fmt.Sprintf(format, args...))
+}
+
+// Err returns the error that lists the unsupported selections,
+// or nil if there are none.
+func (unsupported MaDUnsupported) Err() error {
+	if len(unsupported) == 0 {
+		return nil
+	}
+	return fmt.Errorf(
+		"%v selections cannot be expressed as models-as-data:\n- %s",
+		len(unsupported),
+		strings.Join(unsupported, "\n- "),
+	)
+}
+
+// NewMaDFuncRow returns a row for the func of the provided qualifier,
+// with the package, type, subtypes and name columns filled in.
+func NewMaDFuncRow(extensible MaDExtensible, qual *FuncQualifier) (*MaDRow, FuncInterface, error) {
+	source := GetCachedSource(qual.Path, qual.Version)
+	if source == nil {
+		return nil, nil, fmt.Errorf("Source not found: %s@%s", qual.Path, qual.Version)
+	}
+	fn := FindFuncByID(source, qual.ID)
+	if fn == nil {
+		return nil, nil, fmt.Errorf("Func not found: %q", qual.ID)
+	}
+	row := &MaDRow{
+		Extensible: extensible,
+		Package:    qual.Path,
+		Provenance: MaDProvenance,
+	}
+	switch thing := fn.(type) {
+	case *feparser.FEFunc:
+		row.Name = thing.Name
+	case *feparser.FETypeMethod:
+		row.Type = thing.Receiver.TypeName
+		row.Subtypes = true
+		row.Name = thing.Func.Name
+	case *feparser.FEInterfaceMethod:
+		row.Type = thing.Receiver.TypeName
+		row.Subtypes = true
+		row.Name = thing.Func.Name
+	default:
+		panic(Sf("Unknown type: %T", fn))
+	}
+	return row, fn, nil
+}
+
+// NewMaDFieldRow returns a row for the field of a struct.
+func NewMaDFieldRow(extensible MaDExtensible, qual *StructQualifier, fieldName string) *MaDRow {
+	return &MaDRow{
+		Extensible: extensible,
+		Package:    qual.Path,
+		Type:       qual.TypeName,
+		Subtypes:   true,
+		Name:       fieldName,
+		Provenance: MaDProvenance,
+	}
+}
+
+// FormatMaDAccessPath returns the access path of the element
+// at the provided absolute index of the func (e.g. `Argument[0]`,
+// `Argument[receiver]`, `ReturnValue`, `ReturnValue[1]`).
+func FormatMaDAccessPath(fn FuncInterface, index int) (string, error) {
+	elem, _, relIndex, err := fn.GetRelativeElement(index)
+	if err != nil {
+		return "", err
+	}
+	switch elem {
+	case feparser.ElementReceiver:
+		return "Argument[receiver]", nil
+	case feparser.ElementParameter:
+		return Sf("Argument[%v]", relIndex), nil
+	case feparser.ElementResult:
+		_, _, lenResults := fn.Lengths()
+		if lenResults == 1 {
+			return "ReturnValue", nil
+		}
+		return Sf("ReturnValue[%v]", relIndex), nil
+	default:
+		return "", fmt.Errorf("unknown element: %v", elem)
+	}
+}
+
+// FormatMaDAccessPaths returns the access paths of the
+// elements that are set to true in the provided positions.
+func FormatMaDAccessPaths(fn FuncInterface, positions []bool) ([]string, error) {
+	paths := make([]string, 0)
+	for index, ok := range positions {
+		if !ok {
+			continue
+		}
+		accessPath, err := FormatMaDAccessPath(fn, index)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, accessPath)
+	}
+	return paths, nil
+}
+
+// SortMaDRows sorts the rows and removes the duplicates.
+func SortMaDRows(rows []*MaDRow) []*MaDRow {
+	keyOf := func(row *MaDRow) string {
+		return string(row.Extensible) + "\x00" + strings.Join(formatMaDColumns(row, false), "\x00")
+	}
+	seen := make(map[string]bool)
+	res := make([]*MaDRow, 0, len(rows))
+	for _, row := range rows {
+		key := keyOf(row)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, row)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return keyOf(res[i]) < keyOf(res[j])
+	})
+	return res
+}
+
+func groupMaDRows(rows []*MaDRow) ([]MaDExtensible, map[MaDExtensible][]*MaDRow) {
+	groups := make(map[MaDExtensible][]*MaDRow)
+	for _, row := range SortMaDRows(rows) {
+		groups[row.Extensible] = append(groups[row.Extensible], row)
+	}
+	extensibles := make([]MaDExtensible, 0)
+	for _, extensible := range []MaDExtensible{MaDSourceModel, MaDSinkModel, MaDSummaryModel} {
+		if len(groups[extensible]) > 0 {
+			extensibles = append(extensibles, extensible)
+		}
+	}
+	return extensibles, groups
+}
+
+// formatMaDColumns returns the columns of the row as strings;
+// if quote is true, the strings are quoted (as in the yaml data extensions).
+func formatMaDColumns(row *MaDRow, quote bool) []string {
+	res := make([]string, 0)
+	for _, column := range row.Columns() {
+		switch v := column.(type) {
+		case string:
+			if quote {
+				res = append(res, strconv.Quote(v))
+			} else {
+				res = append(res, v)
+			}
+		case bool:
+			if quote {
+				// Same capitalization as the data extensions of codeql:
+				res = append(res, map[bool]string{true: "True", false: "False"}[v])
+			} else {
+				res = append(res, strconv.FormatBool(v))
+			}
+		default:
+			panic(Sf("Unknown type: %T", column))
+		}
+	}
+	return res
+}
+
+// RenderMaDYAML writes the rows as a codeql data extension file.
+func RenderMaDYAML(w io.Writer, rows []*MaDRow) error {
+	extensibles, groups := groupMaDRows(rows)
+	if _, err := fmt.Fprintln(w, "extensions:"); err != nil {
+		return err
+	}
+	if len(extensibles) == 0 {
+		_, err := fmt.Fprintln(w, "  []")
+		return err
+	}
+	for _, extensible := range extensibles {
+		_, err := fmt.Fprintf(w, "  - addsTo:\n      pack: %s\n      extensible: %s\n    data:\n", MaDPack, extensible)
+		if err != nil {
+			return err
+		}
+		for _, row := range groups[extensible] {
+			_, err := fmt.Fprintf(w, "      - [%s]\n", strings.Join(formatMaDColumns(row, true), ", "))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RenderMaDCSV writes the rows of the provided extensible as csv
+// (with a header of the column names).
+func RenderMaDCSV(w io.Writer, extensible MaDExtensible, rows []*MaDRow) error {
+	_, groups := groupMaDRows(rows)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(MaDColumnNames(extensible)); err != nil {
+		return err
+	}
+	for _, row := range groups[extensible] {
+		if err := writer.Write(formatMaDColumns(row, false)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ListMaDExtensibles returns the extensibles that have at least one row.
+func ListMaDExtensibles(rows []*MaDRow) []MaDExtensible {
+	extensibles, _ := groupMaDRows(rows)
+	return extensibles
+}
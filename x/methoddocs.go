@@ -0,0 +1,101 @@
+package x
+
+import (
+	"fmt"
+
+	. "github.com/gagliardetto/utilz"
+)
+
+// MethodDoc documents a method of a ModelKind for the users
+// (i.e. what to select, and how it relates to the other methods).
+type MethodDoc struct {
+	Name        string // Name of the method.
+	Description string
+	// Examples are funcs (or fields, types) to select, and what to select of them.
+	Examples []string
+	// Constraints are the rules the selections must follow.
+	Constraints []string
+	// CoupledWith are the methods where the funcs selected
+	// in this method must be selected too.
+	CoupledWith []string
+	// RequiresOneOf are the methods of which at least one must have selectors
+	// for the selections of this method to be used.
+	RequiresOneOf []string
+}
+
+// MethodDocumenter is implemented by the handlers that document their methods.
+type MethodDocumenter interface {
+	// MethodDocs returns the docs of the methods (in the order of ScavengeMethods).
+	MethodDocs() []*MethodDoc
+}
+
+// ScavengeMethodsFromDocs returns the initialized methods
+// described by the provided docs (with their descriptions).
+func ScavengeMethodsFromDocs(docs []*MethodDoc) []*XMethod {
+	nameDescs := make([]string, 0, len(docs)*2)
+	for _, doc := range docs {
+		nameDescs = append(nameDescs, doc.Name, doc.Description)
+	}
+	return ScavengeMethodsWithNameDescription(nameDescs...)
+}
+
+// GetMethodDocs returns the docs of the methods of the provided kind, including
+// the constraints that follow from their capabilities; the handlers that do not
+// document their methods get the descriptions of their methods (if any).
+func (rt *ModelKindRouter) GetMethodDocs(kind ModelKind) ([]*MethodDoc, error) {
+	handler := rt.GetHandler(kind)
+	if handler == nil {
+		return nil, fmt.Errorf("handler not found for kind %s", kind)
+	}
+	docs := make([]*MethodDoc, 0)
+	if documenter, ok := handler.(MethodDocumenter); ok {
+		for _, doc := range documenter.MethodDocs() {
+			clone := *doc
+			docs = append(docs, &clone)
+		}
+	} else {
+		for _, mt := range handler.ScavengeMethods() {
+			docs = append(docs, &MethodDoc{
+				Name:        mt.Name,
+				Description: mt.Description,
+			})
+		}
+	}
+
+	capabilities := handler.Capabilities()
+	for _, doc := range docs {
+		caps := findMethodCapabilities(capabilities, doc.Name)
+		if caps == nil {
+			continue
+		}
+		doc.Constraints = append(DescribeCapabilities(caps), doc.Constraints...)
+	}
+	return docs, nil
+}
+
+// DescribeCapabilities returns the constraints that
+// follow from the capabilities, in words.
+func DescribeCapabilities(caps *MethodCapabilities) []string {
+	constraints := make([]string, 0)
+	if len(caps.SelectorKinds) == 1 && caps.SelectorKinds[0] == SelectorKindFunc {
+		constraints = append(constraints, "Only funcs (functions, type methods, interface methods) can be selected.")
+	}
+	if caps.SupportsSelectorKind(SelectorKindFunc) {
+		allRoles := len(caps.Roles) == len(AllElementRoles)
+		switch {
+		case caps.Mode == SelectionModeFlow && allRoles:
+			constraints = append(constraints, "Funcs are selected as flows from inputs to outputs, which can be the receiver, the parameters and the results.")
+		case caps.Mode == SelectionModeFlow:
+			constraints = append(constraints, Sf("Funcs are selected as flows from inputs to outputs, which can be only %s.", formatRoles(caps.Roles)))
+		case !allRoles:
+			constraints = append(constraints, Sf("Only %s of funcs can be selected.", formatRoles(caps.Roles)))
+		}
+	}
+	if caps.MaxSelections > 0 {
+		constraints = append(constraints, Sf("At most %v element(s) per func can be selected.", caps.MaxSelections))
+	}
+	if caps.ContentType {
+		constraints = append(constraints, "Each func must have a content-type (guessed from the func name, or set explicitly).")
+	}
+	return constraints
+}
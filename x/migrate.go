@@ -0,0 +1,287 @@
+package x
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// MigrationReport describes what happens to the selectors
+// of a module when moving them to another version.
+type MigrationReport struct {
+	Path        string
+	FromVersion string
+	ToVersion   string
+	DryRun      bool
+
+	Migrated []*MigrationEntry // Re-targeted, with all selections carried over.
+	Changed  []*MigrationEntry // Re-targeted, but the signature (or fields) changed; the selections that cannot be carried over are reset.
+	Removed  []*MigrationEntry // Not present in the new version; the selector is removed.
+}
+
+type MigrationEntry struct {
+	Model        string
+	Method       string
+	Kind         SelectorKind
+	ID           string
+	OldSignature string `json:",omitempty"`
+	NewSignature string `json:",omitempty"`
+	Note         string `json:",omitempty"`
+}
+
+// MigrateModule re-targets all the selectors of the provided module
+// from one version to another.
+// Funcs, structs and types are matched by ID in the new version;
+// Pos and Flows of funcs are carried over only if the signature did not change;
+// the elements that are already selected in the new version get the
+// selections of both selectors.
+// If dryRun is true, the spec is not modified.
+// NOTE: the caller must lock the spec.
+func (spec *XSpec) MigrateModule(path string, fromVersion string, toVersion string, loader PackageLoader, dryRun bool) (*MigrationReport, error) {
+	if path == "" {
+		return nil, errors.New("path not specified")
+	}
+	if fromVersion == "" || toVersion == "" {
+		return nil, errors.New("from and to versions must be specified")
+	}
+	if fromVersion == toVersion {
+		return nil, errors.New("from and to versions are the same")
+	}
+
+	oldSource, err := loader(path, fromVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading package %s: %s", FormatPathVersion(path, fromVersion), err)
+	}
+	newSource, err := loader(path, toVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading package %s: %s", FormatPathVersion(path, toVersion), err)
+	}
+
+	report := &MigrationReport{
+		Path:        path,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		DryRun:      dryRun,
+		Migrated:    make([]*MigrationEntry, 0),
+		Changed:     make([]*MigrationEntry, 0),
+		Removed:     make([]*MigrationEntry, 0),
+	}
+
+	for _, mdl := range spec.Models {
+		for _, mtd := range mdl.Methods {
+			kept := make([]*XSelector, 0, len(mtd.Selectors))
+			// The migrated selectors of the elements that are already
+			// selected in the new version:
+			merged := make([]*XSelector, 0)
+			for _, sel := range mtd.Selectors {
+				basicQual := sel.GetBasicQualifier()
+				if basicQual.Path != path || basicQual.Version != fromVersion {
+					kept = append(kept, sel)
+					continue
+				}
+				entry := &MigrationEntry{
+					Model:  mdl.Name,
+					Method: mtd.Name,
+					Kind:   sel.Kind,
+					ID:     basicQual.ID,
+				}
+
+				var migrated *XSelector
+				var changed bool
+				switch qual := sel.Qualifier.(type) {
+				case *FuncQualifier:
+					migrated, changed = migrateFuncQualifier(qual, oldSource, newSource, toVersion, entry)
+				case *StructQualifier:
+					migrated, changed = migrateStructQualifier(qual, newSource, toVersion, entry)
+				case *TypeQualifier:
+					migrated, changed = migrateTypeQualifier(qual, newSource, toVersion, entry)
+				default:
+					panic(Sf("Unknown type: %T", sel.Qualifier))
+				}
+
+				if migrated == nil {
+					report.Removed = append(report.Removed, entry)
+					continue
+				}
+				if changed {
+					report.Changed = append(report.Changed, entry)
+				} else {
+					report.Migrated = append(report.Migrated, entry)
+				}
+				alreadySelected := mtd.hasSelector(path, toVersion, basicQual.ID)
+				if alreadySelected {
+					if entry.Note != "" {
+						entry.Note += "; "
+					}
+					entry.Note += "already selected in the new version; the selections are merged"
+				}
+				switch {
+				case dryRun:
+					kept = append(kept, sel)
+				case alreadySelected:
+					merged = append(merged, migrated)
+				default:
+					kept = append(kept, migrated)
+				}
+			}
+			if !dryRun {
+				mtd.Selectors = kept
+				for _, sel := range merged {
+					mergeSelectorInto(mtd, sel)
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (mt *XMethod) hasSelector(path string, version string, id string) bool {
+	for _, sel := range mt.Selectors {
+		if sel.GetBasicQualifier().Is(path, version, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func migrateFuncQualifier(qual *FuncQualifier, oldSource *feparser.FEPackage, newSource *feparser.FEPackage, toVersion string, entry *MigrationEntry) (*XSelector, bool) {
+	if oldFn := FindFuncByID(oldSource, qual.ID); oldFn != nil {
+		entry.OldSignature = oldFn.GetFunc().Signature
+	}
+	newFn := FindFuncByID(newSource, qual.ID)
+	if newFn == nil {
+		entry.Note = "func not found in the new version"
+		return nil, false
+	}
+	entry.NewSignature = newFn.GetFunc().Signature
+
+	migrated := &FuncQualifier{
+		BasicQualifier: qual.BasicQualifier,
+		ContentType:    qual.ContentType,
+		Name:           newFn.GetFunc().Name,
+	}
+	migrated.Version = toVersion
+
+	changed := entry.OldSignature == "" || entry.OldSignature != entry.NewSignature
+	if changed {
+		// The positions might not mean the same thing anymore:
+		migrated.Pos = make([]bool, newFn.Len())
+		if qual.Flows != nil {
+			migrated.Flows = &FlowSpec{
+				Blocks: make([]*FlowBlock, 0),
+			}
+		}
+		entry.Note = "signature changed; Pos and Flows were reset"
+	} else {
+		migrated.Pos = qual.Pos
+		migrated.Flows = qual.Flows
+	}
+
+	return &XSelector{
+		Kind:      SelectorKindFunc,
+		Qualifier: migrated,
+	}, changed
+}
+
+func migrateStructQualifier(qual *StructQualifier, newSource *feparser.FEPackage, toVersion string, entry *MigrationEntry) (*XSelector, bool) {
+	st := FindStructByID(newSource, qual.ID)
+	if st == nil {
+		entry.Note = "struct not found in the new version"
+		return nil, false
+	}
+
+	migrated := &StructQualifier{
+		BasicQualifier: qual.BasicQualifier,
+		TypeName:       qual.TypeName,
+		Fields:         make(map[string]*FieldMeta),
+	}
+	migrated.Version = toVersion
+
+	missing := make([]string, 0)
+	for fieldName, meta := range qual.Fields {
+		if FindFieldByName(st, fieldName) == nil {
+			missing = append(missing, fieldName)
+			continue
+		}
+		migrated.Fields[fieldName] = meta
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		entry.Note = Sf("fields not found in the new version: %v", missing)
+	}
+
+	return &XSelector{
+		Kind:      SelectorKindStruct,
+		Qualifier: migrated,
+	}, len(missing) > 0
+}
+
+func migrateTypeQualifier(qual *TypeQualifier, newSource *feparser.FEPackage, toVersion string, entry *MigrationEntry) (*XSelector, bool) {
+	if FindTypeByID(newSource, qual.ID) == nil {
+		entry.Note = "type not found in the new version"
+		return nil, false
+	}
+
+	migrated := *qual
+	migrated.Version = toVersion
+
+	return &XSelector{
+		Kind:      SelectorKindType,
+		Qualifier: &migrated,
+	}, false
+}
+
+// mergeSelectorInto adds the selector to the method; if the method
+// already has a selector for the same element, the two are merged.
+func mergeSelectorInto(mt *XMethod, sel *XSelector) {
+	basicQual := sel.GetBasicQualifier()
+	for _, existing := range mt.Selectors {
+		if existing.Kind != sel.Kind || !existing.GetBasicQualifier().Is(basicQual.Path, basicQual.Version, basicQual.ID) {
+			continue
+		}
+		switch qual := sel.Qualifier.(type) {
+		case *FuncQualifier:
+			existingQual := existing.GetFuncQualifier()
+			if len(qual.Pos) > len(existingQual.Pos) {
+				existingQual.Pos = append(existingQual.Pos, make([]bool, len(qual.Pos)-len(existingQual.Pos))...)
+			}
+			for index, ok := range qual.Pos {
+				if ok {
+					existingQual.Pos[index] = true
+				}
+			}
+			if qual.Flows != nil {
+				if existingQual.Flows == nil {
+					existingQual.Flows = qual.Flows
+				} else {
+					existingQual.Flows.Blocks = append(existingQual.Flows.Blocks, qual.Flows.Blocks...)
+					existingQual.Flows.Enabled = existingQual.Flows.Enabled || qual.Flows.Enabled
+				}
+			}
+		case *StructQualifier:
+			existingQual := existing.GetStructQualifier()
+			for name, field := range qual.Fields {
+				existingQual.Fields[name] = field
+			}
+			{ // Update counts:
+				if existingQual.Total == 0 {
+					existingQual.Total = qual.Total
+				}
+				if existingQual.Total > 0 {
+					existingQual.Left = existingQual.Total - len(existingQual.Fields)
+				}
+			}
+		case *TypeQualifier:
+			existingQual := existing.GetTypeQualifier()
+			existingQual.Value = existingQual.Value || qual.Value
+		default:
+			panic(Sf("Unknown type: %T", sel.Qualifier))
+		}
+		return
+	}
+	mt.Selectors = append(mt.Selectors, sel)
+}
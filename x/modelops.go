@@ -0,0 +1,291 @@
+package x
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gagliardetto/feparser"
+	. "github.com/gagliardetto/utilz"
+)
+
+// CloneModel returns a deep copy of the model.
+func CloneModel(mdl *XModel) (*XModel, error) {
+	var clone XModel
+	if err := TranscodeJSON(mdl, &clone); err != nil {
+		return nil, fmt.Errorf("error while copying model %q: %s", mdl.Name, err)
+	}
+	return &clone, nil
+}
+
+// normalizeNewModelName normalizes the provided name of a model,
+// and makes sure that no model of the spec already has it;
+// the caller must hold the lock of the spec.
+func (spec *XSpec) normalizeNewModelName(name string) (string, error) {
+	name = ToCamel(name)
+	if name == "" {
+		return "", fmt.Errorf("Model name not valid")
+	}
+	if spec.hasModelName(name) {
+		return "", fmt.Errorf("Class with the provided name already exists: %q", name)
+	}
+	return name, nil
+}
+
+// DeleteModelByName removes the model from the spec.
+func (spec *XSpec) DeleteModelByName(name string) error {
+	spec.Lock()
+	defer spec.Unlock()
+
+	for i, mdl := range spec.Models {
+		if mdl.Name == name {
+			spec.Models = append(spec.Models[:i], spec.Models[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Model %q (on spec %q) not found", name, spec.Name)
+}
+
+// DuplicateModel adds to the spec a copy of the model (including
+// its selectors) with the provided name, and returns it.
+func (spec *XSpec) DuplicateModel(name string, newName string) (*XModel, error) {
+	spec.Lock()
+	defer spec.Unlock()
+
+	for _, mdl := range spec.Models {
+		if mdl.Name != name {
+			continue
+		}
+		normalized, err := spec.normalizeNewModelName(newName)
+		if err != nil {
+			return nil, err
+		}
+		clone, err := CloneModel(mdl)
+		if err != nil {
+			return nil, err
+		}
+		clone.Name = normalized
+		spec.Models = append(spec.Models, clone)
+		return clone, nil
+	}
+	return nil, fmt.Errorf("Model %q (on spec %q) not found", name, spec.Name)
+}
+
+// PatchModel renames the model (if newName is not empty) and/or converts it to
+// the provided kind (if not empty, see ConvertModel); the new name must be unique
+// in the spec. The returned warnings describe the selectors that were not carried
+// over as they were by the conversion. On error, the model is left unchanged.
+func (spec *XSpec) PatchModel(name string, newName string, kind ModelKind) ([]string, error) {
+	spec.Lock()
+	defer spec.Unlock()
+
+	for i, mdl := range spec.Models {
+		if mdl.Name != name {
+			continue
+		}
+		if newName != "" && ToCamel(newName) != name {
+			normalized, err := spec.normalizeNewModelName(newName)
+			if err != nil {
+				return nil, err
+			}
+			newName = normalized
+		} else {
+			newName = name
+		}
+
+		warnings := make([]string, 0)
+		if kind != "" {
+			converted, convWarnings, err := ConvertModel(mdl, kind)
+			if err != nil {
+				return nil, err
+			}
+			mdl = converted
+			warnings = append(warnings, convWarnings...)
+		}
+		mdl.Name = newName
+		spec.Models[i] = mdl
+		return warnings, nil
+	}
+	return nil, fmt.Errorf("Model %q (on spec %q) not found", name, spec.Name)
+}
+
+// ConvertModel returns a copy of the model converted to the provided kind.
+// The selectors of a method are carried over to the method of the new kind
+// with the same name or, if the new kind has only one method, to that method;
+// the selectors not supported by the capabilities of the method are dropped.
+// The positions (Pos) of func selectors are converted to a flow block
+// (the receiver and parameters are inputs, the results are outputs), and
+// the flows are converted to the positions of all their inputs and outputs;
+// the selected elements whose role is not supported are unselected.
+// The returned warnings describe the selectors that were not carried over as they were.
+func ConvertModel(mdl *XModel, kind ModelKind) (*XModel, []string, error) {
+	if !IsValidModelKind(kind) {
+		return nil, nil, fmt.Errorf("Model Kind not valid: %q", kind)
+	}
+	clone, err := CloneModel(mdl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mdl.Kind == kind {
+		return clone, nil, nil
+	}
+	converted := &XModel{
+		Name:    clone.Name,
+		Kind:    kind,
+		Methods: NewScavengeMethods(kind),
+	}
+
+	warnings := make([]string, 0)
+	for _, mt := range clone.Methods {
+		target := converted.Methods.ByName(mt.Name)
+		if target == nil && len(converted.Methods) == 1 {
+			target = converted.Methods[0]
+		}
+		if target == nil {
+			if len(mt.Selectors) > 0 {
+				warnings = append(warnings, Sf("method %q does not exist in kind %s: dropped %v selector(s)", mt.Name, kind, len(mt.Selectors)))
+			}
+			continue
+		}
+
+		caps, err := Router().GetMethodCapabilities(kind, target.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, sel := range mt.Selectors {
+			basicQual := sel.GetBasicQualifier()
+			if !caps.SupportsSelectorKind(sel.Kind) {
+				warnings = append(warnings, Sf("method %q: %s selector %q of %s is not supported by kind %s: dropped", mt.Name, sel.Kind, basicQual.ID, basicQual.PathVersion(), kind))
+				continue
+			}
+			if qual := sel.GetFuncQualifier(); qual != nil {
+				warning, err := convertFuncQualifier(qual, caps)
+				if err != nil {
+					warnings = append(warnings, Sf("method %q: func %q of %s: %s: dropped", mt.Name, qual.ID, qual.PathVersion(), err))
+					continue
+				}
+				if warning != "" {
+					warnings = append(warnings, Sf("method %q: func %q of %s: %s", mt.Name, qual.ID, qual.PathVersion(), warning))
+				}
+			}
+			mergeSelectorInto(target, sel)
+		}
+	}
+	return converted, warnings, nil
+}
+
+// convertFuncQualifier converts the qualifier to the mode of the method
+// (from Pos to Flows, or from Flows to Pos), and unselects the elements
+// whose role the method does not support; the returned string is a warning.
+func convertFuncQualifier(qual *FuncQualifier, caps *MethodCapabilities) (string, error) {
+	warning, err := convertFuncQualifierMode(qual, caps.Mode == SelectionModeFlow)
+	if err != nil {
+		return "", err
+	}
+	if qual.Elements == nil {
+		return warning, nil
+	}
+	unselected := make([]string, 0)
+	unselect := func(elems []bool) {
+		for index, ok := range elems {
+			if !ok {
+				continue
+			}
+			role, elem := qual.Elements.ElementAt(index)
+			if elem != nil && !caps.HasRole(role) {
+				elems[index] = false
+				unselected = append(unselected, elem.Describe(role))
+			}
+		}
+	}
+	unselect(qual.Pos)
+	if qual.Flows != nil {
+		for _, block := range qual.Flows.Blocks {
+			unselect(block.Inp)
+			unselect(block.Out)
+		}
+	}
+	if len(unselected) == 0 {
+		return warning, nil
+	}
+	if AllFalse(qual.Pos...) && (qual.Flows == nil || AllBlocksEmpty(qual.Flows.Blocks...)) {
+		return "", fmt.Errorf("no selected element is supported by method %q", caps.Name)
+	}
+	if warning != "" {
+		warning += "; "
+	}
+	return warning + Sf("unselected %s (not supported by method %q)", strings.Join(unselected, ", "), caps.Name), nil
+}
+
+// convertFuncQualifierMode converts the qualifier from Pos to
+// Flows (or from Flows to Pos); the returned string is a warning.
+func convertFuncQualifierMode(qual *FuncQualifier, toFlows bool) (string, error) {
+	hasFlows := qual.Flows != nil && !AllBlocksEmpty(qual.Flows.Blocks...)
+	if toFlows {
+		if hasFlows || AllFalse(qual.Pos...) {
+			qual.Pos = nil
+			return "", nil
+		}
+		fn, err := findFuncOfQualifier(qual)
+		if err != nil {
+			return "", err
+		}
+		block := &FlowBlock{
+			Inp: make([]bool, fn.Len()),
+			Out: make([]bool, fn.Len()),
+		}
+		for index, ok := range qual.Pos {
+			if !ok || index >= fn.Len() {
+				continue
+			}
+			elem, _, _, err := fn.GetRelativeElement(index)
+			if err != nil {
+				return "", err
+			}
+			if elem == feparser.ElementResult {
+				block.Out[index] = true
+			} else {
+				block.Inp[index] = true
+			}
+		}
+		qual.Pos = nil
+		qual.Flows = &FlowSpec{
+			Blocks:  []*FlowBlock{block},
+			Enabled: !AllFalse(block.Inp...) && !AllFalse(block.Out...),
+		}
+		if !qual.Flows.Enabled {
+			return "the flow has only inputs or only outputs; it was disabled", nil
+		}
+		return "", nil
+	}
+
+	if !hasFlows {
+		qual.Flows = nil
+		return "", nil
+	}
+	var pos []bool
+	for _, block := range qual.Flows.Blocks {
+		if pos == nil {
+			pos = make([]bool, len(block.Inp))
+		}
+		for index := range pos {
+			if (index < len(block.Inp) && block.Inp[index]) || (index < len(block.Out) && block.Out[index]) {
+				pos[index] = true
+			}
+		}
+	}
+	qual.Flows = nil
+	qual.Pos = pos
+	return "the inputs and outputs of the flows were merged into the selected positions", nil
+}
+
+func findFuncOfQualifier(qual *FuncQualifier) (FuncInterface, error) {
+	source := GetCachedSource(qual.Path, qual.Version)
+	if source == nil {
+		return nil, fmt.Errorf("Source not found: %s@%s", qual.Path, qual.Version)
+	}
+	fn := FindFuncByID(source, qual.ID)
+	if fn == nil {
+		return nil, fmt.Errorf("Func not found: %q", qual.ID)
+	}
+	return fn, nil
+}
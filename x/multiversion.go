@@ -0,0 +1,175 @@
+package x
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/gagliardetto/utilz"
+	"golang.org/x/mod/semver"
+)
+
+// MultiversionConflict is an element (func, struct or type) that
+// is selected in multiple versions of the same package,
+// but with different selections (e.g. different flows).
+type MultiversionConflict struct {
+	Model    string
+	Method   string
+	Path     string
+	ID       string
+	Versions []string
+
+	methodIndex     int
+	selectorIndexes []int
+}
+
+func (conflict *MultiversionConflict) Error() string {
+	return fmt.Sprintf(
+		"model %q, method %q: %q of %s has different selections across versions %s",
+		conflict.Model,
+		conflict.Method,
+		conflict.ID,
+		conflict.Path,
+		strings.Join(conflict.Versions, ", "),
+	)
+}
+
+// UnionVersions returns a copy of the model where the selectors of
+// multiple versions of the same package are unioned by path:
+// if an element is selected in the same way in multiple versions,
+// only the selector of the latest version is kept;
+// if the selections differ, the element is reported as a conflict
+// (and all its selectors are kept).
+// Elements that exist only in some of the versions are kept as they are.
+// The provided model is not modified.
+func UnionVersions(mdl *XModel) (*XModel, []*MultiversionConflict) {
+	union := &XModel{
+		Name:    mdl.Name,
+		Kind:    mdl.Kind,
+		Methods: make(XMethodSlice, 0, len(mdl.Methods)),
+	}
+	conflicts := make([]*MultiversionConflict, 0)
+
+	for methodIndex, mtd := range mdl.Methods {
+		drop := make(map[int]bool)
+		for _, group := range groupSelectorsByPathID(mtd) {
+			if len(group) < 2 {
+				continue
+			}
+			if !haveSameSelections(mtd, group) {
+				conflict := &MultiversionConflict{
+					Model:           mdl.Name,
+					Method:          mtd.Name,
+					Path:            mtd.Selectors[group[0]].GetBasicQualifier().Path,
+					ID:              mtd.Selectors[group[0]].GetBasicQualifier().ID,
+					methodIndex:     methodIndex,
+					selectorIndexes: group,
+				}
+				for _, selectorIndex := range group {
+					conflict.Versions = append(conflict.Versions, mtd.Selectors[selectorIndex].GetBasicQualifier().Version)
+				}
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+			// Keep only the latest version:
+			latest := group[0]
+			for _, selectorIndex := range group[1:] {
+				if compareVersions(
+					mtd.Selectors[selectorIndex].GetBasicQualifier().Version,
+					mtd.Selectors[latest].GetBasicQualifier().Version,
+				) > 0 {
+					latest = selectorIndex
+				}
+			}
+			for _, selectorIndex := range group {
+				if selectorIndex != latest {
+					drop[selectorIndex] = true
+				}
+			}
+		}
+
+		selectors := make([]*XSelector, 0, len(mtd.Selectors))
+		for selectorIndex, sel := range mtd.Selectors {
+			if !drop[selectorIndex] {
+				selectors = append(selectors, sel)
+			}
+		}
+		union.Methods = append(union.Methods, &XMethod{
+			Name:        mtd.Name,
+			Description: mtd.Description,
+			Selectors:   selectors,
+		})
+	}
+
+	return union, conflicts
+}
+
+// groupSelectorsByPathID returns the indexes of the selectors of the method,
+// grouped by path and ID (i.e. ignoring the version).
+func groupSelectorsByPathID(mtd *XMethod) [][]int {
+	keys := make([]string, 0)
+	groups := make(map[string][]int)
+	for selectorIndex, sel := range mtd.Selectors {
+		basicQual := sel.GetBasicQualifier()
+		key := basicQual.Path + "#" + basicQual.ID
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], selectorIndex)
+	}
+	res := make([][]int, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, groups[key])
+	}
+	return res
+}
+
+func haveSameSelections(mtd *XMethod, selectorIndexes []int) bool {
+	first := selectionFingerprint(mtd.Selectors[selectorIndexes[0]])
+	for _, selectorIndex := range selectorIndexes[1:] {
+		if selectionFingerprint(mtd.Selectors[selectorIndex]) != first {
+			return false
+		}
+	}
+	return true
+}
+
+// selectionFingerprint returns a string that represents
+// what is selected by the selector, independently of the version.
+func selectionFingerprint(sel *XSelector) string {
+	var v interface{}
+	switch qual := sel.Qualifier.(type) {
+	case *FuncQualifier:
+		var flows []*FlowBlock
+		if qual.Flows != nil && qual.Flows.Enabled {
+			flows = qual.Flows.Blocks
+		}
+		v = []interface{}{qual.Pos, flows, qual.ContentType}
+	case *StructQualifier:
+		fields := make([]string, 0, len(qual.Fields))
+		for name := range qual.Fields {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+		v = fields
+	case *TypeQualifier:
+		v = qual.Value
+	default:
+		panic(Sf("Unknown type: %T", sel.Qualifier))
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// compareVersions compares two module versions;
+// non-semver versions are compared lexically.
+func compareVersions(a string, b string) int {
+	if semver.IsValid(a) && semver.IsValid(b) {
+		return semver.Compare(a, b)
+	}
+	return strings.Compare(a, b)
+}
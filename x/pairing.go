@@ -0,0 +1,65 @@
+package x
+
+import (
+	"fmt"
+
+	. "github.com/gagliardetto/utilz"
+)
+
+// UnpairedSelectorError is the error of a func that is selected
+// in a method, but not in the method it is coupled with.
+type UnpairedSelectorError struct {
+	ID   string // ID of the func.
+	From string // Name of the method where the func is selected.
+	To   string // Name of the method where the func is not selected.
+}
+
+func (e *UnpairedSelectorError) Error() string {
+	return fmt.Sprintf("func %q is selected in %q but not in %q", e.ID, e.From, e.To)
+}
+
+// CheckPairedSelectors returns an *UnpairedSelectorError for the first func
+// selector of the method named `first` that doesn't have a counterpart
+// (same path, version and ID) in the method named `second`, or vice versa.
+func CheckPairedSelectors(mdl *XModel, first string, second string) error {
+	var err error
+	forEachUnpairedSelector(mdl, first, second, func(from int, selectorIndex int, unpaired *UnpairedSelectorError) {
+		if err == nil {
+			err = unpaired
+		}
+	})
+	return err
+}
+
+func forEachUnpairedSelector(mdl *XModel, first string, second string, callback func(from int, selectorIndex int, unpaired *UnpairedSelectorError)) {
+	firstIndex, secondIndex := -1, -1
+	for i, mtd := range mdl.Methods {
+		switch mtd.Name {
+		case first:
+			firstIndex = i
+		case second:
+			secondIndex = i
+		}
+	}
+	if firstIndex < 0 || secondIndex < 0 {
+		return
+	}
+	check := func(from int, to int) {
+		for selectorIndex, sel := range mdl.Methods[from].Selectors {
+			qual := sel.GetFuncQualifier()
+			if qual == nil || AllFalse(qual.Pos...) {
+				continue
+			}
+			other := mdl.Methods[to].GetFuncSelector(qual.Path, qual.Version, qual.ID)
+			if other == nil || AllFalse(other.Pos...) {
+				callback(from, selectorIndex, &UnpairedSelectorError{
+					ID:   qual.ID,
+					From: mdl.Methods[from].Name,
+					To:   mdl.Methods[to].Name,
+				})
+			}
+		}
+	}
+	check(firstIndex, secondIndex)
+	check(secondIndex, firstIndex)
+}
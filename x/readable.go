@@ -0,0 +1,451 @@
+package x
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/gagliardetto/utilz"
+	"gopkg.in/yaml.v2"
+)
+
+// The readable spec format is a YAML serialization of the spec
+// where the selected positions of funcs are referenced by element
+// (`receiver`, `param:<name>`, `param:<index>`, `result:<index>`)
+// instead of by arrays of bools; it is meant to be reviewed in PRs.
+// Spec files with a .yaml or .yml extension use this format.
+
+type readableSpec struct {
+	Name   string           `yaml:"name"`
+	Models []*readableModel `yaml:"models"`
+}
+
+type readableModel struct {
+	Name    string            `yaml:"name"`
+	Kind    ModelKind         `yaml:"kind"`
+	Methods []*readableMethod `yaml:"methods"`
+}
+
+type readableMethod struct {
+	Name        string              `yaml:"name"`
+	Description string              `yaml:"description,omitempty"`
+	Selectors   []*readableSelector `yaml:"selectors"`
+}
+
+type readableSelector struct {
+	Kind    SelectorKind `yaml:"kind"`
+	Path    string       `yaml:"path"`
+	Version string       `yaml:"version"`
+	ID      string       `yaml:"id"`
+
+	// Func:
+	Name        string         `yaml:"name,omitempty"`
+	Pos         []string       `yaml:"pos,flow,omitempty"`
+	Flows       *readableFlows `yaml:"flows,omitempty"`
+	ContentType string         `yaml:"contentType,omitempty"`
+
+	// Struct and Type:
+	TypeName string `yaml:"typeName,omitempty"`
+	// Struct:
+	Fields []string `yaml:"fields,flow,omitempty"`
+	// Type:
+	Value bool `yaml:"value,omitempty"`
+}
+
+type readableFlows struct {
+	Enabled bool                 `yaml:"enabled"`
+	Blocks  []*readableFlowBlock `yaml:"blocks"`
+}
+
+type readableFlowBlock struct {
+	Inp []string `yaml:"inp,flow"`
+	Out []string `yaml:"out,flow"`
+}
+
+// IsReadableSpecPath returns true if the spec file at the
+// provided path uses the readable (YAML) format.
+func IsReadableSpecPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// SaveSpecToFile saves the spec to file, in the format
+// implied by the file extension (readable YAML, or JSON).
+func SaveSpecToFile(spec *XSpec, path string) error {
+	if !IsReadableSpecPath(path) {
+		return SaveAsJSON(spec, path)
+	}
+	data, err := MarshalReadableSpec(spec)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0640); err != nil {
+		return fmt.Errorf("error while writing spec file: %s", err)
+	}
+	return nil
+}
+
+// ReadSpecFile loads a spec from file as it is,
+// i.e. without validating it; the loader is used only
+// for specs in the readable format, to resolve the element references.
+func ReadSpecFile(path string, loader PackageLoader) (*XSpec, error) {
+	if !IsReadableSpecPath(path) {
+		spec := newXSpec()
+		if err := LoadJSON(spec, path); err != nil {
+			return nil, fmt.Errorf("error while loading spec file: %s", err)
+		}
+		return spec, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading spec file: %s", err)
+	}
+	spec, err := UnmarshalReadableSpec(data, loader)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading spec file: %s", err)
+	}
+	return spec, nil
+}
+
+// MarshalReadableSpec serializes the spec in the readable format;
+// the sources of the selected funcs must be loaded (i.e. cached),
+// otherwise the positions are referenced by absolute index (`#<index>`).
+func MarshalReadableSpec(spec *XSpec) ([]byte, error) {
+	out := &readableSpec{
+		Name:   spec.Name,
+		Models: make([]*readableModel, 0, len(spec.Models)),
+	}
+	for _, mdl := range spec.Models {
+		rMdl := &readableModel{
+			Name:    mdl.Name,
+			Kind:    mdl.Kind,
+			Methods: make([]*readableMethod, 0, len(mdl.Methods)),
+		}
+		for _, mtd := range mdl.Methods {
+			rMtd := &readableMethod{
+				Name:        mtd.Name,
+				Description: mtd.Description,
+				Selectors:   make([]*readableSelector, 0, len(mtd.Selectors)),
+			}
+			for _, sel := range mtd.Selectors {
+				basicQual := sel.GetBasicQualifier()
+				rSel := &readableSelector{
+					Kind:    sel.Kind,
+					Path:    basicQual.Path,
+					Version: basicQual.Version,
+					ID:      basicQual.ID,
+				}
+				switch qual := sel.Qualifier.(type) {
+				case *FuncQualifier:
+					fn := LookupFunc(basicQual)
+					rSel.Name = qual.Name
+					rSel.Pos = FormatElementRefs(fn, qual.Pos)
+					rSel.ContentType = qual.ContentType
+					if qual.Flows != nil {
+						rSel.Flows = &readableFlows{
+							Enabled: qual.Flows.Enabled,
+							Blocks:  make([]*readableFlowBlock, 0, len(qual.Flows.Blocks)),
+						}
+						for _, block := range qual.Flows.Blocks {
+							rSel.Flows.Blocks = append(rSel.Flows.Blocks, &readableFlowBlock{
+								Inp: FormatElementRefs(fn, block.Inp),
+								Out: FormatElementRefs(fn, block.Out),
+							})
+						}
+					}
+				case *StructQualifier:
+					rSel.TypeName = qual.TypeName
+					rSel.Fields = sortedFieldNames(qual)
+				case *TypeQualifier:
+					rSel.TypeName = qual.TypeName
+					rSel.Value = qual.Value
+				default:
+					panic(Sf("Unknown type: %T", sel.Qualifier))
+				}
+				rMtd.Selectors = append(rMtd.Selectors, rSel)
+			}
+			rMdl.Methods = append(rMdl.Methods, rMtd)
+		}
+		out.Models = append(out.Models, rMdl)
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("error while marshaling spec: %s", err)
+	}
+	return data, nil
+}
+
+// UnmarshalReadableSpec parses a spec in the readable format;
+// the loader is used to load the sources of the funcs,
+// which are needed to resolve the element references.
+func UnmarshalReadableSpec(data []byte, loader PackageLoader) (*XSpec, error) {
+	in, err := decodeReadableSpec(data)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	spec := in.toSpec(loader, func(selErr *readableSelectorError) {
+		if firstErr == nil {
+			firstErr = selErr
+		}
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return spec, nil
+}
+
+func decodeReadableSpec(data []byte) (*readableSpec, error) {
+	var in readableSpec
+	if err := yaml.UnmarshalStrict(data, &in); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling spec: %s", err)
+	}
+	return &in, nil
+}
+
+// readableSelectorError is a problem of a selector of a readable spec.
+type readableSelectorError struct {
+	Model    string
+	Method   string
+	ID       string
+	Pointer  string // JSON pointer to the offending value in the equivalent JSON spec.
+	Err      error
+	LoadFunc bool // The func of the selector could not be loaded.
+}
+
+func (e *readableSelectorError) Error() string {
+	return fmt.Sprintf("model %q, method %q, selector %q: %s", e.Model, e.Method, e.ID, e.Err)
+}
+
+// toSpec converts the readable spec; onError is called for each problem
+// of the selectors, and the selectors are converted as far as possible
+// (i.e. without the element refs that cannot be resolved).
+func (in *readableSpec) toSpec(loader PackageLoader, onError func(*readableSelectorError)) *XSpec {
+	spec := newXSpec()
+	spec.Name = in.Name
+	spec.Models = make([]*XModel, 0, len(in.Models))
+	for modelIndex, rMdl := range in.Models {
+		mdl := &XModel{
+			Name:    rMdl.Name,
+			Kind:    rMdl.Kind,
+			Methods: make(XMethodSlice, 0, len(rMdl.Methods)),
+		}
+		for methodIndex, rMtd := range rMdl.Methods {
+			mtd := &XMethod{
+				Name:        rMtd.Name,
+				Description: rMtd.Description,
+				Selectors:   make([]*XSelector, 0, len(rMtd.Selectors)),
+			}
+			for selectorIndex, rSel := range rMtd.Selectors {
+				qualifierPointer := JSONPointer("Models", modelIndex, "Methods", methodIndex, "Selectors", selectorIndex, "Qualifier")
+				sel := rSel.toSelector(loader, func(pointer string, err error, loadFunc bool) {
+					onError(&readableSelectorError{
+						Model:    rMdl.Name,
+						Method:   rMtd.Name,
+						ID:       rSel.ID,
+						Pointer:  qualifierPointer + pointer,
+						Err:      err,
+						LoadFunc: loadFunc,
+					})
+				})
+				mtd.Selectors = append(mtd.Selectors, sel)
+			}
+			mdl.Methods = append(mdl.Methods, mtd)
+		}
+		spec.Models = append(spec.Models, mdl)
+	}
+	return spec
+}
+
+// toSelector converts the selector; onError is called for each problem,
+// with the JSON pointer relative to the qualifier.
+func (rSel *readableSelector) toSelector(loader PackageLoader, onError func(pointer string, err error, loadFunc bool)) *XSelector {
+	basicQual := BasicQualifier{
+		Path:    rSel.Path,
+		Version: rSel.Version,
+		ID:      rSel.ID,
+	}
+
+	switch rSel.Kind {
+	case SelectorKindFunc:
+		qual := &FuncQualifier{
+			BasicQualifier: basicQual,
+			Name:           rSel.Name,
+			ContentType:    rSel.ContentType,
+		}
+		sel := &XSelector{Kind: SelectorKindFunc, Qualifier: qual}
+		fn, err := loadFunc(&basicQual, loader)
+		if err != nil {
+			onError("/ID", err, true)
+			return sel
+		}
+		qual.Pos = parseElementRefs(fn, rSel.Pos, func(refIndex int, err error) {
+			onError("/Pos", fmt.Errorf("pos %v: %s", refIndex, err), false)
+		})
+		if rSel.Flows != nil {
+			qual.Flows = &FlowSpec{
+				Enabled: rSel.Flows.Enabled,
+				Blocks:  make([]*FlowBlock, 0, len(rSel.Flows.Blocks)),
+			}
+			for blockIndex, rBlock := range rSel.Flows.Blocks {
+				blockIndex := blockIndex
+				blockPointer := JSONPointer("Flows", "Blocks", blockIndex)
+				block := &FlowBlock{}
+				block.Inp = parseElementRefs(fn, rBlock.Inp, func(refIndex int, err error) {
+					onError(blockPointer+"/Inp", fmt.Errorf("flow block %v: inp %v: %s", blockIndex, refIndex, err), false)
+				})
+				block.Out = parseElementRefs(fn, rBlock.Out, func(refIndex int, err error) {
+					onError(blockPointer+"/Out", fmt.Errorf("flow block %v: out %v: %s", blockIndex, refIndex, err), false)
+				})
+				// The blocks always have one position per element (see ValidateFlowBlocks):
+				if block.Inp == nil {
+					block.Inp = make([]bool, fn.Len())
+				}
+				if block.Out == nil {
+					block.Out = make([]bool, fn.Len())
+				}
+				qual.Flows.Blocks = append(qual.Flows.Blocks, block)
+			}
+		}
+		return sel
+	case SelectorKindStruct:
+		qual := &StructQualifier{
+			BasicQualifier: basicQual,
+			TypeName:       rSel.TypeName,
+			Fields:         make(map[string]*FieldMeta),
+		}
+		for _, name := range rSel.Fields {
+			qual.Fields[name] = nil
+		}
+		return &XSelector{Kind: SelectorKindStruct, Qualifier: qual}
+	case SelectorKindType:
+		qual := &TypeQualifier{
+			BasicQualifier: basicQual,
+			TypeName:       rSel.TypeName,
+			Value:          rSel.Value,
+		}
+		return &XSelector{Kind: SelectorKindType, Qualifier: qual}
+	default:
+		onError("", fmt.Errorf("selector kind not valid: %q", rSel.Kind), false)
+		// Keep the selector, so that the indexes of the selectors are the same.
+		return &XSelector{Kind: rSel.Kind, Qualifier: &FuncQualifier{BasicQualifier: basicQual}}
+	}
+}
+
+// loadFunc loads the source (if not already cached),
+// and returns the func of the qualifier.
+func loadFunc(qual *BasicQualifier, loader PackageLoader) (FuncInterface, error) {
+	source := GetCachedSource(qual.Path, qual.Version)
+	if source == nil {
+		if loader == nil {
+			return nil, fmt.Errorf("source not found: %s", qual.PathVersion())
+		}
+		var err error
+		source, err = loader(qual.Path, qual.Version)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading package %s: %s", qual.PathVersion(), err)
+		}
+	}
+	fn := FindFuncByID(source, qual.ID)
+	if fn == nil {
+		return nil, fmt.Errorf("func not found: %q", qual.ID)
+	}
+	return fn, nil
+}
+
+// ParseElementRefs returns the positions (one per element of the func)
+// where the elements referenced by the provided refs are set to true,
+// or nil if there are no refs; see FormatElementRef for the format of the refs.
+func ParseElementRefs(fn FuncInterface, refs []string) ([]bool, error) {
+	var firstErr error
+	positions := parseElementRefs(fn, refs, func(refIndex int, err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return positions, nil
+}
+
+// parseElementRefs is like ParseElementRefs, but calls onError
+// for each ref that is not valid (and skips it).
+func parseElementRefs(fn FuncInterface, refs []string, onError func(refIndex int, err error)) []bool {
+	if len(refs) == 0 {
+		return nil
+	}
+	positions := make([]bool, fn.Len())
+	for refIndex, ref := range refs {
+		index, err := ParseElementRef(fn, ref)
+		if err != nil {
+			onError(refIndex, err)
+			continue
+		}
+		positions[index] = true
+	}
+	return positions
+}
+
+// ParseElementRef returns the absolute index of the element of the func
+// referenced by the provided ref (the inverse of FormatElementRef).
+func ParseElementRef(fn FuncInterface, ref string) (int, error) {
+	lenReceiver, lenParams, lenResults := fn.Lengths()
+	ref = strings.TrimSpace(ref)
+
+	if strings.HasPrefix(ref, "#") {
+		index, err := strconv.Atoi(strings.TrimPrefix(ref, "#"))
+		if err != nil || index < 0 || index >= fn.Len() {
+			return 0, fmt.Errorf("element %q not valid", ref)
+		}
+		return index, nil
+	}
+	if ref == "receiver" {
+		if lenReceiver == 0 {
+			return 0, errors.New("func has no receiver")
+		}
+		return 0, nil
+	}
+
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, fmt.Errorf("element %q not valid; must be `receiver`, `param:<name or index>`, or `result:<index>`", ref)
+	}
+	role, name := parts[0], parts[1]
+	relIndex, indexErr := strconv.Atoi(name)
+
+	switch role {
+	case "param":
+		if indexErr != nil {
+			relIndex = -1
+			for i, param := range fn.GetFunc().Parameters {
+				if param.VarName == name {
+					relIndex = i
+					break
+				}
+			}
+			if relIndex < 0 {
+				return 0, fmt.Errorf("param %q not found", name)
+			}
+		}
+		if relIndex < 0 || relIndex >= lenParams {
+			return 0, fmt.Errorf("param index %v out of bounds", relIndex)
+		}
+		return lenReceiver + relIndex, nil
+	case "result":
+		if indexErr != nil || relIndex < 0 || relIndex >= lenResults {
+			return 0, fmt.Errorf("result %q not valid", name)
+		}
+		return lenReceiver + lenParams + relIndex, nil
+	default:
+		return 0, fmt.Errorf("element role %q not valid", role)
+	}
+}
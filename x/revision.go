@@ -0,0 +1,46 @@
+package x
+
+import (
+	"strings"
+	"sync/atomic"
+
+	. "github.com/gagliardetto/utilz"
+)
+
+// revisionCounter is the last revision assigned to a spec;
+// the revisions are unique across all the specs.
+var revisionCounter uint64
+
+// Revision returns the revision of the spec, i.e. a number that
+// increases every time the spec is modified (see BumpRevision).
+func (spec *XSpec) Revision() uint64 {
+	if rev := atomic.LoadUint64(&spec.revision); rev != 0 {
+		return rev
+	}
+	atomic.CompareAndSwapUint64(&spec.revision, 0, atomic.AddUint64(&revisionCounter, 1))
+	return atomic.LoadUint64(&spec.revision)
+}
+
+// BumpRevision assigns a new revision to the spec, and returns it.
+func (spec *XSpec) BumpRevision() uint64 {
+	rev := atomic.AddUint64(&revisionCounter, 1)
+	atomic.StoreUint64(&spec.revision, rev)
+	return rev
+}
+
+// ETag returns the revision of the spec formatted as an HTTP entity tag.
+func (spec *XSpec) ETag() string {
+	return Sf("%q", Sf("%v", spec.Revision()))
+}
+
+// ETagMatches returns true if the value of an If-Match (or If-None-Match)
+// header matches the provided entity tag.
+func ETagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,462 @@
+package x
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gagliardetto/codebox/scanner"
+	"github.com/gagliardetto/feparser"
+	"github.com/gagliardetto/golang-go/cmd/go/not-internal/search"
+	. "github.com/gagliardetto/utilz"
+	"golang.org/x/mod/modfile"
+)
+
+// The stubs replace the `depstubber` step: the packages used by the
+// generated tests are written in the vendor/ folder as stubs, i.e. with
+// the exported declarations (and the types they reference), and with
+// empty func bodies; this way the test folder can be compiled and
+// extracted without downloading the dependencies.
+
+// StubsGoVersion is the go version of the generated go.mod files;
+// from go1.14, the vendor/ folder is used by default.
+const StubsGoVersion = "1.14"
+
+// VendorStubber collects the elements to be stubbed.
+type VendorStubber struct {
+	packages map[string]*stubPackage
+	seen     map[types.Type]bool
+}
+
+type stubPackage struct {
+	Path  string
+	Name  string
+	Named map[string]*types.Named
+	Funcs map[string]*types.Signature
+}
+
+func NewVendorStubber() *VendorStubber {
+	return &VendorStubber{
+		packages: make(map[string]*stubPackage),
+		seen:     make(map[types.Type]bool),
+	}
+}
+
+func (vs *VendorStubber) getPackage(path string, name string) *stubPackage {
+	pkg, ok := vs.packages[path]
+	if !ok {
+		pkg = &stubPackage{
+			Path:  path,
+			Name:  name,
+			Named: make(map[string]*types.Named),
+			Funcs: make(map[string]*types.Signature),
+		}
+		vs.packages[path] = pkg
+	}
+	return pkg
+}
+
+// AddSelector adds the element selected by the selector
+// (and all the types it references).
+func (vs *VendorStubber) AddSelector(sel *XSelector) error {
+	basicQual := sel.GetBasicQualifier()
+	if search.IsStandardImportPath(basicQual.Path) {
+		return nil
+	}
+	source := GetCachedSource(basicQual.Path, basicQual.Version)
+	if source == nil {
+		return fmt.Errorf("Source not found: %s@%s", basicQual.Path, basicQual.Version)
+	}
+
+	switch sel.Qualifier.(type) {
+	case *FuncQualifier:
+		fn := FindFuncByID(source, basicQual.ID)
+		if fn == nil {
+			return fmt.Errorf("Func not found: %q", basicQual.ID)
+		}
+		switch thing := fn.(type) {
+		case *feparser.FEFunc:
+			sig, ok := thing.GetOriginal().GetType().(*types.Signature)
+			if !ok {
+				return fmt.Errorf("Func %q has no signature", basicQual.ID)
+			}
+			vs.getPackage(scanner.StringRemoveGoPath(thing.PkgPath), thing.PkgName).Funcs[thing.Name] = sig
+			vs.AddType(sig)
+		case *feparser.FETypeMethod:
+			vs.AddType(thing.Receiver.GetOriginal())
+		case *feparser.FEInterfaceMethod:
+			vs.AddType(thing.Receiver.GetOriginal())
+		default:
+			panic(Sf("Unknown type: %T", fn))
+		}
+	case *StructQualifier:
+		st := FindStructByID(source, basicQual.ID)
+		if st == nil {
+			return fmt.Errorf("Struct not found: %q", basicQual.ID)
+		}
+		vs.AddType(st.GetOriginal().Type)
+	case *TypeQualifier:
+		typ := FindTypeByID(source, basicQual.ID)
+		if typ == nil {
+			return fmt.Errorf("Type not found: %q", basicQual.ID)
+		}
+		vs.AddType(typ.GetOriginal().GetType())
+	default:
+		panic(Sf("Unknown type: %T", sel.Qualifier))
+	}
+	return nil
+}
+
+// AddType adds the named types referenced by the provided type
+// (and, recursively, the types referenced by their declarations).
+func (vs *VendorStubber) AddType(typ types.Type) {
+	if typ == nil || vs.seen[typ] {
+		return
+	}
+	vs.seen[typ] = true
+
+	switch t := typ.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil || search.IsStandardImportPath(obj.Pkg().Path()) {
+			return
+		}
+		vs.getPackage(obj.Pkg().Path(), obj.Pkg().Name()).Named[obj.Name()] = t
+		vs.AddType(t.Underlying())
+		for _, method := range stubMethods(t) {
+			vs.AddType(method.Type())
+		}
+	case *types.Pointer:
+		vs.AddType(t.Elem())
+	case *types.Slice:
+		vs.AddType(t.Elem())
+	case *types.Array:
+		vs.AddType(t.Elem())
+	case *types.Chan:
+		vs.AddType(t.Elem())
+	case *types.Map:
+		vs.AddType(t.Key())
+		vs.AddType(t.Elem())
+	case *types.Signature:
+		vs.AddType(t.Params())
+		vs.AddType(t.Results())
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			vs.AddType(t.At(i).Type())
+		}
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if t.Field(i).Exported() {
+				vs.AddType(t.Field(i).Type())
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			vs.AddType(t.ExplicitMethod(i).Type())
+		}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			vs.AddType(t.EmbeddedType(i))
+		}
+	}
+}
+
+// stubMethods returns the methods that will be declared on the
+// stub of the (non-interface) named type: the exported methods
+// of its method set (including the promoted ones), and the
+// unexported methods declared on the type itself.
+func stubMethods(named *types.Named) []*types.Func {
+	if _, isInterface := named.Underlying().(*types.Interface); isInterface {
+		return nil
+	}
+	methods := make([]*types.Func, 0)
+	mset := types.NewMethodSet(types.NewPointer(named))
+	for i := 0; i < mset.Len(); i++ {
+		method, ok := mset.At(i).Obj().(*types.Func)
+		if !ok {
+			continue
+		}
+		if method.Exported() || len(mset.At(i).Index()) == 1 {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// WriteVendoredGoModule writes to the provided folder of the OutputFS a go.mod file,
+// and the vendor/ folder with the stubs of the elements selected by
+// the model in the provided package versions.
+func WriteVendoredGoModule(out OutputFS, outDir string, mdl *XModel, pathVersions ...string) error {
+	vs := NewVendorStubber()
+	for _, mt := range mdl.Methods {
+		for _, sel := range mt.Selectors {
+			if !SliceContains(pathVersions, sel.GetBasicQualifier().PathVersion()) {
+				continue
+			}
+			if err := vs.AddSelector(sel); err != nil {
+				return err
+			}
+		}
+	}
+	return vs.Write(out, outDir, pathVersions...)
+}
+
+type stubModule struct {
+	Path     string
+	Version  string
+	Packages []string
+}
+
+// Write writes the go.mod file, and the stubs and
+// modules.txt file in the vendor/ folder, in the outDir folder of the OutputFS.
+func (vs *VendorStubber) Write(out OutputFS, outDir string, pathVersions ...string) error {
+	modules := vs.groupByModule(pathVersions)
+
+	{
+		// Write the stubs:
+		for _, pkg := range vs.packages {
+			src, err := pkg.Render()
+			if err != nil {
+				return fmt.Errorf("error while rendering stub of %s: %s", pkg.Path, err)
+			}
+			pkgDir := filepath.Join(outDir, "vendor", filepath.FromSlash(pkg.Path))
+			if err := out.MkdirAll(pkgDir); err != nil {
+				return err
+			}
+			stubFilepath := filepath.Join(pkgDir, "stub.go")
+			if err := out.WriteFile(stubFilepath, src); err != nil {
+				return err
+			}
+		}
+	}
+
+	mf := &modfile.File{}
+	// TODO: change statement path?
+	mf.AddModuleStmt("example.com/hello/world")
+	mf.AddGoStmt(StubsGoVersion)
+
+	var modulesTxt bytes.Buffer
+	for _, mod := range modules {
+		mf.AddNewRequire(mod.Path, mod.Version, false)
+
+		fmt.Fprintf(&modulesTxt, "# %s %s\n", mod.Path, mod.Version)
+		fmt.Fprintln(&modulesTxt, "## explicit")
+		for _, pkgPath := range mod.Packages {
+			fmt.Fprintln(&modulesTxt, pkgPath)
+		}
+	}
+	mf.Cleanup()
+
+	mfBytes, err := mf.Format()
+	if err != nil {
+		return err
+	}
+	// Write `go.mod` file:
+	goModFilepath := filepath.Join(outDir, "go.mod")
+	Infof("Saving go.mod to %q", goModFilepath)
+	if err := out.WriteFile(goModFilepath, mfBytes); err != nil {
+		return err
+	}
+
+	if len(modules) > 0 {
+		modulesTxtFilepath := filepath.Join(outDir, "vendor", "modules.txt")
+		Infof("Saving stubs to %q", filepath.Dir(modulesTxtFilepath))
+		if err := out.WriteFile(modulesTxtFilepath, modulesTxt.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupByModule groups the stubbed packages by module;
+// the modules of the provided pathVersions take precedence
+// over the other modules in the cache.
+func (vs *VendorStubber) groupByModule(pathVersions []string) []*stubModule {
+	knownModules := make([]*feparser.Module, 0)
+	addModulesOf := func(path string, version string) {
+		source := GetCachedSource(path, version)
+		if source == nil || source.Module == nil || source.Module.Version == "" {
+			return
+		}
+		for _, known := range knownModules {
+			if known.Path == source.Module.Path {
+				return
+			}
+		}
+		knownModules = append(knownModules, source.Module)
+	}
+	for _, pathVersion := range pathVersions {
+		path, version := scanner.SplitPathVersion(pathVersion)
+		addModulesOf(path, version)
+	}
+	for _, pv := range GetListCachedSources() {
+		addModulesOf(pv.Path, pv.Version)
+	}
+
+	byPath := make(map[string]*stubModule)
+	for pkgPath := range vs.packages {
+		var mod *feparser.Module
+		for _, known := range knownModules {
+			if pkgPath == known.Path || strings.HasPrefix(pkgPath, known.Path+"/") {
+				if mod == nil || len(known.Path) > len(mod.Path) {
+					mod = known
+				}
+			}
+		}
+		modPath, version := pkgPath, "v0.0.0"
+		if mod != nil {
+			modPath, version = mod.Path, mod.Version
+		} else {
+			Warnf("Module of package %s is not known; using %s %s", pkgPath, modPath, version)
+		}
+		stubMod, ok := byPath[modPath]
+		if !ok {
+			stubMod = &stubModule{Path: modPath, Version: version}
+			byPath[modPath] = stubMod
+		}
+		stubMod.Packages = append(stubMod.Packages, pkgPath)
+	}
+
+	modules := make([]*stubModule, 0, len(byPath))
+	for _, mod := range byPath {
+		sort.Strings(mod.Packages)
+		modules = append(modules, mod)
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Path < modules[j].Path
+	})
+	return modules
+}
+
+// Render returns the formatted source of the stub of the package.
+func (pkg *stubPackage) Render() ([]byte, error) {
+	imports := make(map[string]string) // path -> name
+	usedNames := map[string]bool{pkg.Name: true}
+	qualifier := func(other *types.Package) string {
+		if other.Path() == pkg.Path {
+			return ""
+		}
+		if name, ok := imports[other.Path()]; ok {
+			return name
+		}
+		name := other.Name()
+		for i := 2; usedNames[name]; i++ {
+			name = Sf("%s%v", other.Name(), i)
+		}
+		usedNames[name] = true
+		imports[other.Path()] = name
+		return name
+	}
+
+	var body bytes.Buffer
+	for _, name := range sortedKeysOfNamed(pkg.Named) {
+		named := pkg.Named[name]
+		fmt.Fprintf(&body, "type %s %s\n\n", name, stubTypeString(named.Underlying(), qualifier))
+
+		valueMethods := types.NewMethodSet(named)
+		for _, method := range stubMethods(named) {
+			recv := name
+			if valueMethods.Lookup(method.Pkg(), method.Name()) == nil {
+				recv = "*" + name
+			}
+			fmt.Fprintf(&body, "func (_ %s) %s%s {\n\treturn\n}\n\n", recv, method.Name(), stubSignature(method.Type().(*types.Signature), qualifier))
+		}
+	}
+	funcNames := make([]string, 0, len(pkg.Funcs))
+	for name := range pkg.Funcs {
+		funcNames = append(funcNames, name)
+	}
+	sort.Strings(funcNames)
+	for _, name := range funcNames {
+		fmt.Fprintf(&body, "func %s%s {\n\treturn\n}\n\n", name, stubSignature(pkg.Funcs[name], qualifier))
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintln(&src, "// Code generated by https://github.com/gagliardetto/codemill. DO NOT EDIT.")
+	fmt.Fprintf(&src, "\n// Package %s is a stub of %s, used for testing.\n", pkg.Name, pkg.Path)
+	fmt.Fprintf(&src, "package %s\n\n", pkg.Name)
+	if len(imports) > 0 {
+		paths := make([]string, 0, len(imports))
+		for path := range imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Fprintln(&src, "import (")
+		for _, path := range paths {
+			fmt.Fprintf(&src, "\t%s %q\n", imports[path], path)
+		}
+		fmt.Fprintln(&src, ")")
+	}
+	src.Write(body.Bytes())
+
+	return format.Source(src.Bytes())
+}
+
+func sortedKeysOfNamed(m map[string]*types.Named) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stubTypeString returns the type as a string;
+// the unexported fields of structs are omitted.
+func stubTypeString(typ types.Type, qualifier types.Qualifier) string {
+	st, ok := typ.(*types.Struct)
+	if !ok {
+		return types.TypeString(typ, qualifier)
+	}
+	fields := make([]string, 0, st.NumFields())
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		if !field.Exported() {
+			continue
+		}
+		var decl string
+		if field.Embedded() {
+			decl = stubTypeString(field.Type(), qualifier)
+		} else {
+			decl = field.Name() + " " + stubTypeString(field.Type(), qualifier)
+		}
+		if tag := st.Tag(i); tag != "" {
+			if strings.Contains(tag, "`") {
+				decl += " " + Sf("%q", tag)
+			} else {
+				decl += " `" + tag + "`"
+			}
+		}
+		fields = append(fields, decl)
+	}
+	if len(fields) == 0 {
+		return "struct{}"
+	}
+	return "struct {\n" + strings.Join(fields, "\n") + "\n}"
+}
+
+// stubSignature returns the params and results of the signature,
+// with blank names (so that the body can be just a `return`).
+func stubSignature(sig *types.Signature, qualifier types.Qualifier) string {
+	params := make([]string, 0, sig.Params().Len())
+	for i := 0; i < sig.Params().Len(); i++ {
+		typ := sig.Params().At(i).Type()
+		if slice, ok := typ.(*types.Slice); ok && sig.Variadic() && i == sig.Params().Len()-1 {
+			params = append(params, "_ ..."+stubTypeString(slice.Elem(), qualifier))
+			continue
+		}
+		params = append(params, "_ "+stubTypeString(typ, qualifier))
+	}
+	results := make([]string, 0, sig.Results().Len())
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, "_ "+stubTypeString(sig.Results().At(i).Type(), qualifier))
+	}
+	res := "(" + strings.Join(params, ", ") + ")"
+	if len(results) > 0 {
+		res += " (" + strings.Join(results, ", ") + ")"
+	}
+	return res
+}
@@ -0,0 +1,321 @@
+package x
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dave/jennifer/jen"
+	"github.com/gagliardetto/codebox/scanner"
+	"github.com/gagliardetto/feparser"
+	"github.com/gagliardetto/golang-go/cmd/go/not-internal/search"
+	. "github.com/gagliardetto/utilz"
+)
+
+// TypeCheckGeneratedGo enables the type-checking of the generated go tests
+// before they are written.
+var TypeCheckGeneratedGo = true
+
+// GoTestError is a type error in a generated go test.
+type GoTestError struct {
+	Model    string
+	Method   string     // Empty if the selector is not known.
+	Selector *XSelector // Nil if the selector is not known.
+	Position token.Position
+	Message  string
+	Snippet  string
+}
+
+func (gte *GoTestError) Error() string {
+	var culprit string
+	if gte.Selector != nil {
+		basicQual := gte.Selector.GetBasicQualifier()
+		culprit = Sf("method %q, selector %q of %s", gte.Method, basicQual.ID, basicQual.PathVersion())
+	} else {
+		culprit = "unknown selector"
+	}
+	return Sf(
+		"model %q, %s: line %v: %s\n%s",
+		gte.Model,
+		culprit,
+		gte.Position.Line,
+		gte.Message,
+		gte.Snippet,
+	)
+}
+
+// GoTestErrors is the list of type errors of a generated go test.
+type GoTestErrors []*GoTestError
+
+func (errs GoTestErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return Sf("%v type error(s) in generated go test:\n%s", len(errs), strings.Join(msgs, "\n"))
+}
+
+// TypeCheckGoTest type-checks the generated go test file against
+// the loaded packages selected by the model in the provided pathVersions;
+// if there are type errors, a GoTestErrors is returned, where each error
+// is attributed to the selector that produced the broken test case.
+func TypeCheckGoTest(file *jen.File, mdl *XModel, pathVersions ...string) error {
+	if !TypeCheckGeneratedGo {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := file.Render(&buf); err != nil {
+		return fmt.Errorf("error while rendering go file: %s", err)
+	}
+	src := buf.Bytes()
+
+	imp, err := newLoadedPackagesImporter(pathVersions)
+	if err != nil {
+		Warnf("Skipping type-check of tests of model %q: %s", mdl.Name, err)
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("error while parsing generated go file: %s", err)
+	}
+
+	typeErrors := make([]types.Error, 0)
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok && !typeErr.Soft {
+				typeErrors = append(typeErrors, typeErr)
+			}
+		},
+	}
+	conf.Check("main", fset, []*ast.File{astFile}, nil)
+
+	if len(typeErrors) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(string(src), "\n")
+	keys := newTestCaseKeys(mdl, pathVersions)
+	errs := make(GoTestErrors, 0, len(typeErrors))
+	for _, typeErr := range typeErrors {
+		pos := typeErr.Fset.Position(typeErr.Pos)
+		gte := &GoTestError{
+			Model:    mdl.Name,
+			Position: pos,
+			Message:  typeErr.Msg,
+			Snippet:  formatSnippet(lines, pos.Line, 2),
+		}
+		if key := keys.Attribute(lines, pos.Line); key != nil {
+			gte.Method = key.Method
+			gte.Selector = key.Selector
+		}
+		errs = append(errs, gte)
+	}
+	return errs
+}
+
+// formatSnippet returns the line (1-based) with the provided number of
+// lines of context before it.
+func formatSnippet(lines []string, line int, context int) string {
+	var buf strings.Builder
+	for i := line - context; i <= line; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := "  "
+		if i == line {
+			marker = "> "
+		}
+		fmt.Fprintf(&buf, "\t%s%4d | %s\n", marker, i, strings.TrimRight(lines[i-1], " \t"))
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+type testCaseKey struct {
+	Method   string
+	Selector *XSelector
+	// Comment is the comment that precedes the test
+	// case of the selector (i.e. the signature of funcs).
+	Comment string
+	// Name is the name of the element (func, struct, type).
+	Name *regexp.Regexp
+}
+
+type testCaseKeys []*testCaseKey
+
+func newTestCaseKeys(mdl *XModel, pathVersions []string) testCaseKeys {
+	keys := make(testCaseKeys, 0)
+	for _, mt := range mdl.Methods {
+		for _, sel := range mt.Selectors {
+			basicQual := sel.GetBasicQualifier()
+			if !SliceContains(pathVersions, basicQual.PathVersion()) {
+				continue
+			}
+			source := GetCachedSource(basicQual.Path, basicQual.Version)
+			if source == nil {
+				continue
+			}
+			key := &testCaseKey{
+				Method:   mt.Name,
+				Selector: sel,
+			}
+			switch qual := sel.Qualifier.(type) {
+			case *FuncQualifier:
+				fn := FindFuncByID(source, qual.ID)
+				if fn == nil {
+					continue
+				}
+				key.Comment = fn.GetFunc().Signature
+				key.Name = regexp.MustCompile(`\.` + regexp.QuoteMeta(GetFuncName(fn)) + `\b`)
+			case *StructQualifier:
+				st := FindStructByID(source, qual.ID)
+				if st == nil {
+					continue
+				}
+				key.Comment = st.QualifiedName + " struct"
+				key.Name = regexp.MustCompile(`\.` + regexp.QuoteMeta(st.TypeName) + `\b`)
+			case *TypeQualifier:
+				key.Name = regexp.MustCompile(`\.` + regexp.QuoteMeta(qual.TypeName) + `\b`)
+			default:
+				panic(Sf("Unknown type: %T", sel.Qualifier))
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Attribute returns the key of the selector that produced the
+// test case at the provided line (1-based): that is the selector of
+// the closest comment before the line (within the same top-level func),
+// or else the selector whose element is named on the line.
+func (keys testCaseKeys) Attribute(lines []string, line int) *testCaseKey {
+	for i := line; i >= 1 && i <= len(lines); i-- {
+		text := strings.TrimSpace(lines[i-1])
+		if strings.HasPrefix(lines[i-1], "func ") && i != line {
+			break
+		}
+		if i < line && strings.HasPrefix(lines[i], "func ") {
+			// The line is the declaration of a top-level func.
+			break
+		}
+		if !strings.HasPrefix(text, "//") {
+			continue
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(text, "//"))
+		for _, key := range keys {
+			if key.Comment != "" && (comment == key.Comment || strings.Contains(comment, key.Comment)) {
+				return key
+			}
+		}
+	}
+	if line >= 1 && line <= len(lines) {
+		for _, key := range keys {
+			if key.Name.MatchString(lines[line-1]) {
+				return key
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	sourceImporter   types.Importer
+	sourceImporterMu = &sync.Mutex{}
+)
+
+// loadedPackagesImporter imports the packages already loaded by
+// codemill (and their dependencies); the other standard library
+// packages are imported from source.
+type loadedPackagesImporter struct {
+	packages map[string]*types.Package
+}
+
+func newLoadedPackagesImporter(pathVersions []string) (*loadedPackagesImporter, error) {
+	imp := &loadedPackagesImporter{
+		packages: make(map[string]*types.Package),
+	}
+	for _, pathVersion := range pathVersions {
+		path, version := scanner.SplitPathVersion(pathVersion)
+		if search.IsStandardImportPath(path) {
+			continue
+		}
+		source := GetCachedSource(path, version)
+		if source == nil {
+			return nil, fmt.Errorf("Source not found: %s", pathVersion)
+		}
+		pkg := typesPackageOf(source)
+		if pkg == nil {
+			return nil, fmt.Errorf("types of package %s are not available", pathVersion)
+		}
+		imp.add(pkg)
+	}
+	return imp, nil
+}
+
+func (imp *loadedPackagesImporter) add(pkg *types.Package) {
+	if _, ok := imp.packages[pkg.Path()]; ok {
+		return
+	}
+	imp.packages[pkg.Path()] = pkg
+	for _, dep := range pkg.Imports() {
+		imp.add(dep)
+	}
+}
+
+func (imp *loadedPackagesImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp.packages[path]; ok {
+		return pkg, nil
+	}
+	if !search.IsStandardImportPath(path) {
+		return nil, fmt.Errorf("package %s is not loaded", path)
+	}
+	sourceImporterMu.Lock()
+	defer sourceImporterMu.Unlock()
+	if sourceImporter == nil {
+		sourceImporter = importer.ForCompiler(token.NewFileSet(), "source", nil)
+	}
+	return sourceImporter.Import(path)
+}
+
+// typesPackageOf returns the go/types package of the provided
+// loaded package, or nil if no element of the package has types.
+func typesPackageOf(source *feparser.FEPackage) *types.Package {
+	isThePackage := func(pkg *types.Package) bool {
+		return pkg != nil && pkg.Path() == source.PkgPath
+	}
+	for _, typ := range source.Types {
+		if typ.GetOriginal() == nil {
+			continue
+		}
+		if named, ok := typ.GetOriginal().GetType().(*types.Named); ok && isThePackage(named.Obj().Pkg()) {
+			return named.Obj().Pkg()
+		}
+	}
+	for _, mt := range source.TypeMethods {
+		if named, ok := mt.Receiver.GetOriginal().(*types.Named); ok && isThePackage(named.Obj().Pkg()) {
+			return named.Obj().Pkg()
+		}
+	}
+	for _, fn := range source.Funcs {
+		if fn.GetOriginal() == nil {
+			continue
+		}
+		for _, param := range append(fn.GetOriginal().Input, fn.GetOriginal().Output...) {
+			if v := param.GetTypesVar(); v != nil && isThePackage(v.Pkg()) {
+				return v.Pkg()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package x
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// OutputFS is the folder to which the generators write their assets;
+// the paths are relative to its root.
+type OutputFS interface {
+	// MkdirAll creates the folder (and its parents) if it does not exist.
+	MkdirAll(path string) error
+	// WriteFile writes the file, creating it if it does not exist.
+	WriteFile(path string, data []byte) error
+}
+
+// DirFS is an OutputFS that writes the files to disk,
+// in the folder at the provided path.
+type DirFS string
+
+var _ OutputFS = DirFS("")
+
+func (dir DirFS) MkdirAll(path string) error {
+	return os.MkdirAll(filepath.Join(string(dir), path), os.ModePerm)
+}
+
+func (dir DirFS) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(filepath.Join(string(dir), path), data, 0666)
+}
+
+// VirtualFS is an OutputFS that keeps the files in memory
+// (e.g. to preview the generated assets).
+type VirtualFS struct {
+	mu    *sync.RWMutex
+	files map[string][]byte
+}
+
+var _ OutputFS = &VirtualFS{}
+
+// NewVirtualFS returns a new empty VirtualFS.
+func NewVirtualFS() *VirtualFS {
+	return &VirtualFS{
+		mu:    &sync.RWMutex{},
+		files: make(map[string][]byte),
+	}
+}
+
+// MkdirAll does nothing: the folders of a VirtualFS are implicit.
+func (vfs *VirtualFS) MkdirAll(path string) error {
+	return nil
+}
+
+func (vfs *VirtualFS) WriteFile(path string, data []byte) error {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	vfs.files[filepath.ToSlash(filepath.Clean(path))] = append([]byte(nil), data...)
+	return nil
+}
+
+// Paths returns the sorted paths (slash-separated) of the files.
+func (vfs *VirtualFS) Paths() []string {
+	vfs.mu.RLock()
+	defer vfs.mu.RUnlock()
+	paths := make([]string, 0, len(vfs.files))
+	for path := range vfs.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ReadFile returns the content of the file at the provided path.
+func (vfs *VirtualFS) ReadFile(path string) ([]byte, bool) {
+	vfs.mu.RLock()
+	defer vfs.mu.RUnlock()
+	content, ok := vfs.files[filepath.ToSlash(filepath.Clean(path))]
+	return content, ok
+}
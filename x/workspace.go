@@ -0,0 +1,215 @@
+package x
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/gagliardetto/utilz"
+)
+
+// Workspace is a set of spec files served by one codemill process;
+// the specs are loaded on demand, and share the cache of the loaded packages.
+// One of the specs is the active one (i.e. the one edited in the UI).
+type Workspace struct {
+	// Dir is the folder of the spec files; empty if the
+	// workspace has only the spec provided with --spec.
+	Dir    string
+	loader PackageLoader
+
+	mu      *sync.RWMutex
+	entries map[string]*WorkspaceEntry
+	active  string
+}
+
+// WorkspaceEntry is a spec file of a workspace.
+type WorkspaceEntry struct {
+	File     string // File is the name of the spec file, and the ID of the entry.
+	Filepath string `json:"-"`
+
+	mu   *sync.RWMutex
+	spec *XSpec
+}
+
+// newWorkspaceEntry returns the entry of the spec
+// file at the provided path; spec can be nil.
+func newWorkspaceEntry(path string, spec *XSpec) *WorkspaceEntry {
+	return &WorkspaceEntry{
+		File:     filepath.Base(path),
+		Filepath: path,
+		mu:       &sync.RWMutex{},
+		spec:     spec,
+	}
+}
+
+// Spec returns the spec of the entry, or nil if not loaded yet.
+func (entry *WorkspaceEntry) Spec() *XSpec {
+	entry.mu.RLock()
+	defer entry.mu.RUnlock()
+	return entry.spec
+}
+
+// NewWorkspace returns a new empty workspace.
+func NewWorkspace(loader PackageLoader) *Workspace {
+	return &Workspace{
+		loader:  loader,
+		mu:      &sync.RWMutex{},
+		entries: make(map[string]*WorkspaceEntry),
+	}
+}
+
+// OpenWorkspace returns the workspace of the spec files (.json, .yaml, .yml)
+// in the provided folder; no spec is loaded.
+func OpenWorkspace(dir string, loader PackageLoader) (*Workspace, error) {
+	ws := NewWorkspace(loader)
+	ws.Dir = MustAbs(dir)
+
+	MustCreateFolderIfNotExists(ws.Dir, 0750)
+	files, err := ioutil.ReadDir(ws.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !IsSpecFilename(file.Name()) {
+			continue
+		}
+		ws.entries[file.Name()] = newWorkspaceEntry(filepath.Join(ws.Dir, file.Name()), nil)
+	}
+	return ws, nil
+}
+
+// IsSpecFilename returns true if the file has the extension of a spec file.
+func IsSpecFilename(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// AddSpec adds an already loaded spec to the workspace.
+func (ws *Workspace) AddSpec(path string, spec *XSpec) *WorkspaceEntry {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	entry := newWorkspaceEntry(path, spec)
+	ws.entries[entry.File] = entry
+	return entry
+}
+
+// CreateSpec creates a new spec with the provided name in the folder
+// of the workspace (the file is written when the spec is saved).
+func (ws *Workspace) CreateSpec(name string) (*WorkspaceEntry, error) {
+	if ws.Dir == "" {
+		return nil, fmt.Errorf("the workspace has no folder")
+	}
+	name = ToCamel(name)
+	if name == "" {
+		return nil, fmt.Errorf("Spec name not valid")
+	}
+	file := name + ".json"
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.entries[file]; ok {
+		return nil, fmt.Errorf("Spec file already exists: %q", file)
+	}
+	entry := newWorkspaceEntry(filepath.Join(ws.Dir, file), NewXSpecWithName(name))
+	ws.entries[file] = entry
+	return entry, nil
+}
+
+// List returns the entries of the workspace, sorted by file name.
+func (ws *Workspace) List() []*WorkspaceEntry {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	entries := make([]*WorkspaceEntry, 0, len(ws.entries))
+	for _, entry := range ws.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].File < entries[j].File
+	})
+	return entries
+}
+
+// Load returns the entry of the provided file, loading its spec if needed.
+func (ws *Workspace) Load(file string) (*WorkspaceEntry, error) {
+	ws.mu.RLock()
+	entry, ok := ws.entries[file]
+	ws.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Spec file not found in workspace: %q", file)
+	}
+	if entry.Spec() != nil {
+		return entry, nil
+	}
+
+	// Loading the packages of the spec can take a while,
+	// so the workspace is not locked meanwhile:
+	spec, err := TryLoadSpecFromFile(entry.Filepath, ws.loader)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading spec %q: %s", file, err)
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.spec == nil {
+		entry.spec = spec
+	}
+	return entry, nil
+}
+
+// Activate loads the spec of the provided file (if needed), and makes it the active one.
+func (ws *Workspace) Activate(file string) (*WorkspaceEntry, error) {
+	entry, err := ws.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.active = file
+	return entry, nil
+}
+
+// Active returns the entry of the active spec, or nil.
+func (ws *Workspace) Active() *WorkspaceEntry {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.entries[ws.active]
+}
+
+// ListLoaded returns the entries whose spec is loaded.
+func (ws *Workspace) ListLoaded() []*WorkspaceEntry {
+	loaded := make([]*WorkspaceEntry, 0)
+	for _, entry := range ws.List() {
+		if entry.Spec() != nil {
+			loaded = append(loaded, entry)
+		}
+	}
+	return loaded
+}
+
+// Save saves the spec of the entry to its file; the caller must hold the
+// lock of the spec. The meta of the spec is removed before saving and added
+// back afterwards (if restoreMeta is true).
+func (entry *WorkspaceEntry) Save(restoreMeta bool) error {
+	spec := entry.Spec()
+	if spec == nil {
+		return nil
+	}
+	spec.RemoveMeta()
+	Infof("Saving spec to %q", MustAbs(entry.Filepath))
+	if err := SaveSpecToFile(spec, entry.Filepath); err != nil {
+		return err
+	}
+	if restoreMeta {
+		return spec.AddMeta()
+	}
+	return nil
+}
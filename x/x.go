@@ -1,6 +1,7 @@
 package x
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -61,6 +62,8 @@ type XSpec struct {
 	Name   string // Name of the module, user-defined.
 	Models []*XModel
 	*sync.RWMutex
+
+	revision uint64 // see Revision.
 }
 
 func (spec *XSpec) NormalizeName() error {
@@ -567,6 +570,11 @@ func (spec *XSpec) HasModelName(name string) bool {
 	spec.RLock()
 	defer spec.RUnlock()
 
+	return spec.hasModelName(name)
+}
+
+// hasModelName is HasModelName for callers that already hold the lock.
+func (spec *XSpec) hasModelName(name string) bool {
 	for _, md := range spec.Models {
 		if md.Name == name {
 			return true
@@ -781,6 +789,8 @@ type FuncQualifier struct {
 	Pos   []bool    // Pos is used depending on the ModelKind.
 	Flows *FlowSpec // The FuncQualifier can either be in Pos mode, or Flow mode; it depends on the ModelKind that will handle it.
 
+	ContentType string `json:",omitempty"` // ContentType is used depending on the ModelKind.
+
 	Name     string                     // Name of the func.
 	Elements *FuncQualifierElementsMeta `json:",omitempty"`
 }
@@ -1226,17 +1236,21 @@ type ModelKindHandler interface {
 	// GenerateCodeQL generates codeql code based on the
 	// provided model; the generated code is then saved in the
 	// destination dir.
-	GenerateCodeQL(impAdder ImportAdder, mdl *XModel, moduleGroup *cqljen.Group) error
+	GenerateCodeQL(ctx *CodeQLContext, mdl *XModel, moduleGroup *cqljen.Group) error
 
 	// GenerateGo generates go code based on the
 	// provided model; the generated code is then saved in the
-	// destination dir.
-	GenerateGo(dir string, mdl *XModel) error
+	// provided OutputFS.
+	GenerateGo(out OutputFS, mdl *XModel) error
 
 	// ScavengeMethods returns an array of initialized
 	// methods unique to the ModelKind.
 	ScavengeMethods() []*XMethod
 
+	// Capabilities returns what the selectors of each
+	// method can select (in the order of ScavengeMethods).
+	Capabilities() []*MethodCapabilities
+
 	// Validate validates the provided XModel.
 	Validate(mdl *XModel) error
 }
@@ -1244,10 +1258,9 @@ type ModelKindHandler interface {
 type PackageLoader func(path string, version string) (*feparser.FEPackage, error)
 
 func TryLoadSpecFromFile(path string, loader PackageLoader) (*XSpec, error) {
-	spec := newXSpec()
-	err := LoadJSON(spec, path)
+	spec, err := ReadSpecFile(path, loader)
 	if err != nil {
-		return nil, fmt.Errorf("error while loading spec file: %s", err)
+		return nil, err
 	}
 	// TODO:
 	// - validate names
@@ -1649,15 +1662,17 @@ func GroupTypeSelectors(mtd *XMethod) (b2typ BasicToTypes, err error) {
 	return
 }
 
+// GetFuncByQualifier returns the func of the qualifier from the cached sources;
+// it fails the generation (see Failf) if it is not found.
 func GetFuncByQualifier(qual *FuncQualifier) FuncInterface {
 	source := GetCachedSource(qual.Path, qual.Version)
 	if source == nil {
-		Fatalf("Source not found: %s@%s", qual.Path, qual.Version)
+		Failf("Source not found: %s@%s", qual.Path, qual.Version)
 	}
 	// Find the func/type-method/interface-method:
 	fn := FindFuncByID(source, qual.ID)
 	if fn == nil {
-		Fatalf("Func not found: %q", qual.ID)
+		Failf("Func not found: %q", qual.ID)
 	}
 	return fn
 }
@@ -1689,21 +1704,20 @@ func FormatDepstubberComment(path string, typeNames []string, funcAndVarNames []
 	))
 }
 
-// SaveGoFile encodes to a file the provided *jen.File.
-func SaveGoFile(outDir string, assetFileName string, file *jen.File) error {
+// SaveGoFile encodes to a file (in the outDir folder of the OutputFS) the provided *jen.File.
+func SaveGoFile(out OutputFS, outDir string, assetFileName string, file *jen.File) error {
 	// Save Go assets:
 	assetFilepath := path.Join(outDir, assetFileName)
 
-	// Create file Golang file:
-	goFile, err := os.Create(assetFilepath)
-	if err != nil {
-		panic(err)
+	// Render generated Golang:
+	var buf bytes.Buffer
+	if err := file.Render(&buf); err != nil {
+		return err
 	}
-	defer goFile.Close()
 
 	// Write generated Golang to file:
-	Infof("Saving Golang assets to %q", MustAbs(assetFilepath))
-	return file.Render(goFile)
+	Infof("Saving Golang assets to %q", assetFilepath)
+	return out.WriteFile(assetFilepath, buf.Bytes())
 }
 
 // WriteGoModFile will generate a go.mod file requiring the provided
@@ -1781,8 +1795,9 @@ func WriteGoModFile(outDir string, pathVersions ...string) error {
 	return nil
 }
 
-// WriteCodeQLTestQuery will write to a file the provided codeql test query.
-func WriteCodeQLTestQuery(outDir string, name string, content string) error {
+// WriteCodeQLTestQuery will write to a file (in the outDir
+// folder of the OutputFS) the provided codeql test query.
+func WriteCodeQLTestQuery(out OutputFS, outDir string, name string, content string) error {
 	{
 		name = strings.TrimSuffix(name, ".ql")
 		name = strings.TrimSuffix(name, ".qll")
@@ -1793,21 +1808,16 @@ func WriteCodeQLTestQuery(outDir string, name string, content string) error {
 	// Save codeql test query:
 	assetFilepath := path.Join(outDir, assetFileName)
 
-	// Create file:
-	file, err := os.Create(assetFilepath)
-	if err != nil {
-		return fmt.Errorf("error while creating file: %s", err)
-	}
-	defer file.Close()
-
 	formatted, err := cqljen.FormatCodeQL([]byte(content))
 	if err != nil {
 		return fmt.Errorf("error while formatting codeql: %s", err)
 	}
 
-	Infof("Saving test query to %q", MustAbs(assetFilepath))
-	_, err = file.Write(formatted)
-	return err
+	Infof("Saving test query to %q", assetFilepath)
+	if err := out.WriteFile(assetFilepath, formatted); err != nil {
+		return fmt.Errorf("error while creating file: %s", err)
+	}
+	return nil
 }
 
 const (
@@ -1815,8 +1825,8 @@ const (
 )
 
 // WriteEmptyCodeQLDotExpectedFile will create an empty <name>.expected file
-// in the specified directory.
-func WriteEmptyCodeQLDotExpectedFile(outDir string, name string) error {
+// in the specified directory of the OutputFS.
+func WriteEmptyCodeQLDotExpectedFile(out OutputFS, outDir string, name string) error {
 	{
 		name = strings.TrimSuffix(name, ".ql")
 		name = strings.TrimSuffix(name, ".qll")
@@ -1828,13 +1838,10 @@ func WriteEmptyCodeQLDotExpectedFile(outDir string, name string) error {
 	assetFilepath := path.Join(outDir, assetFileName)
 
 	// Create file:
-	file, err := os.Create(assetFilepath)
-	if err != nil {
-		panic(err)
+	Infof("Saving %s to %q", assetFileName, assetFilepath)
+	if err := out.WriteFile(assetFilepath, nil); err != nil {
+		return fmt.Errorf("error while creating file: %s", err)
 	}
-	defer file.Close()
-
-	Infof("Saving %s to %q", assetFileName, MustAbs(assetFilepath))
 	return nil
 }
 
@@ -2117,12 +2124,6 @@ func AddImportsFromFunc(file *jen.File, fe FuncInterface) {
 		}
 	}
 }
-func CqlFormatPackagePath(path string) cqljen.Code {
-	if isStd := search.IsStandardImportPath(path); isStd {
-		return cqljen.Lit(path)
-	}
-	return cqljen.Id("package").Call(cqljen.List(cqljen.Lit(path), cqljen.Lit("")))
-}
 func CqlFormatHeaderDoc(modules []*BasicQualifier) []string {
 	if len(modules) == 1 {
 		mod := modules[0]
@@ -2178,7 +2179,7 @@ func HasValidPos(qualifiers ...*FuncQualifier) bool {
 }
 
 // MustPosToRelativeParamIndexes returns the relative parameter indexes given the
-// absolute positions. Panics if a position is not referred to a parameter.
+// absolute positions. Fails the generation (see Failf) if a position is not referred to a parameter.
 func MustPosToRelativeParamIndexes(fe FuncInterface, positions []bool) []int {
 	indexes := make([]int, 0)
 	for posIndex, pos := range positions {
@@ -2188,10 +2189,11 @@ func MustPosToRelativeParamIndexes(fe FuncInterface, positions []bool) []int {
 
 		elTyp, _, relIndex, err := fe.GetRelativeElement(posIndex)
 		if err != nil {
-			Fatalf("Error while GetRelativeElement: %s", err)
+			Failf("Error while GetRelativeElement: %s", err)
 		}
 		if elTyp != feparser.ElementParameter {
-			Fatalf("Is not a parameter")
+			// Should not happen: the selections are checked (see CheckModelCapabilities).
+			Failf("Element %v of func %q is not a parameter", posIndex, fe.GetFunc().Name)
 		}
 
 		indexes = append(indexes, relIndex)